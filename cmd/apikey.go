@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"telegram_server/internal/apikey"
+	"text/tabwriter"
+	"time"
+)
+
+var apiKeyUsage = `usage:
+  apikey create -name NAME -scopes SCOPE[,SCOPE...] [-rate-limit N]
+  apikey list
+  apikey revoke ID
+
+scopes: ` + strings.Join(apikey.Scopes, ", ")
+
+// runAPIKeyCommand runs the apikey subcommand with the arguments following "apikey"
+func runAPIKeyCommand(ctx context.Context, keys apikey.Manager, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		name := fs.String("name", "", "who the key is issued to")
+		scopes := fs.String("scopes", "", "comma separated scopes")
+		rateLimit := fs.Int("rate-limit", 60, "requests per minute, 0 for no limit")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		var scopeList []string
+		for _, scope := range strings.Split(*scopes, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopeList = append(scopeList, scope)
+			}
+		}
+		raw, key, err := keys.Create(ctx, *name, scopeList, *rateLimit)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Created API key %d for %s, store it now, it is not shown again:\n%s\n", key.ID, key.Name, raw)
+		return nil
+
+	case "list":
+		list, err := keys.List(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tRATE LIMIT\tCREATED\tLAST USED\tREVOKED")
+		for _, key := range list {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.RateLimit,
+				key.CreatedAt.Format(time.RFC3339), formatOptionalTime(key.LastUsedAt), formatOptionalTime(key.RevokedAt))
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", apiKeyUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q", args[1])
+		}
+		revoked, err := keys.Revoke(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return fmt.Errorf("no active API key with id %d", id)
+		}
+		fmt.Fprintf(out, "Revoked API key %d\n", id)
+		return nil
+	}
+	return fmt.Errorf("unknown apikey command %q\n%s", args[0], apiKeyUsage)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"telegram_server/internal/export"
+	"time"
+)
+
+var exportUsage = `usage:
+  export [-format FORMAT] [-from TIME] [-to TIME] [-chat ID] [-user NAME] [-after-id ID] [-gzip] [-o FILE]
+
+formats: ` + strings.Join(export.Formats, ", ") + `
+times are RFC 3339 or dates, an interrupted export is resumed with -after-id set to the last id reported`
+
+// runExportCommand runs the export subcommand with the arguments following
+// "export". The export goes to -o or to out, the summary to status.
+func runExportCommand(ctx context.Context, exporter export.Exporter, args []string, out, status io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(status)
+	fs.Usage = func() { fmt.Fprintln(status, exportUsage) }
+	format := fs.String("format", export.FormatCSV, "output format")
+	from := fs.String("from", "", "first creation time to export")
+	to := fs.String("to", "", "creation time to stop before")
+	chatID := fs.Int64("chat", 0, "only messages of this chat")
+	user := fs.String("user", "", "only messages of this username")
+	afterID := fs.Int64("after-id", 0, "only messages with a larger id")
+	gzip := fs.Bool("gzip", false, "compress the output")
+	output := fs.String("o", "", "output file, standard output by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%s", exportUsage)
+	}
+
+	// Flags are checked like the query of GET /messages/export
+	query := url.Values{"format": {*format}, "username": {*user}, "from": {*from}, "to": {*to}}
+	if *chatID != 0 {
+		query.Set("chat_id", strconv.FormatInt(*chatID, 10))
+	}
+	if *afterID != 0 {
+		query.Set("after_id", strconv.FormatInt(*afterID, 10))
+	}
+	if *gzip {
+		query.Set("compress", "gzip")
+	}
+	opts, fields := export.ParseQuery(query)
+	if fields != nil {
+		var problems []string
+		for _, f := range fields {
+			problems = append(problems, f.Field+" "+f.Message)
+		}
+		return fmt.Errorf("invalid export: %s", strings.Join(problems, ", "))
+	}
+
+	var file *os.File
+	if *output != "" {
+		var err error
+		if file, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	start := time.Now()
+	summary, err := exporter.Export(ctx, out, opts)
+	if err != nil {
+		if summary.LastID > 0 {
+			return fmt.Errorf("export stopped after %d messages, resume with -after-id %d: %w", summary.Count, summary.LastID, err)
+		}
+		return err
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(status, "Exported %d messages in %s, last id %d\n", summary.Count, time.Since(start).Round(time.Millisecond), summary.LastID)
+	return nil
+}
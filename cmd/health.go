@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"telegram_server/internal/bot"
+	"telegram_server/internal/database"
+	"telegram_server/internal/health"
+	"telegram_server/internal/logger"
+	"time"
+)
+
+// webhookErrorWindow is how long a failed delivery reported by getWebhookInfo
+// keeps the webhook check failing
+const webhookErrorWindow = 10 * time.Minute
+
+// newHealthChecker registers the readiness checks: the database is required,
+// the logger and Telegram only degrade the server when they fail since no
+// other replica would do better. b is nil when the bot could not be created.
+func newHealthChecker(l logger.Logger, db database.Database, b bot.Bot) (health.Checker, error) {
+	checker, err := health.NewChecker(health.Config{Logger: l})
+	if err != nil {
+		return nil, err
+	}
+
+	checker.Register(health.Component{Name: "database", Check: db.PingContext})
+	checker.Register(health.Component{Name: "logger", Optional: true, Check: func(ctx context.Context) error {
+		stats := l.Stats()
+		switch {
+		case stats.Workers == 0:
+			return errors.New("no log worker is running")
+		case stats.Queued >= stats.Capacity:
+			return fmt.Errorf("log queue is full, %d logs dropped so far", stats.Dropped)
+		}
+		return nil
+	}})
+	if b == nil {
+		return checker, nil
+	}
+
+	checker.Register(health.Component{Name: "telegram", Optional: true, Check: func(ctx context.Context) error {
+		_, err := b.GetMe(ctx)
+		return err
+	}})
+	checker.Register(health.Component{Name: "webhook", Optional: true, Check: func(ctx context.Context) error {
+		info, err := b.GetWebhookInfo(ctx)
+		if err != nil {
+			return err
+		}
+		if info.URL == "" {
+			return errors.New("webhook is not set")
+		}
+		if info.LastErrorDate > 0 {
+			failedAt := time.Unix(int64(info.LastErrorDate), 0)
+			if since := time.Since(failedAt); since < webhookErrorWindow {
+				return fmt.Errorf("delivery failed %s ago with %d updates pending: %s",
+					since.Truncate(time.Second), info.PendingUpdateCount, info.LastErrorMessage)
+			}
+		}
+		return nil
+	}})
+	return checker, nil
+}
@@ -2,17 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"telegram_server/internal/admin"
+	"telegram_server/internal/apikey"
 	"telegram_server/internal/app"
 	"telegram_server/internal/bot"
+	"telegram_server/internal/crashreport"
 	"telegram_server/internal/database"
+	"telegram_server/internal/export"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/idempotency"
+	"telegram_server/internal/jwtauth"
 	"telegram_server/internal/logger"
 	"telegram_server/internal/models"
+	"telegram_server/internal/operator"
 	"telegram_server/internal/router"
 	"telegram_server/internal/server"
+	"telegram_server/internal/session"
+	"telegram_server/internal/stream"
+	"telegram_server/internal/tgauth"
+	"telegram_server/internal/tracing"
+	"telegram_server/internal/webhook"
 	"time"
 )
 
@@ -29,7 +46,7 @@ type Logger interface {
 
 type Database interface {
 	Connect() error
-	SaveMessage(ctx context.Context, username, text string) error
+	SaveMessage(ctx context.Context, username, text string) (models.Message, error)
 	GetMessages(ctx context.Context) ([]models.Message, error)
 	CloseDB()
 }
@@ -42,8 +59,7 @@ func main() {
 		BufferSize:  1000,
 		LogFileName: "server.log",
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// The workers write logs until Close, a deadline here would stop them
 	appLogger := logger.NewLogger(loggerConfig)
 	err := appLogger.Start(ctx)
 	if err != nil {
@@ -53,11 +69,36 @@ func main() {
 	defer appLogger.Close()
 	appLogger.LogEvent("Logger initialized successfully")
 
+	// Spans are exported when an OTLP endpoint is configured, the trace context
+	// of callers is passed on either way
+	tracing.SetPropagator()
+	var appTracing tracing.Tracing
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		sampleRatio, _ := strconv.ParseFloat(os.Getenv("TRACING_SAMPLE_RATIO"), 64)
+		appTracing, err = tracing.NewTracing(ctx, tracing.Config{
+			Logger:      appLogger,
+			ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+			SampleRatio: sampleRatio,
+		})
+		if err != nil {
+			appLogger.LogEvent("Failed to set up tracing: " + err.Error())
+		} else {
+			defer func() {
+				flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				appTracing.Shutdown(flushCtx)
+			}()
+		}
+	}
+
 	dbConfig := database.Config{
-		DBName:  "botdb",
-		Logger:  appLogger,
-		WithSSL: false,
+		DBName:       "botdb",
+		Logger:       appLogger,
+		WithSSL:      false,
+		SearchConfig: os.Getenv("SEARCH_CONFIG"),
 	}
+	// DB_MAX_CONNS sizes the pool, raise it with EXPORT_MAX_CONCURRENT
+	dbConfig.MaxConns, _ = strconv.Atoi(os.Getenv("DB_MAX_CONNS"))
 
 	db, err := database.NewDatabase(dbConfig)
 	if err != nil {
@@ -71,9 +112,123 @@ func main() {
 		os.Exit(1)
 	}
 
+	apiKeys, err := apikey.NewManager(apikey.Config{
+		Logger:   appLogger,
+		Database: db,
+	})
+	if err != nil {
+		appLogger.LogEvent("Failed to create API key manager: " + err.Error())
+		db.CloseDB()
+		os.Exit(1)
+	}
+
+	// apikey subcommand manages keys and exits without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		err := runAPIKeyCommand(context.Background(), apiKeys, os.Args[2:], os.Stdout)
+		db.CloseDB()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// search-config subcommand changes the search configuration and exits, the
+	// server indexes the messages again
+	if len(os.Args) > 1 && os.Args[1] == "search-config" {
+		err := runSearchConfigCommand(context.Background(), db, os.Args[2:], os.Stdout)
+		db.CloseDB()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	maxExports, _ := strconv.Atoi(os.Getenv("EXPORT_MAX_CONCURRENT"))
+	exporter, err := export.NewExporter(export.Config{
+		Logger:        appLogger,
+		Database:      db,
+		MaxConcurrent: maxExports,
+	})
+	if err != nil {
+		appLogger.LogEvent("Failed to create message exporter: " + err.Error())
+		db.CloseDB()
+		os.Exit(1)
+	}
+
+	// export subcommand writes messages to a file or standard output and exits
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		exportCtx, stopExport := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := runExportCommand(exportCtx, exporter, os.Args[2:], os.Stdout, os.Stderr)
+		stopExport()
+		db.CloseDB()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	middleware := []func(http.Handler) http.Handler{apiKeys.Middleware}
+	tokens, err := newJWTAuthenticator(appLogger)
+	if err != nil {
+		appLogger.LogEvent("Failed to create JWT authentication: " + err.Error())
+		db.CloseDB()
+		os.Exit(1)
+	}
+	if tokens != nil {
+		middleware = append(middleware, tokens.Middleware)
+	}
+	services := authorizer{keys: apiKeys, tokens: tokens}
+
+	// Imports are limited by BATCH_MAX_BODY_BYTES, other requests keep the 1MB default
+	batchBodyLimit := 64 << 20
+	if n, err := strconv.Atoi(os.Getenv("BATCH_MAX_BODY_BYTES")); err == nil && n > 0 {
+		batchBodyLimit = n
+	}
+
+	appMetrics := newServerMetrics(db.PoolStats, appLogger.Stats)
+
+	// Probes and scrapes are left out of the access log unless ACCESS_LOG_EXCLUDE says otherwise
+	accessLog := &server.AccessLogConfig{ExcludePaths: []string{"/healthz", "/readyz", "/metrics"}}
+	if exclude, ok := os.LookupEnv("ACCESS_LOG_EXCLUDE"); ok {
+		accessLog.ExcludePaths = strings.FieldsFunc(exclude, func(r rune) bool { return r == ',' })
+	}
+	if rate, err := strconv.ParseFloat(os.Getenv("ACCESS_LOG_SAMPLE_RATE"), 64); err == nil {
+		accessLog.SampleRate = rate
+	}
+
+	rateLimits, webAppRateLimit, err := newRateLimits(db)
+	if err != nil {
+		appLogger.LogEvent("Failed to set up rate limits: " + err.Error())
+		db.CloseDB()
+		os.Exit(1)
+	}
+	webAppLimiter := server.NewRateLimiter(rateLimits.Store, appLogger)
+
 	srvConfig := server.Config{
-		Port:   "8080",
-		Logger: appLogger,
+		Port:       "8080",
+		Logger:     appLogger,
+		Middleware: middleware,
+		BodyLimits: map[string]int{"POST /messages:batch": batchBodyLimit},
+		Metrics:    appMetrics,
+		AccessLog:  accessLog,
+		RateLimit:  rateLimits,
+	}
+
+	// Panics are always logged, CRASH_REPORT_FILE and CRASH_REPORT_URL send them on
+	if os.Getenv("CRASH_REPORT_FILE") != "" || os.Getenv("CRASH_REPORT_URL") != "" {
+		reporter, err := crashreport.NewReporter(crashreport.Config{
+			File:  os.Getenv("CRASH_REPORT_FILE"),
+			URL:   os.Getenv("CRASH_REPORT_URL"),
+			Token: os.Getenv("CRASH_REPORT_TOKEN"),
+		})
+		if err != nil {
+			appLogger.LogEvent("Failed to create crash reporter: " + err.Error())
+		} else {
+			srvConfig.ErrorReporter = reporter
+		}
 	}
 
 	httpSrv, err := server.NewHttpServer(srvConfig)
@@ -96,14 +251,167 @@ func main() {
 		os.Exit(1)
 	}
 
+	messageStream, err := stream.NewHub(stream.Config{
+		Logger:   appLogger,
+		Database: db,
+	})
+	if err != nil {
+		db.CloseDB()
+		appLogger.LogEvent("Failed to create message stream: " + err.Error())
+		os.Exit(1)
+	}
+
 	newBot, err := bot.NewBot(appLogger, db)
 	if err != nil {
 		appLogger.LogEvent("Failed to create bot: " + err.Error())
 		newBot = nil
 	}
 
-	httpSrv.SetHandler("/ping", newRouter.PingHandler)
-	httpSrv.SetHandler("/message", newRouter.MessageHandler)
+	webhooks, err := webhook.NewDispatcher(webhook.Config{
+		Logger:   appLogger,
+		Database: db,
+	})
+	if err != nil {
+		db.CloseDB()
+		appLogger.LogEvent("Failed to create webhook dispatcher: " + err.Error())
+		os.Exit(1)
+	}
+	newRouter.SetNotifier(webhooks)
+	if newBot != nil {
+		newBot.SetNotifier(webhooks)
+		newBot.SetMetrics(appMetrics)
+	}
+
+	botCtx, stopBot := context.WithCancel(context.Background())
+	defer stopBot()
+
+	// Stopping the bot context also ends the message streams before the server shuts down
+	go messageStream.Run(botCtx)
+	go webhooks.Run(botCtx)
+	go runSearchIndexer(botCtx, db, appLogger, time.Minute)
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		go runRateLimitCleanup(botCtx, db, appLogger, 10*time.Minute)
+	}
+
+	spec, err := newAPISpec()
+	if err != nil {
+		appLogger.LogEvent("Failed to build OpenAPI document: " + err.Error())
+	} else {
+		httpSrv.SetHandler("GET /openapi.json", spec.Handler)
+	}
+
+	httpSrv.SetHandler("GET /ping", newRouter.PingHandler)
+
+	checker, err := newHealthChecker(appLogger, db, newBot)
+	if err != nil {
+		db.CloseDB()
+		appLogger.LogEvent("Failed to create health checks: " + err.Error())
+		os.Exit(1)
+	}
+	httpSrv.SetHandler("GET /healthz", checker.LiveHandler)
+	httpSrv.SetHandler("GET /readyz", checker.ReadyHandler)
+	httpSrv.SetHandler("GET /metrics", appMetrics.registry.Handler)
+	messageHandler := newRouter.MessageHandler
+	idempotencyKeys, err := idempotency.NewIdempotency(idempotency.Config{
+		Logger:   appLogger,
+		Database: db,
+		Scope:    requestActor,
+	})
+	if err != nil {
+		appLogger.LogEvent("Failed to create idempotency keys: " + err.Error())
+	} else {
+		go idempotencyKeys.RunCleanup(botCtx, time.Hour)
+		messageHandler = idempotencyKeys.Wrap(messageHandler)
+	}
+	httpSrv.SetHandler("POST /message", services.require("", models.ScopeMessagesWrite, messageHandler))
+	httpSrv.SetHandler("POST /messages:batch", services.require("", models.ScopeMessagesWrite, newRouter.BatchMessagesHandler))
+
+	// Dashboard sessions need the bot for the Login Widget, the routes for
+	// service clients work with API keys and tokens without them
+	var sessions session.Manager
+	if newBot != nil {
+		go newBot.RunCleanup(botCtx, time.Minute)
+		httpSrv.SetHandler("POST /webhook", newBot.WebHookHandler)
+
+		miniApp, err := tgauth.NewInitDataAuth(tgauth.InitDataConfig{
+			BotToken: newBot.Token(),
+			Logger:   appLogger,
+		})
+		if err != nil {
+			appLogger.LogEvent("Failed to create Mini App authentication: " + err.Error())
+		} else {
+			httpSrv.SetHandler("GET /webapp/me", miniApp.Middleware(newRouter.WebAppMeHandler))
+			httpSrv.SetHandler("GET /webapp/messages", miniApp.Middleware(newRouter.WebAppMessagesHandler))
+			httpSrv.SetHandler("POST /webapp/messages", miniApp.Middleware(webAppLimiter.Limit(webAppRateLimit, newRouter.WebAppPostMessageHandler)))
+		}
+
+		sessions, err = newSessionManager(newBot.Token(), appLogger, db)
+		if err != nil {
+			appLogger.LogEvent("Failed to create session manager: " + err.Error())
+			sessions = nil
+		} else {
+			go sessions.RunCleanup(botCtx, time.Hour)
+
+			httpSrv.SetHandler("GET /auth/telegram", sessions.LoginHandler)
+			httpSrv.SetHandler("POST /auth/logout", sessions.LogoutHandler)
+			httpSrv.SetHandler("GET /auth/me", sessions.RequireRole(models.RoleViewer, sessions.MeHandler))
+		}
+	}
+
+	// sessions is nil without the bot or when it failed, then only API keys and tokens are accepted
+	staff := authorizer{keys: apiKeys, tokens: tokens, sessions: sessions}.require
+
+	httpSrv.SetHandler("GET /messages", staff(models.RoleViewer, models.ScopeMessagesRead, newRouter.ListMessagesHandler))
+	httpSrv.SetHandler("GET /messages/stream", staff(models.RoleViewer, models.ScopeMessagesRead, messageStream.SSEHandler))
+	httpSrv.SetHandler("GET /messages/ws", staff(models.RoleViewer, models.ScopeMessagesRead, messageStream.WebSocketHandler))
+	httpSrv.SetHandler("GET /messages/search", staff(models.RoleViewer, models.ScopeMessagesRead, newRouter.SearchMessagesHandler))
+	httpSrv.SetHandler("GET /messages/export", staff(models.RoleViewer, models.ScopeMessagesRead, exporter.Handler))
+	httpSrv.SetHandler("GET /messages/{id}", staff(models.RoleViewer, models.ScopeMessagesRead, newRouter.GetMessageHandler))
+	httpSrv.SetHandler("PATCH /messages/{id}", staff(models.RoleAdmin, models.ScopeMessagesWrite, newRouter.UpdateMessageHandler))
+	httpSrv.SetHandler("DELETE /messages/{id}", staff(models.RoleAdmin, models.ScopeMessagesWrite, newRouter.DeleteMessageHandler))
+	httpSrv.SetHandler("GET /chats/{chatID}/messages", staff(models.RoleViewer, models.ScopeMessagesRead, newRouter.TranscriptHandler))
+	httpSrv.SetHandler("GET /polls/{pollID}/results", staff(models.RoleViewer, models.ScopeAdmin, newRouter.PollResultsHandler))
+	httpSrv.SetHandler("GET /polls/{pollID}/export", staff(models.RoleViewer, models.ScopeAdmin, newRouter.PollExportHandler))
+
+	httpSrv.SetHandler("GET /admin/webhooks", staff(models.RoleAdmin, models.ScopeAdmin, webhooks.ListSubscriptionsHandler))
+	httpSrv.SetHandler("POST /admin/webhooks", staff(models.RoleAdmin, models.ScopeAdmin, webhooks.CreateSubscriptionHandler))
+	httpSrv.SetHandler("DELETE /admin/webhooks/{id}", staff(models.RoleAdmin, models.ScopeAdmin, webhooks.DeleteSubscriptionHandler))
+	httpSrv.SetHandler("GET /admin/webhooks/dead-letters", staff(models.RoleAdmin, models.ScopeAdmin, webhooks.DeadLettersHandler))
+	httpSrv.SetHandler("POST /admin/webhooks/deliveries/{id}/replay", staff(models.RoleAdmin, models.ScopeAdmin, webhooks.ReplayHandler))
+
+	// Sending and operator handoff talk to Telegram
+	if newBot != nil {
+		sender, err := admin.NewSender(admin.Config{
+			Logger:   appLogger,
+			Database: db,
+			Bot:      newBot,
+			Actor:    requestActor,
+		})
+		if err != nil {
+			appLogger.LogEvent("Failed to create admin sender: " + err.Error())
+		} else {
+			httpSrv.SetHandler("POST /admin/send", staff(models.RoleAdmin, models.ScopeAdmin, sender.SendHandler))
+		}
+
+		operatorChatID, _ := strconv.ParseInt(os.Getenv("OPERATOR_CHAT_ID"), 10, 64)
+		newOperator, err := operator.NewOperator(operator.Config{
+			OperatorChatID: operatorChatID,
+			Logger:         appLogger,
+			Database:       db,
+			Bot:            newBot,
+		})
+		if err != nil {
+			appLogger.LogEvent("Failed to create operator handoff: " + err.Error())
+		} else {
+			newBot.SetHandoff(newOperator)
+			go newOperator.RunAutoRelease(botCtx, time.Minute)
+
+			httpSrv.SetHandler("GET /operator/chats", staff(models.RoleViewer, models.ScopeAdmin, newOperator.ListChatsHandler))
+			httpSrv.SetHandler("POST /operator/chats/{chatID}/handoff", staff(models.RoleAdmin, models.ScopeAdmin, newOperator.HandoffHandler))
+			httpSrv.SetHandler("DELETE /operator/chats/{chatID}/handoff", staff(models.RoleAdmin, models.ScopeAdmin, newOperator.ReleaseHandler))
+			httpSrv.SetHandler("POST /operator/chats/{chatID}/reply", staff(models.RoleAdmin, models.ScopeAdmin, newOperator.ReplyHandler))
+		}
+	}
 
 	cfg := app.Config{
 		Logger:     appLogger,
@@ -115,6 +423,9 @@ func main() {
 
 	application := app.NewApp(cfg)
 
+	// SHUTDOWN_DRAIN_DELAY is how long readiness fails before shutting down, e.g. 5s
+	drainDelay, _ := time.ParseDuration(os.Getenv("SHUTDOWN_DRAIN_DELAY"))
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -124,6 +435,11 @@ func main() {
 		sig := <-sigChan
 		appLogger.LogEvent("Received signal: " + sig.String())
 
+		// Load balancers see /readyz fail and stop sending requests before the server stops
+		checker.Shutdown()
+		time.Sleep(drainDelay)
+		stopBot()
+
 		shutdownCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 		defer cancel()
 
@@ -137,3 +453,104 @@ func main() {
 	<-done
 	appLogger.LogEvent("Application's shutted down successfully")
 }
+
+// authorizer checks access the way a request authenticated: with an API key,
+// a JWT bearer token or a dashboard session cookie.
+type authorizer struct {
+	keys     apikey.Manager
+	tokens   jwtauth.Authenticator // nil when JWT authentication is not configured
+	sessions session.Manager       // nil on routes closed to dashboard users and without sessions
+}
+
+// require lets through API keys and tokens granting scope and sessions with at least role
+func (a authorizer) require(role, scope string, h http.HandlerFunc) http.HandlerFunc {
+	byKey := a.keys.RequireScope(scope, h)
+	var byToken, bySession http.HandlerFunc
+	if a.tokens != nil {
+		byToken = a.tokens.RequireScope(scope, h)
+	}
+	if a.sessions != nil {
+		bySession = a.sessions.RequireRole(role, h)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := apikey.FromContext(r.Context()); ok {
+			byKey(w, r)
+			return
+		}
+		if _, ok := jwtauth.FromContext(r.Context()); ok && byToken != nil {
+			byToken(w, r)
+			return
+		}
+		if bySession != nil {
+			bySession(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "authentication required")
+	}
+}
+
+// requestActor names who made an authenticated request for the audit log
+func requestActor(r *http.Request) string {
+	if key, ok := apikey.FromContext(r.Context()); ok {
+		return "apikey:" + key.Name
+	}
+	if claims, ok := jwtauth.FromContext(r.Context()); ok {
+		return "jwt:" + claims.Subject
+	}
+	if s, ok := session.FromContext(r.Context()); ok {
+		return "telegram:" + strconv.FormatInt(s.UserID, 10)
+	}
+	return "anonymous"
+}
+
+// newJWTAuthenticator validates bearer tokens issued by JWT_ISSUER for
+// JWT_AUDIENCE with keys from JWT_JWKS_URL or JWT_JWKS_FILE. It returns nil
+// when JWT_ISSUER is not set.
+func newJWTAuthenticator(l jwtauth.Logger) (jwtauth.Authenticator, error) {
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	return jwtauth.NewAuthenticator(jwtauth.Config{
+		JWKSURL:  os.Getenv("JWT_JWKS_URL"),
+		JWKSFile: os.Getenv("JWT_JWKS_FILE"),
+		Issuer:   issuer,
+		Audience: os.Getenv("JWT_AUDIENCE"),
+		Logger:   l,
+	})
+}
+
+// newSessionManager creates dashboard sessions for users listed in DASHBOARD_ADMINS
+// and DASHBOARD_VIEWERS. Cookies are signed with SESSION_SECRET, without it a random
+// secret is used and sessions do not survive a restart.
+func newSessionManager(botToken string, l session.Logger, db session.Database) (session.Manager, error) {
+	roles, err := session.ParseRoles(os.Getenv("DASHBOARD_ADMINS"), os.Getenv("DASHBOARD_VIEWERS"))
+	if err != nil {
+		return nil, err
+	}
+
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(secret) == 0 {
+		l.LogEvent("SESSION_SECRET is not set, using a random secret")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	loginWidget, err := tgauth.NewLoginWidget(tgauth.LoginWidgetConfig{BotToken: botToken})
+	if err != nil {
+		return nil, err
+	}
+
+	return session.NewManager(session.Config{
+		Secret:      secret,
+		Secure:      os.Getenv("SESSION_INSECURE") == "",
+		Roles:       roles,
+		LoginWidget: loginWidget,
+		Logger:      l,
+		Database:    db,
+	})
+}
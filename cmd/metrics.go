@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"telegram_server/internal/logger"
+	"telegram_server/internal/metrics"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// serverMetrics are the metrics served on GET /metrics. It is the Metrics of
+// the HTTP server and of the bot, pool and logger stats are read on scrape.
+type serverMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	panics          *metrics.CounterVec
+	updates         *metrics.CounterVec
+	apiCalls        *metrics.CounterVec
+	apiDuration     *metrics.HistogramVec
+}
+
+func newServerMetrics(poolStats func() models.PoolStats, logStats func() logger.Stats) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		requests: r.Counter("http_requests_total",
+			"HTTP requests by route pattern, method and status code.", "route", "method", "code"),
+		requestDuration: r.Histogram("http_request_duration_seconds",
+			"HTTP request latency by route pattern and method.", metrics.DefaultBuckets, "route", "method"),
+		panics: r.Counter("http_panics_total",
+			"Handlers that panicked by route pattern.", "route"),
+		updates: r.Counter("telegram_updates_total",
+			"Webhook updates received from Telegram by type.", "type"),
+		apiCalls: r.Counter("telegram_api_calls_total",
+			"Bot API calls by method and result, the result is ok, the Telegram error code or error.", "method", "result"),
+		apiDuration: r.Histogram("telegram_api_call_duration_seconds",
+			"Bot API call latency by method.", metrics.DefaultBuckets, "method"),
+	}
+
+	r.GaugeFunc("db_pool_acquired_connections", "Connections in use.",
+		func() float64 { return float64(poolStats().AcquiredConns) })
+	r.GaugeFunc("db_pool_idle_connections", "Idle connections.",
+		func() float64 { return float64(poolStats().IdleConns) })
+	r.GaugeFunc("db_pool_total_connections", "Open connections.",
+		func() float64 { return float64(poolStats().TotalConns) })
+	r.GaugeFunc("db_pool_max_connections", "Largest number of connections of the pool.",
+		func() float64 { return float64(poolStats().MaxConns) })
+	r.CounterFunc("db_pool_acquires_total", "Connections acquired from the pool.",
+		func() float64 { return float64(poolStats().AcquireCount) })
+	r.CounterFunc("db_pool_empty_acquires_total", "Acquires that waited because no connection was idle.",
+		func() float64 { return float64(poolStats().EmptyAcquireCount) })
+	r.CounterFunc("db_pool_canceled_acquires_total", "Acquires canceled before they got a connection.",
+		func() float64 { return float64(poolStats().CanceledAcquireCount) })
+	r.CounterFunc("db_pool_acquire_wait_seconds_total", "Time spent acquiring connections.",
+		func() float64 { return poolStats().AcquireDuration.Seconds() })
+
+	r.GaugeFunc("logger_queue_depth", "Log lines waiting to be written.",
+		func() float64 { return float64(logStats().Queued) })
+	r.GaugeFunc("logger_queue_capacity", "Size of the log queue.",
+		func() float64 { return float64(logStats().Capacity) })
+	r.CounterFunc("logger_dropped_total", "Log lines dropped because the queue was full or the logger stopped.",
+		func() float64 { return float64(logStats().Dropped) })
+
+	return m
+}
+
+// ObserveRequest implements server.Metrics. Requests matching no route are
+// counted under "unmatched" and unusual methods under "OTHER" so scanners
+// cannot add series.
+func (m *serverMetrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+	m.requests.Inc(route, method, strconv.Itoa(status))
+	m.requestDuration.Observe(duration.Seconds(), route, method)
+}
+
+// PanicRecovered implements server.Metrics
+func (m *serverMetrics) PanicRecovered(route string) {
+	if route == "" {
+		route = "unmatched"
+	}
+	m.panics.Inc(route)
+}
+
+// UpdateReceived implements bot.Metrics
+func (m *serverMetrics) UpdateReceived(updateType string) {
+	m.updates.Inc(updateType)
+}
+
+// APICall implements bot.Metrics
+func (m *serverMetrics) APICall(method, result string, duration time.Duration) {
+	m.apiCalls.Inc(method, result)
+	m.apiDuration.Observe(duration.Seconds(), method)
+}
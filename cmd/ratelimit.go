@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"telegram_server/internal/apikey"
+	"telegram_server/internal/database"
+	"telegram_server/internal/jwtauth"
+	"telegram_server/internal/logger"
+	"telegram_server/internal/server"
+	"telegram_server/internal/tgauth"
+	"time"
+)
+
+// newRateLimits sets the HTTP rate limits from the environment. Policies are
+// written as requests per window, e.g. "60/1m", "off" turns one off:
+//
+//	RATE_LIMIT_DEFAULT  every route per IP address, 600/1m
+//	RATE_LIMIT_MESSAGE  POST /message per API key or token, 60/1m
+//	RATE_LIMIT_WEBAPP   POST /webapp/messages per Telegram user, 30/1m
+//
+// Buckets are kept in memory unless RATE_LIMIT_STORE=postgres shares them
+// between the replicas. The Mini App policy is returned apart, the user is
+// known only inside the route.
+func newRateLimits(db database.Database) (*server.RateLimitConfig, server.RateLimitPolicy, error) {
+	var store server.RateLimitStore = server.NewMemoryRateLimitStore()
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+	case "postgres":
+		store = db
+	default:
+		return nil, server.RateLimitPolicy{}, fmt.Errorf("unknown rate limit store %q", os.Getenv("RATE_LIMIT_STORE"))
+	}
+
+	def, err := ratePolicy("RATE_LIMIT_DEFAULT", "600/1m")
+	if err != nil {
+		return nil, server.RateLimitPolicy{}, err
+	}
+	message, err := ratePolicy("RATE_LIMIT_MESSAGE", "60/1m")
+	if err != nil {
+		return nil, server.RateLimitPolicy{}, err
+	}
+	webApp, err := ratePolicy("RATE_LIMIT_WEBAPP", "30/1m")
+	if err != nil {
+		return nil, server.RateLimitPolicy{}, err
+	}
+	message.Key = keyByCredentials
+	webApp.Name = "webapp"
+	webApp.Key = keyByTelegramUser
+
+	cfg := &server.RateLimitConfig{
+		Store: store,
+		// Probes, scrapes and Telegram itself are never limited
+		Routes: map[string]server.RateLimitPolicy{
+			"POST /message": message,
+			"GET /healthz":  {},
+			"GET /readyz":   {},
+			"GET /metrics":  {},
+			"POST /webhook": {},
+		},
+	}
+	if def.Limit > 0 {
+		def.Key = server.KeyByIP
+		cfg.Default = &def
+	}
+	return cfg, webApp, nil
+}
+
+// ratePolicy parses the policy in the environment variable name, or fallback when it is not set
+func ratePolicy(name, fallback string) (server.RateLimitPolicy, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		value = fallback
+	}
+	if value == "off" {
+		return server.RateLimitPolicy{}, nil
+	}
+	requests, window, found := strings.Cut(value, "/")
+	limit, err := strconv.Atoi(requests)
+	if !found || err != nil || limit <= 0 {
+		return server.RateLimitPolicy{}, fmt.Errorf("%s: expected requests/window, got %q", name, value)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return server.RateLimitPolicy{}, fmt.Errorf("%s: invalid window %q", name, window)
+	}
+	return server.RateLimitPolicy{Limit: limit, Window: d}, nil
+}
+
+// keyByCredentials names callers by their API key or token, anonymous ones by IP address
+func keyByCredentials(r *http.Request) string {
+	if key, ok := apikey.FromContext(r.Context()); ok {
+		return "apikey:" + strconv.FormatInt(key.ID, 10)
+	}
+	if claims, ok := jwtauth.FromContext(r.Context()); ok {
+		return "jwt:" + claims.Subject
+	}
+	return server.KeyByIP(r)
+}
+
+// keyByTelegramUser names Mini App callers by their Telegram user
+func keyByTelegramUser(r *http.Request) string {
+	if user, ok := tgauth.UserFromContext(r.Context()); ok {
+		return "telegram:" + strconv.FormatInt(user.ID, 10)
+	}
+	return server.KeyByIP(r)
+}
+
+// runRateLimitCleanup deletes full buckets from the database every interval until ctx is done
+func runRateLimitCleanup(ctx context.Context, db database.Database, l logger.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := db.DeleteFullRateLimitBuckets(ctx)
+			if err != nil {
+				l.LogEvent("Error while deleting full rate limit buckets: " + err.Error())
+				continue
+			}
+			if deleted > 0 {
+				l.LogEvent("Deleted " + strconv.FormatInt(deleted, 10) + " full rate limit buckets")
+			}
+		}
+	}
+}
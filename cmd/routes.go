@@ -0,0 +1,581 @@
+package main
+
+import (
+	"net/http"
+	"telegram_server/internal/admin"
+	"telegram_server/internal/export"
+	"telegram_server/internal/health"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/idempotency"
+	"telegram_server/internal/models"
+	"telegram_server/internal/openapi"
+	"telegram_server/internal/operator"
+	"telegram_server/internal/router"
+	"telegram_server/internal/tgauth"
+	"telegram_server/internal/webhook"
+)
+
+// Documentation of every pattern registered in main, routes_test.go fails when
+// a registered pattern is missing here.
+
+const (
+	securitySession  = "session"
+	securityInitData = "telegramInitData"
+	securityAPIKey   = "apiKey"
+	securityBearer   = "bearerJWT"
+)
+
+var (
+	integerSchema = &openapi.Schema{Type: "integer", Format: "int64"}
+	textSchema    = &openapi.Schema{Type: "string"}
+)
+
+// errorResponse documents the router error envelope
+func errorResponse(status int) openapi.Response {
+	return openapi.Response{Status: status}
+}
+
+// staffErrors adds the answers of rejected sessions and API keys
+func staffErrors(responses ...openapi.Response) []openapi.Response {
+	return append(responses,
+		errorResponse(http.StatusUnauthorized),
+		errorResponse(http.StatusForbidden),
+		errorResponse(http.StatusTooManyRequests),
+	)
+}
+
+func apiRoutes() []openapi.Route {
+	messageID := openapi.Param{Name: "id", In: "path", Schema: integerSchema}
+	chatID := openapi.Param{Name: "chatID", In: "path", Description: "Telegram chat id", Schema: integerSchema}
+	streamParams := []openapi.Param{
+		{Name: "chat_id", In: "query", Schema: integerSchema},
+		{Name: "username", In: "query"},
+		{Name: "Last-Event-ID", In: "header", Description: "Id of the last message received", Schema: integerSchema},
+		{Name: "last_event_id", In: "query", Description: "Last-Event-ID for clients that cannot set headers", Schema: integerSchema},
+	}
+
+	return []openapi.Route{
+		{
+			Pattern: "GET /openapi.json",
+			Summary: "This document",
+			Tags:    []string{"meta"},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: &openapi.Schema{Type: "object"}},
+			},
+		},
+		{
+			Pattern: "GET /ping",
+			Summary: "Server check",
+			Tags:    []string{"meta"},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: struct {
+					Message string `json:"message"`
+				}{}},
+			},
+		},
+		{
+			Pattern:     "GET /healthz",
+			Summary:     "Liveness probe",
+			Description: "Answers 200 as long as the process serves requests, dependencies are not checked.",
+			Tags:        []string{"meta"},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: health.Liveness{}},
+			},
+		},
+		{
+			Pattern: "GET /readyz",
+			Summary: "Readiness probe",
+			Description: "Checks the database, the logger and the Telegram Bot API. Results are cached for a few seconds. " +
+				"A failing optional component reports degraded with 200, a failing required one or a shutdown answers 503.",
+			Tags: []string{"meta"},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: health.Report{}},
+				{Status: http.StatusServiceUnavailable, Body: health.Report{}},
+			},
+		},
+		{
+			Pattern:     "GET /metrics",
+			Summary:     "Prometheus metrics",
+			Description: "Request, Telegram, database pool and logger metrics in the Prometheus text format. Keep it reachable by the scraper only.",
+			Tags:        []string{"meta"},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: textSchema, ContentType: "text/plain"},
+			},
+		},
+		{
+			Pattern: "POST /message",
+			Summary: "Store a message",
+			Description: "Requires an API key or bearer token with the messages:write scope. username is limited to 64 and text to 4096 characters. " +
+				"Retries with the same Idempotency-Key and body within 24 hours get the first response back with Idempotent-Replayed: true.",
+			Tags:     []string{"messages"},
+			Security: []string{securityAPIKey, securityBearer},
+			Params: []openapi.Param{
+				{Name: idempotency.Header, In: "header", Description: "Client chosen key that makes retries safe, up to 255 printable ASCII characters", Schema: textSchema},
+			},
+			Request: router.NewMessage{},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: router.StatusResponse{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusUnauthorized),
+				errorResponse(http.StatusForbidden),
+				errorResponse(http.StatusConflict),
+				errorResponse(http.StatusTooManyRequests),
+				errorResponse(http.StatusRequestEntityTooLarge),
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			},
+		},
+		{
+			Pattern: "POST /messages:batch",
+			Summary: "Import messages",
+			Description: "Requires an API key or bearer token with the messages:write scope. The body is a JSON array of messages or, " +
+				"with Content-Type application/x-ndjson, one message per line. Lines are validated like POST /message and may keep the chat, " +
+				"direction and creation time of migrated history, rejected ones are listed in the response. Lines are committed every " +
+				"10000, a failed import answers with the summary of the committed lines and resumes after last_line. " +
+				"The body is limited by BATCH_MAX_BODY_BYTES (64MB by default).",
+			Tags:     []string{"messages"},
+			Security: []string{securityAPIKey, securityBearer},
+			Request:  []router.BatchMessage{},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: router.BatchResult{}},
+				{Status: http.StatusBadRequest, Body: router.BatchResult{}},
+				errorResponse(http.StatusUnauthorized),
+				errorResponse(http.StatusForbidden),
+				{Status: http.StatusRequestEntityTooLarge, Body: router.BatchResult{}},
+				errorResponse(http.StatusUnsupportedMediaType),
+				{Status: http.StatusInternalServerError, Body: router.BatchResult{}},
+				{Status: http.StatusServiceUnavailable, Body: router.BatchResult{}},
+			},
+		},
+		{
+			Pattern: "POST /webhook",
+			Summary: "Telegram Bot API webhook",
+			Tags:    []string{"bot"},
+			Request: &openapi.Schema{Type: "object", Description: "Telegram Update"},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK},
+				errorResponse(http.StatusBadRequest),
+			},
+		},
+		{
+			Pattern:  "GET /webapp/me",
+			Summary:  "Telegram user of the Mini App",
+			Tags:     []string{"webapp"},
+			Security: []string{securityInitData},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: tgauth.User{}},
+				errorResponse(http.StatusUnauthorized),
+			},
+		},
+		{
+			Pattern:  "GET /webapp/messages",
+			Summary:  "Conversation of the Mini App user with the bot",
+			Tags:     []string{"webapp"},
+			Security: []string{securityInitData},
+			Responses: []openapi.Response{
+				{Status: http.StatusOK, Body: router.MessageList{}},
+				errorResponse(http.StatusUnauthorized),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			},
+		},
+		{
+			Pattern:  "POST /webapp/messages",
+			Summary:  "Post a message from the Mini App",
+			Tags:     []string{"webapp"},
+			Security: []string{securityInitData},
+			Request:  router.WebAppMessage{},
+			Responses: []openapi.Response{
+				{Status: http.StatusCreated, Body: router.CreatedMessage{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusUnauthorized),
+				errorResponse(http.StatusRequestEntityTooLarge),
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusTooManyRequests),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			},
+		},
+		{
+			Pattern:     "GET /auth/telegram",
+			Summary:     "Telegram Login Widget callback",
+			Description: "Verifies the widget parameters, sets the session cookie and redirects to the local path in redirect.",
+			Tags:        []string{"auth"},
+			Params: []openapi.Param{
+				{Name: "hash", In: "query", Required: true},
+				{Name: "id", In: "query", Required: true, Schema: integerSchema},
+				{Name: "auth_date", In: "query", Required: true, Schema: integerSchema},
+				{Name: "redirect", In: "query", Description: "Local path to return to"},
+			},
+			Responses: []openapi.Response{
+				{Status: http.StatusFound, Description: "Logged in"},
+				errorResponse(http.StatusUnauthorized),
+				errorResponse(http.StatusForbidden),
+				errorResponse(http.StatusInternalServerError),
+			},
+		},
+		{
+			Pattern: "POST /auth/logout",
+			Summary: "End the dashboard session",
+			Tags:    []string{"auth"},
+			Responses: []openapi.Response{
+				{Status: http.StatusNoContent},
+				errorResponse(http.StatusInternalServerError),
+			},
+		},
+		{
+			Pattern:   "GET /auth/me",
+			Summary:   "Current dashboard session",
+			Tags:      []string{"auth"},
+			Security:  []string{securitySession},
+			Responses: staffErrors(openapi.Response{Status: http.StatusOK, Body: models.Session{}}),
+		},
+		{
+			Pattern:  "GET /messages",
+			Summary:  "List messages",
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params: []openapi.Param{
+				{Name: "username", In: "query"},
+				{Name: "chat_id", In: "query", Schema: integerSchema},
+				{Name: "from", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+				{Name: "to", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+				{Name: "q", In: "query", Description: "Substring of the text"},
+				{Name: "order", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []string{"asc", "desc"}}},
+				{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer", Format: "int32"}},
+				{Name: "cursor", In: "query", Description: "next_cursor of the previous page"},
+			},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: router.MessageList{}},
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern: "GET /messages/stream",
+			Summary: "Stream new messages",
+			Description: "Server-Sent Events with one message event per saved message, the event id is the message id. " +
+				"Clients resume with Last-Event-ID, messages saved in between are replayed first.",
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   streamParams,
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: textSchema, ContentType: "text/event-stream"},
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern: "GET /messages/ws",
+			Summary: "Stream new messages over WebSocket",
+			Description: "Every saved message is sent as a JSON text frame. " +
+				"Slow clients are closed with code 1008, clients resume with last_event_id.",
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   streamParams,
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusSwitchingProtocols, Description: "Upgraded to WebSocket"},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern: "GET /messages/search",
+			Summary: "Search messages",
+			Description: "Full-text search with the text search configuration set by SEARCH_CONFIG on the first start (simple by default) " +
+				"or later by the search-config command, best matches first. Older messages are indexed in the background. " +
+				`q takes words, "phrases", prefix* terms, -excluded terms and OR. Snippets are HTML with the matches in <mark> tags.`,
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params: []openapi.Param{
+				{Name: "q", In: "query", Required: true, Description: "Search query, up to 256 characters", Schema: textSchema},
+				{Name: "username", In: "query", Schema: textSchema},
+				{Name: "chat_id", In: "query", Schema: integerSchema},
+				{Name: "from", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+				{Name: "to", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+				{Name: "limit", In: "query", Description: "Page size, 20 by default, at most 100", Schema: integerSchema},
+				{Name: "cursor", In: "query", Description: "next_cursor of the previous page"},
+			},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: router.SearchResults{}},
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern: "GET /messages/export",
+			Summary: "Export messages",
+			Description: "Streams the messages in id order as CSV, NDJSON or the columnar format described in internal/export. " +
+				"The X-Export-Count and X-Export-Last-ID trailers tell how far the export got, an interrupted export is resumed with after_id. " +
+				"The export subcommand of the server writes the same files from the command line. Downloads beyond EXPORT_MAX_CONCURRENT " +
+				"(2 by default) are refused with 503 and Retry-After.",
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params: []openapi.Param{
+				{Name: "format", In: "query", Schema: &openapi.Schema{Type: "string", Enum: export.Formats}},
+				{Name: "from", In: "query", Description: "RFC 3339 time or date", Schema: textSchema},
+				{Name: "to", In: "query", Description: "RFC 3339 time or date, exclusive", Schema: textSchema},
+				{Name: "chat_id", In: "query", Schema: integerSchema},
+				{Name: "username", In: "query", Schema: textSchema},
+				{Name: "after_id", In: "query", Description: "Last id of an interrupted export", Schema: integerSchema},
+				{Name: "compress", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []string{"gzip"}}},
+			},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: textSchema, ContentType: "text/csv"},
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:  "GET /messages/{id}",
+			Summary:  "Get a message",
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{messageID},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: models.Message{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:     "PATCH /messages/{id}",
+			Summary:     "Edit a message",
+			Description: "Requires the admin role.",
+			Tags:        []string{"messages"},
+			Security:    []string{securitySession, securityAPIKey, securityBearer},
+			Params:      []openapi.Param{messageID},
+			Request:     router.MessagePatch{},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: models.Message{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusRequestEntityTooLarge),
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:     "DELETE /messages/{id}",
+			Summary:     "Delete a message",
+			Description: "Requires the admin role.",
+			Tags:        []string{"messages"},
+			Security:    []string{securitySession, securityAPIKey, securityBearer},
+			Params:      []openapi.Param{messageID},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusNoContent},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:  "GET /chats/{chatID}/messages",
+			Summary:  "Transcript of a chat",
+			Tags:     []string{"messages"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{chatID},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: router.Transcript{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:  "GET /polls/{pollID}/results",
+			Summary:  "Aggregated poll results",
+			Tags:     []string{"polls"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: models.PollResults{}},
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:  "GET /polls/{pollID}/export",
+			Summary:  "Answers of every user as CSV",
+			Tags:     []string{"polls"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: textSchema, ContentType: "text/csv"},
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern: "POST /admin/send",
+			Summary: "Send a message through the bot",
+			Description: "Sends the text, with an optional inline keyboard, to one chat or a list of chats. " +
+				"Every recipient is reported with its Telegram message id or error, the send is recorded in the audit log.",
+			Tags:     []string{"admin"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Request:  admin.SendRequest{},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: admin.SendResponse{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusRequestEntityTooLarge),
+				errorResponse(http.StatusUnprocessableEntity),
+			),
+		},
+		{
+			Pattern:  "GET /admin/webhooks",
+			Summary:  "List webhook subscriptions",
+			Tags:     []string{"webhooks"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: webhook.SubscriptionList{}},
+				errorResponse(http.StatusInternalServerError),
+			),
+		},
+		{
+			Pattern: "POST /admin/webhooks",
+			Summary: "Subscribe a URL to events",
+			Description: "Deliveries are POSTed with the headers " + webhook.HeaderID + ", " + webhook.HeaderEvent + ", " +
+				webhook.HeaderTimestamp + " and " + webhook.HeaderSignature + ", which is " +
+				"sha256=hex(HMAC-SHA256(secret, timestamp + \".\" + body)). The secret is only returned here.",
+			Tags:     []string{"webhooks"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Request:  webhook.SubscriptionRequest{},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusCreated, Body: webhook.CreatedSubscription{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:  "DELETE /admin/webhooks/{id}",
+			Summary:  "Remove a webhook subscription and its deliveries",
+			Tags:     []string{"webhooks"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{{Name: "id", In: "path", Schema: integerSchema}},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusNoContent, Description: "Deleted"},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusInternalServerError),
+			),
+		},
+		{
+			Pattern:  "GET /admin/webhooks/dead-letters",
+			Summary:  "Deliveries that ran out of attempts",
+			Tags:     []string{"webhooks"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer", Format: "int32"}}},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: webhook.DeliveryList{}},
+				errorResponse(http.StatusUnprocessableEntity),
+				errorResponse(http.StatusInternalServerError),
+				errorResponse(http.StatusServiceUnavailable),
+			),
+		},
+		{
+			Pattern:  "POST /admin/webhooks/deliveries/{id}/replay",
+			Summary:  "Send a dead delivery again",
+			Tags:     []string{"webhooks"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{{Name: "id", In: "path", Schema: integerSchema}},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusAccepted, Body: webhook.StatusResponse{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusNotFound),
+				errorResponse(http.StatusInternalServerError),
+			),
+		},
+		{
+			Pattern:   "GET /operator/chats",
+			Summary:   "Chats answered by operators",
+			Tags:      []string{"operator"},
+			Security:  []string{securitySession, securityAPIKey, securityBearer},
+			Responses: staffErrors(openapi.Response{Status: http.StatusOK, Body: operator.ChatList{}}, errorResponse(http.StatusInternalServerError)),
+		},
+		{
+			Pattern:  "POST /operator/chats/{chatID}/handoff",
+			Summary:  "Take a chat over from the bot",
+			Tags:     []string{"operator"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{chatID},
+			Request:  operator.HandoffRequest{},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: operator.ModeResponse{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusInternalServerError),
+			),
+		},
+		{
+			Pattern:  "DELETE /operator/chats/{chatID}/handoff",
+			Summary:  "Give a chat back to the bot",
+			Tags:     []string{"operator"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{chatID},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: operator.ModeResponse{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusInternalServerError),
+			),
+		},
+		{
+			Pattern:  "POST /operator/chats/{chatID}/reply",
+			Summary:  "Send an operator answer to the user",
+			Tags:     []string{"operator"},
+			Security: []string{securitySession, securityAPIKey, securityBearer},
+			Params:   []openapi.Param{chatID},
+			Request:  operator.ReplyRequest{},
+			Responses: staffErrors(
+				openapi.Response{Status: http.StatusOK, Body: operator.StatusResponse{}},
+				errorResponse(http.StatusBadRequest),
+				errorResponse(http.StatusConflict),
+				errorResponse(http.StatusBadGateway),
+			),
+		},
+	}
+}
+
+func newAPISpec() (openapi.Spec, error) {
+	return openapi.NewSpec(openapi.Config{
+		Title:       "Telegram server API",
+		Description: "Routes that need the bot are only served when it is configured.",
+		Routes:      apiRoutes(),
+		ErrorBody:   httperror.ErrorResponse{},
+		SecuritySchemes: map[string]openapi.SecurityScheme{
+			securitySession: {
+				Type:        "apiKey",
+				In:          "cookie",
+				Name:        "session",
+				Description: "Dashboard session from GET /auth/telegram, mutations need the admin role.",
+			},
+			securityAPIKey: {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "X-API-Key",
+				Description: "Key created with the apikey subcommand, also accepted as \"Authorization: Bearer <key>\". Reading messages needs messages:read, changing them messages:write, polls and operator routes admin.",
+			},
+			securityBearer: {
+				Type:         "http",
+				Scheme:       "bearer",
+				BearerFormat: "JWT",
+				Description:  "RS256 or ES256 token of the platform issuer when JWT_ISSUER is set, scopes as for API keys in scope or scp.",
+			},
+			securityInitData: {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "Authorization",
+				Description: "Mini App initData as \"tma <initData>\", X-Telegram-Init-Data is accepted too.",
+			},
+		},
+	})
+}
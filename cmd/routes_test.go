@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// registeredPatterns collects the patterns passed to SetHandler in main.go
+func registeredPatterns(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parsing main.go: %v", err)
+	}
+
+	var patterns []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "SetHandler" || len(call.Args) == 0 {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			t.Errorf("SetHandler pattern must be a string literal to be checked against the OpenAPI document")
+			return true
+		}
+		pattern, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatalf("unquoting %s: %v", lit.Value, err)
+		}
+		patterns = append(patterns, pattern)
+		return true
+	})
+	return patterns
+}
+
+func TestAPIRoutesDocumented(t *testing.T) {
+	registered := registeredPatterns(t)
+	if len(registered) == 0 {
+		t.Fatal("no SetHandler calls found in main.go")
+	}
+
+	documented := make(map[string]bool)
+	for _, route := range apiRoutes() {
+		documented[route.Pattern] = true
+	}
+	isRegistered := make(map[string]bool)
+	for _, pattern := range registered {
+		isRegistered[pattern] = true
+		if !documented[pattern] {
+			t.Errorf("route %q is registered but not documented in apiRoutes", pattern)
+		}
+	}
+	for pattern := range documented {
+		if !isRegistered[pattern] {
+			t.Errorf("route %q is documented but never registered", pattern)
+		}
+	}
+}
+
+func TestAPISpecHandler(t *testing.T) {
+	spec, err := newAPISpec()
+	if err != nil {
+		t.Fatalf("newAPISpec: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	spec.Handler(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	var doc struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatalf("decoding document: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("expected openapi 3.0.3, got %q", doc.OpenAPI)
+	}
+	if _, ok := doc.Paths["/messages/{id}"]["patch"]; !ok {
+		t.Errorf("expected PATCH /messages/{id} in paths")
+	}
+	for _, name := range []string{"Message", "NewMessage", "ErrorResponse"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("expected schema %s in components", name)
+		}
+	}
+}
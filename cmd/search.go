@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"telegram_server/internal/database"
+	"telegram_server/internal/logger"
+	"time"
+)
+
+// searchIndexBatchSize is the number of messages indexed per transaction
+const searchIndexBatchSize = 1000
+
+var searchConfigUsage = `usage:
+  search-config CONFIG
+
+CONFIG is a Postgres text search configuration, e.g. simple, english or russian`
+
+// runSearchConfigCommand runs the search-config subcommand with the arguments
+// following "search-config"
+func runSearchConfigCommand(ctx context.Context, db database.Database, args []string, out io.Writer) error {
+	if len(args) != 1 || args[0] == "" {
+		return fmt.Errorf("%s", searchConfigUsage)
+	}
+	if err := db.SetSearchConfig(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Search configuration set to %s, the server indexes the messages again in the background\n", args[0])
+	return nil
+}
+
+// runSearchIndexer indexes the messages left to index in batches, then checks
+// every interval whether the search configuration changed
+func runSearchIndexer(ctx context.Context, db database.Database, l logger.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var total int64
+		for ctx.Err() == nil {
+			indexed, done, err := db.IndexSearchBatch(ctx, searchIndexBatchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					l.LogEvent("Error while indexing messages for search: " + err.Error())
+				}
+				break
+			}
+			total += indexed
+			if done {
+				break
+			}
+		}
+		if total > 0 {
+			l.LogEvent("Indexed " + strconv.FormatInt(total, 10) + " messages for search")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Admin lets support staff message users through the bot from their tools.
+// Every send is recorded in the audit log with who made it.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	SaveAuditEntry(ctx context.Context, entry models.AuditEntry) (int64, error)
+}
+
+type Bot interface {
+	SendMessageWithMarkup(ctx context.Context, chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) (int, error)
+}
+
+// retryError is a Bot API error asking to wait before the next request
+type retryError interface {
+	error
+	RetryDelay() time.Duration
+}
+
+type Sender interface {
+	Send(ctx context.Context, actor string, req SendRequest) (SendResponse, error)
+	SendHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type Config struct {
+	Logger   Logger
+	Database Database
+	Bot      Bot
+	// Actor names who made a request in the audit log
+	Actor func(r *http.Request) string
+	// MaxRecipients bounds the chats of one request
+	MaxRecipients int
+	// SendInterval paces the messages of all requests, Telegram allows a bot
+	// about 30 messages per second to different chats
+	SendInterval time.Duration
+	// MaxRetries bounds the retries of a recipient when Telegram asks to wait
+	MaxRetries int
+}
+
+type SenderImpl struct {
+	logger        Logger
+	database      Database
+	bot           Bot
+	actor         func(r *http.Request) string
+	maxRecipients int
+	sendInterval  time.Duration
+	maxRetries    int
+
+	mu sync.Mutex
+	// next is when the next message may be sent
+	next time.Time
+}
+
+// SendRequest is the body of POST /admin/send, exactly one of ChatID and
+// ChatIDs is set
+type SendRequest struct {
+	ChatID      *int64                         `json:"chat_id,omitempty"`
+	ChatIDs     []int64                        `json:"chat_ids,omitempty"`
+	Text        string                         `json:"text"`
+	ReplyMarkup *tgbotapi.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// SendResult is the outcome for one recipient, MessageID is the Telegram
+// message id when the message was sent
+type SendResult struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SendResponse reports every recipient in request order
+type SendResponse struct {
+	Results []SendResult `json:"results"`
+	Sent    int          `json:"sent"`
+	Failed  int          `json:"failed"`
+	AuditID int64        `json:"audit_id,omitempty"`
+}
+
+// ActionSend is the audit log action of a send
+const ActionSend = "admin.send"
+
+const maxTextLength = 4096
+
+func defaultConfig() Config {
+	return Config{
+		MaxRecipients: 100,
+		Actor:         func(r *http.Request) string { return "unknown" },
+		SendInterval:  time.Second / 30,
+		MaxRetries:    3,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	if cfg.Bot == nil {
+		return fmt.Errorf("bot is required")
+	}
+	if cfg.MaxRecipients < 0 || cfg.SendInterval < 0 || cfg.MaxRetries < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+func NewSender(cfg Config) (Sender, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.MaxRecipients == 0 {
+		cfg.MaxRecipients = def.MaxRecipients
+	}
+	if cfg.Actor == nil {
+		cfg.Actor = def.Actor
+	}
+	if cfg.SendInterval == 0 {
+		cfg.SendInterval = def.SendInterval
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = def.MaxRetries
+	}
+
+	return &SenderImpl{
+		logger:        cfg.Logger,
+		database:      cfg.Database,
+		bot:           cfg.Bot,
+		actor:         cfg.Actor,
+		maxRecipients: cfg.MaxRecipients,
+		sendInterval:  cfg.SendInterval,
+		maxRetries:    cfg.MaxRetries,
+	}, nil
+}
+
+// recipients returns the chats of a request without duplicates
+func (req SendRequest) recipients() []int64 {
+	if req.ChatID != nil {
+		return []int64{*req.ChatID}
+	}
+	var chats []int64
+	for _, id := range req.ChatIDs {
+		if !slices.Contains(chats, id) {
+			chats = append(chats, id)
+		}
+	}
+	return chats
+}
+
+func (s *SenderImpl) validate(req SendRequest) []httperror.FieldError {
+	var fields []httperror.FieldError
+	switch {
+	case req.ChatID != nil && req.ChatIDs != nil:
+		fields = append(fields, httperror.FieldError{Field: "chat_ids", Message: "must not be combined with chat_id"})
+	case req.ChatID == nil && len(req.ChatIDs) == 0:
+		fields = append(fields, httperror.FieldError{Field: "chat_id", Message: "chat_id or chat_ids is required"})
+	case len(req.recipients()) > s.maxRecipients:
+		fields = append(fields, httperror.FieldError{Field: "chat_ids", Message: "must have at most " + strconv.Itoa(s.maxRecipients) + " chats"})
+	}
+	if slices.Contains(req.ChatIDs, 0) || (req.ChatID != nil && *req.ChatID == 0) {
+		fields = append(fields, httperror.FieldError{Field: "chat_id", Message: "must not be 0"})
+	}
+
+	switch {
+	case req.Text == "":
+		fields = append(fields, httperror.FieldError{Field: "text", Message: "is required"})
+	case !utf8.ValidString(req.Text):
+		fields = append(fields, httperror.FieldError{Field: "text", Message: "must be valid UTF-8"})
+	case utf8.RuneCountInString(req.Text) > maxTextLength:
+		fields = append(fields, httperror.FieldError{Field: "text", Message: "must be at most " + strconv.Itoa(maxTextLength) + " characters"})
+	}
+	return fields
+}
+
+// reserve takes the next send slot, no sooner than after from now, and
+// returns the wait for it
+func (s *SenderImpl) reserve(after time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	at := now.Add(after)
+	if s.next.After(at) {
+		at = s.next
+	}
+	s.next = at.Add(s.sendInterval)
+	return at.Sub(now)
+}
+
+// sendTo sends the message to one chat in its slot and sends it again when
+// Telegram asks to wait, the wait holds back the other requests too
+func (s *SenderImpl) sendTo(ctx context.Context, chatID int64, req SendRequest) (int, error) {
+	var after time.Duration
+	for retry := 0; ; retry++ {
+		timer := time.NewTimer(s.reserve(after))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, errors.New("not sent: " + ctx.Err().Error())
+		case <-timer.C:
+		}
+
+		id, err := s.bot.SendMessageWithMarkup(ctx, chatID, req.Text, req.ReplyMarkup)
+		var retryErr retryError
+		if !errors.As(err, &retryErr) || retryErr.RetryDelay() <= 0 || retry == s.maxRetries {
+			return id, err
+		}
+		after = retryErr.RetryDelay()
+		s.logger.LogEvent("Telegram asked to wait " + after.String() + " before sending more admin messages")
+	}
+}
+
+// Send sends the message to every recipient in turn, paced by SendInterval,
+// and records the outcome in the audit log. Recipients left when ctx ends are
+// reported as not sent. The error is only about the audit log, the results are
+// valid either way.
+func (s *SenderImpl) Send(ctx context.Context, actor string, req SendRequest) (SendResponse, error) {
+	var resp SendResponse
+	for _, chatID := range req.recipients() {
+		result := SendResult{ChatID: chatID}
+		if err := ctx.Err(); err != nil {
+			result.Error = "not sent: " + err.Error()
+		} else if id, err := s.sendTo(ctx, chatID, req); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.MessageID = id
+		}
+
+		if result.Error == "" {
+			resp.Sent++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	details, err := json.Marshal(struct {
+		Text        string       `json:"text"`
+		ReplyMarkup bool         `json:"reply_markup"`
+		Results     []SendResult `json:"results"`
+	}{req.Text, req.ReplyMarkup != nil, resp.Results})
+	if err != nil {
+		return resp, err
+	}
+	// Messages are out, the audit entry is written even if the client went away
+	resp.AuditID, err = s.database.SaveAuditEntry(context.WithoutCancel(ctx), models.AuditEntry{
+		Actor:   actor,
+		Action:  ActionSend,
+		Details: details,
+	})
+	s.logger.LogEvent(actor + " sent a message to " + strconv.Itoa(resp.Sent) + " chats, " + strconv.Itoa(resp.Failed) + " failed")
+	return resp, err
+}
+
+// POST Handler /admin/send (message chats through the bot)
+func (s *SenderImpl) SendHandler(w http.ResponseWriter, r *http.Request) {
+	var req SendRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			httperror.Write(w, r, http.StatusRequestEntityTooLarge, httperror.CodeTooLarge, "request body too large")
+			return
+		}
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if fields := s.validate(req); fields != nil {
+		httperror.Write(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "invalid message", fields...)
+		return
+	}
+
+	resp, err := s.Send(r.Context(), s.actor(r), req)
+	if err != nil {
+		s.logger.LogEvent("Error while recording admin send in the audit log: " + err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,197 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+type fakeDatabase struct {
+	entries []models.AuditEntry
+}
+
+func (f *fakeDatabase) SaveAuditEntry(ctx context.Context, entry models.AuditEntry) (int64, error) {
+	f.entries = append(f.entries, entry)
+	return int64(len(f.entries)), nil
+}
+
+// floodError is a 429 of the Bot API
+type floodError struct{}
+
+func (floodError) Error() string {
+	return "telegram sendMessage failed: 429 Too Many Requests: retry after 1"
+}
+
+func (floodError) RetryDelay() time.Duration { return time.Millisecond }
+
+// fakeBot fails chats listed in blocked and answers the first floods sends
+// with a 429
+type fakeBot struct {
+	blocked map[int64]bool
+	floods  int
+	sent    []int64
+	markup  *tgbotapi.InlineKeyboardMarkup
+}
+
+func (f *fakeBot) SendMessageWithMarkup(ctx context.Context, chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) (int, error) {
+	if f.blocked[chatID] {
+		return 0, errors.New("telegram sendMessage failed: 403 Forbidden: bot was blocked by the user")
+	}
+	if f.floods > 0 {
+		f.floods--
+		return 0, floodError{}
+	}
+	f.sent = append(f.sent, chatID)
+	f.markup = markup
+	return 100 + len(f.sent), nil
+}
+
+func newTestSender(t *testing.T, db *fakeDatabase, bot *fakeBot) *SenderImpl {
+	t.Helper()
+	s, err := NewSender(Config{
+		Logger:        testLogger{},
+		Database:      db,
+		Bot:           bot,
+		Actor:         func(r *http.Request) string { return "session:alice" },
+		MaxRecipients: 3,
+		SendInterval:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	return s.(*SenderImpl)
+}
+
+func TestSendHandler(t *testing.T) {
+	db := &fakeDatabase{}
+	bot := &fakeBot{blocked: map[int64]bool{2: true}}
+	s := newTestSender(t, db, bot)
+
+	body := `{"chat_ids":[1,2,3,1],"text":"Maintenance tonight",
+		"reply_markup":{"inline_keyboard":[[{"text":"Status","url":"https://status.example.com"}]]}}`
+	rr := httptest.NewRecorder()
+	s.SendHandler(rr, httptest.NewRequest("POST", "/admin/send", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp SendResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []SendResult{
+		{ChatID: 1, MessageID: 101},
+		{ChatID: 2, Error: "telegram sendMessage failed: 403 Forbidden: bot was blocked by the user"},
+		{ChatID: 3, MessageID: 102},
+	}
+	if len(resp.Results) != len(want) || resp.Sent != 2 || resp.Failed != 1 || resp.AuditID != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	for i, result := range resp.Results {
+		if result != want[i] {
+			t.Errorf("result %d: expected %+v, got %+v", i, want[i], result)
+		}
+	}
+	if bot.markup == nil || len(bot.markup.InlineKeyboard) != 1 {
+		t.Errorf("expected markup to reach the bot, got %+v", bot.markup)
+	}
+
+	if len(db.entries) != 1 {
+		t.Fatalf("expected one audit entry, got %d", len(db.entries))
+	}
+	entry := db.entries[0]
+	if entry.Actor != "session:alice" || entry.Action != ActionSend || !strings.Contains(string(entry.Details), "bot was blocked") {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+}
+
+func TestSendHandler_Invalid(t *testing.T) {
+	db := &fakeDatabase{}
+	bot := &fakeBot{}
+	s := newTestSender(t, db, bot)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"malformed", `{"chat_id":`, http.StatusBadRequest, ""},
+		{"unknown field", `{"chat_id":1,"text":"hi","parse_mode":"HTML"}`, http.StatusBadRequest, ""},
+		{"no recipient", `{"text":"hi"}`, http.StatusUnprocessableEntity, "chat_id"},
+		{"both recipients", `{"chat_id":1,"chat_ids":[2],"text":"hi"}`, http.StatusUnprocessableEntity, "chat_ids"},
+		{"too many recipients", `{"chat_ids":[1,2,3,4],"text":"hi"}`, http.StatusUnprocessableEntity, "chat_ids"},
+		{"no text", `{"chat_id":1}`, http.StatusUnprocessableEntity, "text"},
+		{"text too long", `{"chat_id":1,"text":"` + strings.Repeat("a", maxTextLength+1) + `"}`, http.StatusUnprocessableEntity, "text"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		s.SendHandler(rr, httptest.NewRequest("POST", "/admin/send", strings.NewReader(tt.body)))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rr.Code)
+		}
+		if tt.field != "" && !strings.Contains(rr.Body.String(), `"field":"`+tt.field+`"`) {
+			t.Errorf("%s: expected error on %s: %s", tt.name, tt.field, rr.Body.String())
+		}
+	}
+	// The server limits bodies with http.MaxBytesReader
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/send", nil)
+	req.Body = http.MaxBytesReader(rr, io.NopCloser(strings.NewReader(`{"chat_id":1,"text":"`+strings.Repeat("a", 100)+`"}`)), 32)
+	s.SendHandler(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), httperror.CodeTooLarge) {
+		t.Errorf("too large: expected 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if len(bot.sent) != 0 || len(db.entries) != 0 {
+		t.Errorf("rejected requests must not send or audit, sent %v, audited %d", bot.sent, len(db.entries))
+	}
+}
+
+func TestSend_RetriesWhenAskedToWait(t *testing.T) {
+	db := &fakeDatabase{}
+	bot := &fakeBot{floods: 2}
+	s := newTestSender(t, db, bot)
+
+	resp, err := s.Send(context.Background(), "session:alice", SendRequest{ChatIDs: []int64{1, 2}, Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Sent != 2 || resp.Failed != 0 || len(bot.sent) != 2 {
+		t.Errorf("expected both chats sent after the retries, got %+v", resp)
+	}
+
+	// A recipient still flooded after MaxRetries fails alone
+	bot.floods = s.maxRetries + 1
+	resp, _ = s.Send(context.Background(), "session:alice", SendRequest{ChatIDs: []int64{3, 4}, Text: "hi"})
+	if resp.Sent != 1 || resp.Failed != 1 || !strings.Contains(resp.Results[0].Error, "429") {
+		t.Errorf("expected the first chat to fail with 429, got %+v", resp)
+	}
+}
+
+func TestSend_Canceled(t *testing.T) {
+	bot := &fakeBot{}
+	s := newTestSender(t, &fakeDatabase{}, bot)
+	s.sendInterval = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	resp, _ := s.Send(ctx, "session:alice", SendRequest{ChatIDs: []int64{1, 2}, Text: "hi"})
+	if resp.Sent != 1 || resp.Failed != 1 || !strings.HasPrefix(resp.Results[1].Error, "not sent") {
+		t.Errorf("expected the second chat to wait for its slot until canceled, got %+v", resp)
+	}
+}
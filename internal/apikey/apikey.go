@@ -0,0 +1,338 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Apikey authenticates HTTP clients with keys of the form tgs_<prefix>_<secret>.
+// The prefix identifies the key in the database, only the SHA-256 hash of the
+// whole key is stored.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey) (int64, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, bool, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64) error
+	RevokeAPIKey(ctx context.Context, id int64) (bool, error)
+}
+
+type Manager interface {
+	// Create stores a new key and returns it in plain text, it cannot be recovered later
+	Create(ctx context.Context, name string, scopes []string, rateLimit int) (string, models.APIKey, error)
+	Revoke(ctx context.Context, id int64) (bool, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+	Authenticate(ctx context.Context, raw string) (models.APIKey, error)
+	Middleware(next http.Handler) http.Handler
+	RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc
+}
+
+type Config struct {
+	Logger   Logger
+	Database Database
+	// TouchInterval limits how often the last use of a key is written
+	TouchInterval time.Duration
+}
+
+type ManagerImpl struct {
+	logger        Logger
+	database      Database
+	touchInterval time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	buckets  map[int64]*bucket
+	lastUsed map[int64]time.Time
+}
+
+const keyPrefix = "tgs_"
+
+var (
+	ErrInvalidKey   = errors.New("invalid API key")
+	ErrRevokedKey   = errors.New("API key is revoked")
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// Scopes lists the valid scopes
+var Scopes = []string{models.ScopeMessagesRead, models.ScopeMessagesWrite, models.ScopeAdmin}
+
+func defaultConfig() Config {
+	return Config{
+		TouchInterval: time.Minute,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	return nil
+}
+
+func NewManager(cfg Config) (Manager, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if cfg.TouchInterval == 0 {
+		cfg.TouchInterval = defaultConfig().TouchInterval
+	}
+
+	return &ManagerImpl{
+		logger:        cfg.Logger,
+		database:      cfg.Database,
+		touchInterval: cfg.TouchInterval,
+		now:           time.Now,
+		buckets:       make(map[int64]*bucket),
+		lastUsed:      make(map[int64]time.Time),
+	}, nil
+}
+
+// HasScope tells whether a key grants scope
+func HasScope(key models.APIKey, scope string) bool {
+	return slices.Contains(key.Scopes, scope) || slices.Contains(key.Scopes, models.ScopeAdmin)
+}
+
+func hashKey(raw string) []byte {
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:]
+}
+
+// parseKey returns the prefix of a key in the tgs_<prefix>_<secret> form
+func parseKey(raw string) (string, bool) {
+	rest, found := strings.CutPrefix(raw, keyPrefix)
+	if !found {
+		return "", false
+	}
+	prefix, secret, found := strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+func (m *ManagerImpl) Create(ctx context.Context, name string, scopes []string, rateLimit int) (string, models.APIKey, error) {
+	var key models.APIKey
+	if name == "" {
+		return "", key, fmt.Errorf("name is required")
+	}
+	if len(scopes) == 0 {
+		return "", key, fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", key, fmt.Errorf("%w %q", ErrUnknownScope, scope)
+		}
+	}
+	if rateLimit < 0 {
+		return "", key, fmt.Errorf("rate limit must not be negative")
+	}
+
+	prefixBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", key, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", key, err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	raw := keyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	key = models.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashKey(raw),
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		CreatedAt: m.now(),
+	}
+	id, err := m.database.CreateAPIKey(ctx, key)
+	if err != nil {
+		return "", key, err
+	}
+	key.ID = id
+	m.logger.LogEvent("API key " + strconv.FormatInt(id, 10) + " created for " + name)
+	return raw, key, nil
+}
+
+func (m *ManagerImpl) Revoke(ctx context.Context, id int64) (bool, error) {
+	revoked, err := m.database.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if revoked {
+		m.mu.Lock()
+		delete(m.buckets, id)
+		delete(m.lastUsed, id)
+		m.mu.Unlock()
+		m.logger.LogEvent("API key " + strconv.FormatInt(id, 10) + " revoked")
+	}
+	return revoked, nil
+}
+
+func (m *ManagerImpl) List(ctx context.Context) ([]models.APIKey, error) {
+	return m.database.ListAPIKeys(ctx)
+}
+
+// Authenticate checks a key against its stored hash and records its use
+func (m *ManagerImpl) Authenticate(ctx context.Context, raw string) (models.APIKey, error) {
+	prefix, ok := parseKey(raw)
+	if !ok {
+		return models.APIKey{}, ErrInvalidKey
+	}
+	key, found, err := m.database.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if !found || subtle.ConstantTimeCompare(key.Hash, hashKey(raw)) != 1 {
+		return models.APIKey{}, ErrInvalidKey
+	}
+	if key.RevokedAt != nil {
+		return models.APIKey{}, ErrRevokedKey
+	}
+
+	m.touch(ctx, key.ID)
+	return key, nil
+}
+
+// touch writes the last use of a key at most once per touchInterval
+func (m *ManagerImpl) touch(ctx context.Context, id int64) {
+	now := m.now()
+	m.mu.Lock()
+	last, ok := m.lastUsed[id]
+	if ok && now.Sub(last) < m.touchInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastUsed[id] = now
+	m.mu.Unlock()
+
+	if err := m.database.TouchAPIKey(ctx, id); err != nil {
+		m.logger.LogEvent("Error while recording API key use: " + err.Error())
+	}
+}
+
+// bucket is a token bucket refilled with rateLimit tokens per minute
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of the key, if there is none it returns the time until the next one
+func (m *ManagerImpl) allow(key models.APIKey) (bool, time.Duration) {
+	if key.RateLimit <= 0 {
+		return true, 0
+	}
+	limit := float64(key.RateLimit)
+	perSecond := limit / 60
+
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key.ID]
+	if !ok {
+		b = &bucket{tokens: limit, last: now}
+		m.buckets[key.ID] = b
+	}
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// keyFromRequest reads the key from X-API-Key or an Authorization: Bearer header
+// holding an API key. Other bearer tokens are left to other authenticators.
+func keyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && strings.HasPrefix(token, keyPrefix) {
+		return token
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+// FromContext returns the key put into the context by Middleware
+func FromContext(ctx context.Context) (models.APIKey, bool) {
+	key, ok := ctx.Value(contextKey{}).(models.APIKey)
+	return key, ok
+}
+
+// Middleware authenticates requests carrying an API key and applies its rate
+// limit. Requests without a key pass through, RequireScope rejects them.
+func (m *ManagerImpl) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := keyFromRequest(r)
+		if raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := m.Authenticate(r.Context(), raw)
+		if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrRevokedKey) {
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			m.logger.LogEvent("Error while authenticating API key: " + err.Error())
+			httperror.Write(w, r, http.StatusServiceUnavailable, httperror.CodeUnavailable, "could not verify API key")
+			return
+		}
+
+		if ok, wait := m.allow(key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			httperror.Write(w, r, http.StatusTooManyRequests, httperror.CodeTooManyRequests, "rate limit of API key exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, key)))
+	})
+}
+
+// RequireScope lets through requests authenticated with a key granting scope
+func (m *ManagerImpl) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := FromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "API key required")
+			return
+		}
+		if !HasScope(key, scope) {
+			httperror.Write(w, r, http.StatusForbidden, httperror.CodeForbidden, "API key lacks scope "+scope)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,186 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+// fakeDatabase keeps keys in memory.
+type fakeDatabase struct {
+	keys    map[string]models.APIKey
+	touches int
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{keys: map[string]models.APIKey{}}
+}
+
+func (f *fakeDatabase) CreateAPIKey(ctx context.Context, key models.APIKey) (int64, error) {
+	key.ID = int64(len(f.keys) + 1)
+	f.keys[key.Prefix] = key
+	return key.ID, nil
+}
+
+func (f *fakeDatabase) GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, bool, error) {
+	key, ok := f.keys[prefix]
+	return key, ok, nil
+}
+
+func (f *fakeDatabase) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	for _, key := range f.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (f *fakeDatabase) TouchAPIKey(ctx context.Context, id int64) error {
+	f.touches++
+	return nil
+}
+
+func (f *fakeDatabase) RevokeAPIKey(ctx context.Context, id int64) (bool, error) {
+	for prefix, key := range f.keys {
+		if key.ID == id && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			f.keys[prefix] = key
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestManager(t *testing.T, db *fakeDatabase) *ManagerImpl {
+	t.Helper()
+	m, err := NewManager(Config{Logger: testLogger{}, Database: db})
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m.(*ManagerImpl)
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	db := newFakeDatabase()
+	m := newTestManager(t, db)
+	ctx := context.Background()
+
+	raw, key, err := m.Create(ctx, "crm", []string{models.ScopeMessagesWrite}, 10)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if string(db.keys[key.Prefix].Hash) == raw {
+		t.Fatal("key must be stored hashed")
+	}
+
+	got, err := m.Authenticate(ctx, raw)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got.ID != key.ID {
+		t.Errorf("expected key %d, got %d", key.ID, got.ID)
+	}
+
+	// Last use is written once per touch interval
+	m.Authenticate(ctx, raw)
+	if db.touches != 1 {
+		t.Errorf("expected 1 touch, got %d", db.touches)
+	}
+
+	if _, err := m.Authenticate(ctx, raw+"x"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for wrong secret, got %v", err)
+	}
+	if _, err := m.Authenticate(ctx, "garbage"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for malformed key, got %v", err)
+	}
+
+	if revoked, err := m.Revoke(ctx, key.ID); err != nil || !revoked {
+		t.Fatalf("Revoke: %v, %v", revoked, err)
+	}
+	if _, err := m.Authenticate(ctx, raw); !errors.Is(err, ErrRevokedKey) {
+		t.Errorf("expected ErrRevokedKey, got %v", err)
+	}
+}
+
+func TestCreate_Invalid(t *testing.T) {
+	m := newTestManager(t, newFakeDatabase())
+	ctx := context.Background()
+
+	if _, _, err := m.Create(ctx, "", []string{models.ScopeAdmin}, 0); err == nil {
+		t.Error("expected error without name")
+	}
+	if _, _, err := m.Create(ctx, "crm", nil, 0); err == nil {
+		t.Error("expected error without scopes")
+	}
+	if _, _, err := m.Create(ctx, "crm", []string{"messages:delete"}, 0); !errors.Is(err, ErrUnknownScope) {
+		t.Errorf("expected ErrUnknownScope, got %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	db := newFakeDatabase()
+	m := newTestManager(t, db)
+	ctx := context.Background()
+
+	writer, _, _ := m.Create(ctx, "writer", []string{models.ScopeMessagesWrite}, 2)
+	reader, _, _ := m.Create(ctx, "reader", []string{models.ScopeMessagesRead}, 0)
+	admin, _, _ := m.Create(ctx, "admin", []string{models.ScopeAdmin}, 0)
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	handler := m.Middleware(m.RequireScope(models.ScopeMessagesWrite, ok))
+
+	do := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/message", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name          string
+		header, value string
+		status        int
+	}{
+		{"no key", "", "", http.StatusUnauthorized},
+		{"invalid key", "X-API-Key", "tgs_nope_nope", http.StatusUnauthorized},
+		{"missing scope", "X-API-Key", reader, http.StatusForbidden},
+		{"admin", "Authorization", "Bearer " + admin, http.StatusNoContent},
+		{"writer", "X-API-Key", writer, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if rr := do(tt.header, tt.value); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rr.Code)
+		}
+	}
+
+	// writer has a limit of 2 requests per minute and used one above
+	if rr := do("X-API-Key", writer); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected second request to pass, got %d", rr.Code)
+	}
+	rr := do("X-API-Key", writer)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected Retry-After 30, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// The bucket refills with time
+	start := time.Now()
+	m.now = func() time.Time { return start.Add(time.Minute) }
+	if rr := do("X-API-Key", writer); rr.Code != http.StatusNoContent {
+		t.Errorf("expected request after refill to pass, got %d", rr.Code)
+	}
+}
@@ -12,7 +12,7 @@ type Logger interface {
 }
 
 type Database interface {
-	SaveMessage(ctx context.Context, username, text string) error
+	SaveMessage(ctx context.Context, username, text string) (models.Message, error)
 	GetMessages(ctx context.Context) ([]models.Message, error)
 	Ping() error
 	CloseDB()
@@ -21,6 +21,16 @@ type Database interface {
 type Router interface {
 	PingHandler(w http.ResponseWriter, r *http.Request)
 	MessageHandler(w http.ResponseWriter, r *http.Request)
+	TranscriptHandler(w http.ResponseWriter, r *http.Request)
+	ListMessagesHandler(w http.ResponseWriter, r *http.Request)
+	GetMessageHandler(w http.ResponseWriter, r *http.Request)
+	UpdateMessageHandler(w http.ResponseWriter, r *http.Request)
+	DeleteMessageHandler(w http.ResponseWriter, r *http.Request)
+	PollResultsHandler(w http.ResponseWriter, r *http.Request)
+	PollExportHandler(w http.ResponseWriter, r *http.Request)
+	WebAppMeHandler(w http.ResponseWriter, r *http.Request)
+	WebAppMessagesHandler(w http.ResponseWriter, r *http.Request)
+	WebAppPostMessageHandler(w http.ResponseWriter, r *http.Request)
 }
 
 type HttpServer interface {
@@ -29,7 +39,7 @@ type HttpServer interface {
 }
 
 type Bot interface {
-	SendMessage(chatID int64, text string) error
+	SendMessage(chatID int64, text string) (int, error)
 	WebHookHandler(w http.ResponseWriter, r *http.Request)
 }
 
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// telegramAPIURL is the base URL of Telegram Bot API, the token and method are appended to it
+var telegramAPIURL = "https://api.telegram.org/bot"
+
+// apiResponse is the envelope returned by every Bot API method
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// APIError is returned when Telegram rejects a request
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	// RetryAfter is the wait Telegram asks for before the next request, it is
+	// set with code 429
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s failed: %d %s", e.Method, e.Code, e.Description)
+}
+
+// RetryDelay returns RetryAfter, callers outside the package check for it
+// without importing APIError
+func (e *APIError) RetryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+// callAPI posts params as JSON to the given Bot API method and decodes
+// the result into result (if it is not nil).
+func (b *BotImpl) callAPI(method string, params any, result any) error {
+	return b.callAPIContext(context.Background(), method, params, result)
+}
+
+// callAPIContext is callAPI giving up when ctx is done, every call has a client span
+func (b *BotImpl) callAPIContext(ctx context.Context, method string, params any, result any) error {
+	ctx, span := tracer.Start(ctx, "telegram "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("telegram.method", method)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := b.doCallAPI(ctx, method, params, result)
+	outcome := "ok"
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		outcome = strconv.Itoa(apiErr.Code)
+		span.SetAttributes(attribute.Int("telegram.error_code", apiErr.Code))
+	} else if err != nil {
+		outcome = "error"
+	}
+	if err != nil {
+		// The URL of the request holds the bot token, it must not reach the traces
+		message := err.Error()
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			message = urlErr.Err.Error()
+		}
+		span.SetStatus(codes.Error, message)
+	}
+	if b.metrics != nil {
+		b.metrics.APICall(method, outcome, time.Since(start))
+	}
+	return err
+}
+
+func (b *BotImpl) doCallAPI(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		b.logger.LogEvent("Error while marshaling JSON: " + err.Error())
+		return err
+	}
+
+	url := telegramAPIURL + botToken + "/" + method
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		b.logger.LogEvent("Error while calling " + method + ": " + err.Error())
+		return err
+	}
+	defer response.Body.Close()
+
+	var apiResp apiResponse
+	if err := json.NewDecoder(response.Body).Decode(&apiResp); err != nil {
+		b.logger.LogEvent("Error while decoding " + method + " response: " + err.Error())
+		return err
+	}
+	if !apiResp.Ok {
+		apiErr := &APIError{
+			Method:      method,
+			Code:        apiResp.ErrorCode,
+			Description: apiResp.Description,
+			RetryAfter:  time.Duration(apiResp.Parameters.RetryAfter) * time.Second,
+		}
+		b.logger.LogEvent(apiErr.Error())
+		return apiErr
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(apiResp.Result, result); err != nil {
+			b.logger.LogEvent("Error while decoding " + method + " result: " + err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMe returns the bot user, it is the cheapest way to check the token and
+// that the Bot API can be reached
+func (b *BotImpl) GetMe(ctx context.Context) (tgbotapi.User, error) {
+	var user tgbotapi.User
+	err := b.callAPIContext(ctx, "getMe", struct{}{}, &user)
+	return user, err
+}
+
+// GetWebhookInfo returns where Telegram sends updates and its last delivery error
+func (b *BotImpl) GetWebhookInfo(ctx context.Context) (tgbotapi.WebhookInfo, error) {
+	var info tgbotapi.WebhookInfo
+	err := b.callAPIContext(ctx, "getWebhookInfo", struct{}{}, &info)
+	return info, err
+}
@@ -0,0 +1,382 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// testLogger collects log events.
+type testLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *testLogger) LogEvent(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+// fakeDatabase keeps bot messages in memory.
+type fakeDatabase struct {
+	mu       sync.Mutex
+	saved    map[int]*models.BotMessage
+	deleted  map[int]bool
+	messages []models.Message
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{saved: map[int]*models.BotMessage{}, deleted: map[int]bool{}}
+}
+
+func (f *fakeDatabase) SaveMessage(ctx context.Context, username, text string) (models.Message, error) {
+	return models.Message{}, nil
+}
+
+func (f *fakeDatabase) GetMessages(ctx context.Context) ([]models.Message, error) { return nil, nil }
+
+func (f *fakeDatabase) SaveChatMessage(ctx context.Context, msg models.Message) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return int64(len(f.messages)), nil
+}
+
+func (f *fakeDatabase) UpdateMessageStatus(ctx context.Context, id int64, status string, messageID int) error {
+	return nil
+}
+
+func (f *fakeDatabase) SaveBotMessage(ctx context.Context, chatID int64, messageID int, text string, expiresAt *time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.saved[messageID] = &models.BotMessage{ChatID: chatID, MessageID: messageID, Text: text, ExpiresAt: expiresAt}
+	return nil
+}
+
+func (f *fakeDatabase) UpdateBotMessageText(ctx context.Context, chatID int64, messageID int, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := f.saved[messageID]; ok {
+		m.Text = text
+	}
+	return nil
+}
+
+func (f *fakeDatabase) MarkBotMessageDeleted(ctx context.Context, chatID int64, messageID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted[messageID] = true
+	return nil
+}
+
+func (f *fakeDatabase) GetExpiredBotMessages(ctx context.Context, now time.Time) ([]models.BotMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var expired []models.BotMessage
+	for id, m := range f.saved {
+		if !f.deleted[id] && m.ExpiresAt != nil && !m.ExpiresAt.After(now) {
+			expired = append(expired, *m)
+		}
+	}
+	return expired, nil
+}
+
+func (f *fakeDatabase) SavePoll(ctx context.Context, poll models.Poll) error { return nil }
+
+func (f *fakeDatabase) ClosePoll(ctx context.Context, pollID string) error { return nil }
+
+func (f *fakeDatabase) SavePollVote(ctx context.Context, vote models.PollVote) error { return nil }
+
+// newTestAPI starts a fake Bot API server and points telegramAPIURL to it.
+func newTestAPI(t *testing.T, handler func(method string, params map[string]any) (int, string)) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		status, body := handler(method, params)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	old := telegramAPIURL
+	telegramAPIURL = srv.URL + "/bot"
+	t.Cleanup(func() {
+		telegramAPIURL = old
+		srv.Close()
+	})
+}
+
+func TestCallAPI_Error(t *testing.T) {
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: message to edit not found"}`
+	})
+
+	b := &BotImpl{logger: &testLogger{}, database: newFakeDatabase()}
+	err := b.EditMessageText(1, 2, "new")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %v", err)
+	}
+	if apiErr.Method != "editMessageText" || apiErr.Code != 400 {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+}
+
+func TestCallAPI_RetryAfter(t *testing.T) {
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		return http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 3","parameters":{"retry_after":3}}`
+	})
+
+	b := &BotImpl{logger: &testLogger{}, database: newFakeDatabase()}
+	_, err := b.SendMessageWithMarkup(context.Background(), 1, "hello", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 429 || apiErr.RetryDelay() != 3*time.Second {
+		t.Errorf("expected a 429 asking to wait 3s, got %v", err)
+	}
+}
+
+func TestSendEphemeralMessage_DeletedAfterTTL(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		mu.Lock()
+		calls = append(calls, method)
+		mu.Unlock()
+		switch method {
+		case "sendMessage":
+			return http.StatusOK, `{"ok":true,"result":{"message_id":42,"chat":{"id":7}}}`
+		case "deleteMessage":
+			if params["message_id"].(float64) != 42 {
+				return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"wrong id"}`
+			}
+			return http.StatusOK, `{"ok":true,"result":true}`
+		}
+		return http.StatusNotFound, `{"ok":false,"error_code":404,"description":"Not Found"}`
+	})
+
+	db := newFakeDatabase()
+	b := &BotImpl{logger: &testLogger{}, database: db}
+
+	id, err := b.SendEphemeralMessage(7, "code: 1234", -time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != 42 {
+		t.Fatalf("expected message id 42, got %d", id)
+	}
+
+	if err := b.DeleteExpiredMessages(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !db.deleted[42] {
+		t.Error("expected expired message to be marked deleted")
+	}
+	if len(calls) != 2 || calls[1] != "deleteMessage" {
+		t.Errorf("unexpected API calls: %v", calls)
+	}
+}
+
+func TestSendMessageWithMarkup(t *testing.T) {
+	var markup map[string]any
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		markup, _ = params["reply_markup"].(map[string]any)
+		return http.StatusOK, `{"ok":true,"result":{"message_id":5,"chat":{"id":7}}}`
+	})
+
+	b := &BotImpl{logger: &testLogger{}, database: newFakeDatabase()}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL("Open", "https://example.com"),
+	))
+	id, err := b.SendMessageWithMarkup(context.Background(), 7, "hello", &keyboard)
+	if err != nil || id != 5 {
+		t.Fatalf("expected message 5, got %d, %v", id, err)
+	}
+	rows, _ := markup["inline_keyboard"].([]any)
+	if len(rows) != 1 {
+		t.Errorf("expected the keyboard to be sent, got %v", markup)
+	}
+
+	if _, err := b.SendMessage(7, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if markup != nil {
+		t.Errorf("expected no markup on plain messages, got %v", markup)
+	}
+}
+
+func TestDeleteExpiredMessages_AlreadyGone(t *testing.T) {
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: message to delete not found"}`
+	})
+
+	db := newFakeDatabase()
+	expiresAt := time.Now().Add(-time.Minute)
+	db.SaveBotMessage(context.Background(), 7, 5, "old", &expiresAt)
+
+	b := &BotImpl{logger: &testLogger{}, database: db}
+	if err := b.DeleteExpiredMessages(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !db.deleted[5] {
+		t.Error("expected missing message to be forgotten")
+	}
+}
+
+func TestSendPoll(t *testing.T) {
+	var params map[string]any
+	newTestAPI(t, func(method string, p map[string]any) (int, string) {
+		params = p
+		return http.StatusOK, `{"ok":true,"result":{"message_id":3,"chat":{"id":7},"poll":{"id":"p1"}}}`
+	})
+
+	b := &BotImpl{logger: &testLogger{}, database: newFakeDatabase()}
+
+	if _, err := b.SendPoll(7, "Only one option?", []string{"yes"}, nil); err == nil {
+		t.Error("expected error for poll with a single option")
+	}
+
+	correct := 1
+	id, err := b.SendPoll(7, "2+2?", []string{"3", "4"}, &correct)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != "p1" {
+		t.Errorf("expected poll id p1, got %q", id)
+	}
+	if params["type"] != "quiz" || params["is_anonymous"] != false || params["correct_option_id"].(float64) != 1 {
+		t.Errorf("unexpected sendPoll params: %v", params)
+	}
+}
+
+// testMetrics collects what the bot reports
+type testMetrics struct {
+	mu      sync.Mutex
+	updates []string
+	calls   []string
+}
+
+func (m *testMetrics) UpdateReceived(updateType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updates = append(m.updates, updateType)
+}
+
+func (m *testMetrics) APICall(method, result string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, method+" "+result)
+}
+
+func TestMetrics(t *testing.T) {
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		if method == "deleteMessage" {
+			return http.StatusForbidden, `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`
+		}
+		return http.StatusOK, `{"ok":true,"result":{"message_id":5,"chat":{"id":7}}}`
+	})
+
+	m := &testMetrics{}
+	b := &BotImpl{logger: &testLogger{}, database: newFakeDatabase()}
+	b.SetMetrics(m)
+
+	b.SendMessage(7, "hello")
+	b.DeleteMessage(7, 5)
+	telegramAPIURL = "http://127.0.0.1:0/bot"
+	b.SendMessage(7, "unreachable")
+
+	for _, body := range []string{`{"update_id":1,"poll_answer":{"poll_id":"p","option_ids":[0]}}`, `{"update_id":2}`, `{`} {
+		b.WebHookHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/webhook", strings.NewReader(body)))
+	}
+
+	wantCalls := []string{"sendMessage ok", "deleteMessage 403", "sendMessage error"}
+	if strings.Join(m.calls, "|") != strings.Join(wantCalls, "|") {
+		t.Errorf("expected calls %q, got %q", wantCalls, m.calls)
+	}
+	wantUpdates := []string{"poll_answer", "other", "invalid"}
+	if strings.Join(m.updates, "|") != strings.Join(wantUpdates, "|") {
+		t.Errorf("expected updates %q, got %q", wantUpdates, m.updates)
+	}
+}
+
+// setTestTracer records spans in memory for the length of the test
+func setTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	return exporter
+}
+
+// The reply to an update is traced as a child of the update span
+func TestTracing(t *testing.T) {
+	exporter := setTestTracer(t)
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		return http.StatusOK, `{"ok":true,"result":{"message_id":5,"chat":{"id":7}}}`
+	})
+
+	b := &BotImpl{logger: &testLogger{}, database: newFakeDatabase()}
+	update := `{"update_id":9,"message":{"message_id":3,"from":{"id":1,"username":"alice"},"chat":{"id":7},"text":"hi"}}`
+	b.WebHookHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/webhook", strings.NewReader(update)))
+
+	telegramAPIURL = "http://127.0.0.1:0/bot"
+	b.SendMessage(7, "unreachable")
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	reply, updateSpan, failed := spans[0], spans[1], spans[2]
+	if updateSpan.Name != "telegram.update message" || reply.Name != "telegram sendMessage" {
+		t.Fatalf("unexpected spans %s, %s", updateSpan.Name, reply.Name)
+	}
+	if reply.Parent.SpanID() != updateSpan.SpanContext.SpanID() {
+		t.Error("expected the reply to be a child of the update")
+	}
+	if failed.Status.Code != codes.Error || strings.Contains(failed.Status.Description, "/bot") {
+		t.Errorf("expected a failed span without the request URL, got %+v", failed.Status)
+	}
+}
+
+// Messages sent on behalf of a chat have no sender user
+func TestWebHookHandler_NoFrom(t *testing.T) {
+	newTestAPI(t, func(method string, params map[string]any) (int, string) {
+		return http.StatusOK, `{"ok":true,"result":{"message_id":5,"chat":{"id":-100}}}`
+	})
+
+	db := newFakeDatabase()
+	b := &BotImpl{logger: &testLogger{}, database: db}
+	for _, update := range []string{
+		`{"update_id":1,"message":{"message_id":3,"sender_chat":{"id":-100,"username":"news"},"chat":{"id":-100},"text":"hi"}}`,
+		`{"update_id":2,"message":{"message_id":4,"chat":{"id":-100},"text":"hi"}}`,
+	} {
+		rr := httptest.NewRecorder()
+		b.WebHookHandler(rr, httptest.NewRequest("POST", "/webhook", strings.NewReader(update)))
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", rr.Code)
+		}
+	}
+	var senders []string
+	for _, m := range db.messages {
+		if m.Direction == models.DirectionIncoming {
+			senders = append(senders, m.UserName)
+		}
+	}
+	if strings.Join(senders, "|") != "news|" {
+		t.Errorf("expected the messages of news and of nobody, got %q", senders)
+	}
+}
@@ -1,16 +1,20 @@
 package bot
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"time"
 
 	//"telegram_server/internal/awsclient"
+	"telegram_server/internal/httperror"
 	"telegram_server/internal/models"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Bot is a service that interacts with Telegram bot
@@ -18,10 +22,30 @@ import (
 type BotImpl struct {
 	logger   Logger
 	database Database
+	handoff  Handoff
+	notifier Notifier
+	metrics  Metrics
 }
 
 type Bot interface {
-	SendMessage(chatID int64, text string) error
+	SendMessage(chatID int64, text string) (int, error)
+	SendMessageWithMarkup(ctx context.Context, chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) (int, error)
+	SendEphemeralMessage(chatID int64, text string, ttl time.Duration) (int, error)
+	EditMessageText(chatID int64, messageID int, text string) error
+	EditMessageReplyMarkup(chatID int64, messageID int, markup *tgbotapi.InlineKeyboardMarkup) error
+	DeleteMessage(chatID int64, messageID int) error
+	PinChatMessage(chatID int64, messageID int, disableNotification bool) error
+	CopyMessage(chatID, fromChatID int64, messageID int) (int, error)
+	ForwardMessage(chatID, fromChatID int64, messageID int) (int, error)
+	DeleteExpiredMessages(ctx context.Context) error
+	RunCleanup(ctx context.Context, interval time.Duration)
+	SendPoll(chatID int64, question string, options []string, correctOptionID *int) (string, error)
+	Token() string
+	GetMe(ctx context.Context) (tgbotapi.User, error)
+	GetWebhookInfo(ctx context.Context) (tgbotapi.WebhookInfo, error)
+	SetHandoff(h Handoff)
+	SetNotifier(n Notifier)
+	SetMetrics(m Metrics)
 	WebHookHandler(w http.ResponseWriter, r *http.Request)
 }
 
@@ -30,21 +54,47 @@ type Logger interface {
 }
 
 type Database interface {
-	SaveMessage(ctx context.Context, username, text string) error
+	SaveMessage(ctx context.Context, username, text string) (models.Message, error)
 	GetMessages(ctx context.Context) ([]models.Message, error)
+	SaveChatMessage(ctx context.Context, msg models.Message) (int64, error)
+	UpdateMessageStatus(ctx context.Context, id int64, status string, messageID int) error
+	SaveBotMessage(ctx context.Context, chatID int64, messageID int, text string, expiresAt *time.Time) error
+	UpdateBotMessageText(ctx context.Context, chatID int64, messageID int, text string) error
+	MarkBotMessageDeleted(ctx context.Context, chatID int64, messageID int) error
+	GetExpiredBotMessages(ctx context.Context, now time.Time) ([]models.BotMessage, error)
+	SavePoll(ctx context.Context, poll models.Poll) error
+	ClosePoll(ctx context.Context, pollID string) error
+	SavePollVote(ctx context.Context, vote models.PollVote) error
 }
 
-type AWSClient interface {
-	GetBotToken(ctx context.Context) (string, error)
+// Handoff gets incoming messages before the bot answers them, a true result means
+// a human operator takes care of the message.
+type Handoff interface {
+	HandleMessage(ctx context.Context, msg *tgbotapi.Message) (bool, error)
+}
+
+// Notifier is told about every incoming message once it is saved
+type Notifier interface {
+	MessageSaved(ctx context.Context, id int64)
 }
 
-type SendMessageRequest struct {
-	ChatID int64  `json:"chat_id"`
-	Text   string `json:"text"`
+// Metrics is told about webhook updates and Bot API calls. The result of a
+// call is "ok", the error code Telegram answered with or "error" when there
+// was no answer.
+type Metrics interface {
+	UpdateReceived(updateType string)
+	APICall(method, result string, duration time.Duration)
+}
+
+type AWSClient interface {
+	GetBotToken(ctx context.Context) (string, error)
 }
 
 var botToken string
 
+// tracer starts the spans of updates and Bot API calls
+var tracer = otel.Tracer("telegram_server/internal/bot")
+
 // NewBot creates a new Bot
 func NewBot(l Logger, db Database) (Bot, error) {
 	// newAws, err := awsclient.NewAWSClient(l)
@@ -62,28 +112,51 @@ func NewBot(l Logger, db Database) (Bot, error) {
 	}, nil
 }
 
-func (b *BotImpl) SendMessage(chatID int64, text string) error {
+// Token returns the bot token, it is the key for data signed by Telegram
+func (b *BotImpl) Token() string {
+	return botToken
+}
 
-	url := "https://api.telegram.org/bot" + botToken + "/sendMessage"
-	data := SendMessageRequest{
-		ChatID: chatID,
-		Text:   text,
-	}
-	body, err := json.Marshal(data)
-	if err != nil {
-		b.logger.LogEvent("Error while marshaling JSON: " + err.Error())
-		return err
-	}
+// SetHandoff sets the operator handoff, nil disables it
+func (b *BotImpl) SetHandoff(h Handoff) {
+	b.handoff = h
+}
 
-	response, err := http.Post(url, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		b.logger.LogEvent("Error while sending response message: " + err.Error())
-		return err
-	}
-	defer response.Body.Close()
+// SetNotifier sets who is told about incoming messages, nil tells nobody
+func (b *BotImpl) SetNotifier(n Notifier) {
+	b.notifier = n
+}
 
-	b.logger.LogEvent("Message sent! " + response.Status + " " + fmt.Sprint(response.StatusCode))
-	return nil
+// SetMetrics sets where updates and API calls are counted, nil counts nothing
+func (b *BotImpl) SetMetrics(m Metrics) {
+	b.metrics = m
+}
+
+// updateType names the kind of an update for metrics
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.ChannelPost != nil:
+		return "channel_post"
+	case update.EditedChannelPost != nil:
+		return "edited_channel_post"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.Poll != nil:
+		return "poll"
+	case update.PollAnswer != nil:
+		return "poll_answer"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	case update.ChatMember != nil:
+		return "chat_member"
+	}
+	return "other"
 }
 
 // webhook Handler
@@ -95,30 +168,84 @@ func (b *BotImpl) WebHookHandler(w http.ResponseWriter, r *http.Request) {
 
 		logString := "Error while decoding webhook update: " + err.Error()
 		b.logger.LogEvent(logString)
-		http.Error(w, "Error while decoding", http.StatusBadRequest)
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid update")
+		if b.metrics != nil {
+			b.metrics.UpdateReceived("invalid")
+		}
 		return
 	}
+	kind := updateType(update)
+	if b.metrics != nil {
+		b.metrics.UpdateReceived(kind)
+	}
+
+	// The update is handled to the end even when Telegram stops waiting for the answer
+	ctx, span := tracer.Start(context.WithoutCancel(r.Context()), "telegram.update "+kind, trace.WithAttributes(
+		attribute.Int("telegram.update_id", update.UpdateID),
+		attribute.String("telegram.update_type", kind),
+	))
+	defer span.End()
+	if chat := update.FromChat(); chat != nil {
+		span.SetAttributes(attribute.Int64("telegram.chat_id", chat.ID))
+	}
+
 	if update.Message != nil {
-		userName := update.Message.From.UserName
+		// Messages sent on behalf of a chat have no From
+		userName := ""
+		if update.Message.From != nil {
+			userName = update.Message.From.UserName
+		} else if update.Message.SenderChat != nil {
+			userName = update.Message.SenderChat.UserName
+		}
 		messageText := update.Message.Text
 
 		logString := "Received message from: " + userName + ", text: " + messageText
 		b.logger.LogEvent(logString)
 
+		incoming := models.Message{
+			UserName:  userName,
+			Text:      messageText,
+			ChatID:    update.Message.Chat.ID,
+			MessageID: update.Message.MessageID,
+			Direction: models.DirectionIncoming,
+			Status:    models.StatusReceived,
+		}
+		if update.Message.ReplyToMessage != nil {
+			incoming.ReplyTo = &update.Message.ReplyToMessage.MessageID
+		}
+
 		// Saving message to database
-		if err := b.database.SaveMessage(context.Background(), userName, messageText); err != nil {
+		if id, err := b.database.SaveChatMessage(ctx, incoming); err != nil {
 			b.logger.LogEvent("Error while saving message to database: " + err.Error())
+			span.SetStatus(codes.Error, "could not save the message")
 		} else {
 			b.logger.LogEvent("Message saved successfully")
+			if b.notifier != nil {
+				b.notifier.MessageSaved(ctx, id)
+			}
 		}
 
-		// TO DELETE
-		fmt.Println(b.database.GetMessages(context.Background()))
+		if b.handoff != nil {
+			handled, err := b.handoff.HandleMessage(ctx, update.Message)
+			if err != nil {
+				b.logger.LogEvent("Error while handing message to operator: " + err.Error())
+			}
+			if handled {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
 
 		responseText := "Hi, " + userName + "! You wrote: " + messageText
-		b.SendMessage(update.Message.Chat.ID, responseText)
+		b.sendMessage(ctx, update.Message.Chat.ID, responseText, update.Message.MessageID, nil, nil)
 
 	}
+	if update.Poll != nil {
+		b.handlePoll(ctx, update.Poll)
+	}
+	if update.PollAnswer != nil {
+		b.handlePollAnswer(ctx, update.PollAnswer)
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
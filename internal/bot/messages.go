@@ -0,0 +1,201 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"telegram_server/internal/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type SendMessageRequest struct {
+	ChatID           int64                          `json:"chat_id"`
+	Text             string                         `json:"text"`
+	ReplyToMessageID int                            `json:"reply_to_message_id,omitempty"`
+	ReplyMarkup      *tgbotapi.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type EditMessageTextRequest struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+type EditMessageReplyMarkupRequest struct {
+	ChatID      int64                          `json:"chat_id"`
+	MessageID   int                            `json:"message_id"`
+	ReplyMarkup *tgbotapi.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type DeleteMessageRequest struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int   `json:"message_id"`
+}
+
+type PinChatMessageRequest struct {
+	ChatID              int64 `json:"chat_id"`
+	MessageID           int   `json:"message_id"`
+	DisableNotification bool  `json:"disable_notification,omitempty"`
+}
+
+// CopyMessageRequest is used both for copyMessage and forwardMessage
+type CopyMessageRequest struct {
+	ChatID     int64 `json:"chat_id"`
+	FromChatID int64 `json:"from_chat_id"`
+	MessageID  int   `json:"message_id"`
+}
+
+func (b *BotImpl) SendMessage(chatID int64, text string) (int, error) {
+	return b.sendMessage(context.Background(), chatID, text, 0, nil, nil)
+}
+
+// SendMessageWithMarkup sends text with an inline keyboard, nil markup sends plain text
+func (b *BotImpl) SendMessageWithMarkup(ctx context.Context, chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) (int, error) {
+	return b.sendMessage(ctx, chatID, text, 0, markup, nil)
+}
+
+// SendEphemeralMessage sends a message that is deleted by DeleteExpiredMessages after ttl
+func (b *BotImpl) SendEphemeralMessage(chatID int64, text string, ttl time.Duration) (int, error) {
+	expiresAt := time.Now().Add(ttl)
+	return b.sendMessage(context.Background(), chatID, text, 0, nil, &expiresAt)
+}
+
+// sendMessage sends text to chatID, optionally as a reply to replyTo or with an inline
+// keyboard, and records it in the conversation history. Messages with expiresAt set are
+// deleted by DeleteExpiredMessages.
+func (b *BotImpl) sendMessage(ctx context.Context, chatID int64, text string, replyTo int, markup *tgbotapi.InlineKeyboardMarkup, expiresAt *time.Time) (int, error) {
+	outgoing := models.Message{
+		Text:      text,
+		ChatID:    chatID,
+		Direction: models.DirectionOutgoing,
+		Status:    models.StatusPending,
+	}
+	if replyTo != 0 {
+		outgoing.ReplyTo = &replyTo
+	}
+	recordID, err := b.database.SaveChatMessage(ctx, outgoing)
+	if err != nil {
+		b.logger.LogEvent("Error while saving outgoing message: " + err.Error())
+	}
+
+	var sent tgbotapi.Message
+	req := SendMessageRequest{ChatID: chatID, Text: text, ReplyToMessageID: replyTo, ReplyMarkup: markup}
+	if err := b.callAPIContext(ctx, "sendMessage", req, &sent); err != nil {
+		b.logger.LogEvent("Error while sending response message: " + err.Error())
+		if recordID != 0 {
+			b.database.UpdateMessageStatus(ctx, recordID, models.StatusFailed, 0)
+		}
+		return 0, err
+	}
+	b.logger.LogEvent("Message sent! id: " + strconv.Itoa(sent.MessageID))
+
+	if recordID != 0 {
+		if err := b.database.UpdateMessageStatus(ctx, recordID, models.StatusSent, sent.MessageID); err != nil {
+			b.logger.LogEvent("Error while updating outgoing message: " + err.Error())
+		}
+	}
+	if err := b.database.SaveBotMessage(ctx, chatID, sent.MessageID, text, expiresAt); err != nil {
+		b.logger.LogEvent("Error while saving sent message: " + err.Error())
+	}
+	return sent.MessageID, nil
+}
+
+func (b *BotImpl) EditMessageText(chatID int64, messageID int, text string) error {
+	req := EditMessageTextRequest{ChatID: chatID, MessageID: messageID, Text: text}
+	if err := b.callAPI("editMessageText", req, nil); err != nil {
+		return err
+	}
+	if err := b.database.UpdateBotMessageText(context.Background(), chatID, messageID, text); err != nil {
+		b.logger.LogEvent("Error while saving edited message: " + err.Error())
+	}
+	return nil
+}
+
+// EditMessageReplyMarkup replaces the inline keyboard of a message, nil removes it
+func (b *BotImpl) EditMessageReplyMarkup(chatID int64, messageID int, markup *tgbotapi.InlineKeyboardMarkup) error {
+	req := EditMessageReplyMarkupRequest{ChatID: chatID, MessageID: messageID, ReplyMarkup: markup}
+	return b.callAPI("editMessageReplyMarkup", req, nil)
+}
+
+func (b *BotImpl) DeleteMessage(chatID int64, messageID int) error {
+	req := DeleteMessageRequest{ChatID: chatID, MessageID: messageID}
+	if err := b.callAPI("deleteMessage", req, nil); err != nil {
+		return err
+	}
+	if err := b.database.MarkBotMessageDeleted(context.Background(), chatID, messageID); err != nil {
+		b.logger.LogEvent("Error while saving deleted message: " + err.Error())
+	}
+	return nil
+}
+
+func (b *BotImpl) PinChatMessage(chatID int64, messageID int, disableNotification bool) error {
+	req := PinChatMessageRequest{ChatID: chatID, MessageID: messageID, DisableNotification: disableNotification}
+	return b.callAPI("pinChatMessage", req, nil)
+}
+
+// CopyMessage copies a message to chatID without a link to the original and returns the new message id
+func (b *BotImpl) CopyMessage(chatID, fromChatID int64, messageID int) (int, error) {
+	var copied tgbotapi.MessageID
+	req := CopyMessageRequest{ChatID: chatID, FromChatID: fromChatID, MessageID: messageID}
+	if err := b.callAPI("copyMessage", req, &copied); err != nil {
+		return 0, err
+	}
+	if err := b.database.SaveBotMessage(context.Background(), chatID, copied.MessageID, "", nil); err != nil {
+		b.logger.LogEvent("Error while saving copied message: " + err.Error())
+	}
+	return copied.MessageID, nil
+}
+
+// ForwardMessage forwards a message to chatID and returns the new message id
+func (b *BotImpl) ForwardMessage(chatID, fromChatID int64, messageID int) (int, error) {
+	var forwarded tgbotapi.Message
+	req := CopyMessageRequest{ChatID: chatID, FromChatID: fromChatID, MessageID: messageID}
+	if err := b.callAPI("forwardMessage", req, &forwarded); err != nil {
+		return 0, err
+	}
+	if err := b.database.SaveBotMessage(context.Background(), chatID, forwarded.MessageID, forwarded.Text, nil); err != nil {
+		b.logger.LogEvent("Error while saving forwarded message: " + err.Error())
+	}
+	return forwarded.MessageID, nil
+}
+
+// DeleteExpiredMessages deletes every ephemeral message whose TTL has passed
+func (b *BotImpl) DeleteExpiredMessages(ctx context.Context) error {
+	expired, err := b.database.GetExpiredBotMessages(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range expired {
+		err := b.DeleteMessage(msg.ChatID, msg.MessageID)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+			// Message is already gone (deleted by user or too old), forget about it
+			err = b.database.MarkBotMessageDeleted(ctx, msg.ChatID, msg.MessageID)
+		}
+		if err != nil {
+			b.logger.LogEvent("Error while deleting expired message: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// RunCleanup calls DeleteExpiredMessages every interval until ctx is done
+func (b *BotImpl) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.DeleteExpiredMessages(ctx); err != nil {
+				b.logger.LogEvent("Error while cleaning up expired messages: " + err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"telegram_server/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type SendPollRequest struct {
+	ChatID          int64    `json:"chat_id"`
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	IsAnonymous     bool     `json:"is_anonymous"`
+	Type            string   `json:"type"`
+	CorrectOptionID *int     `json:"correct_option_id,omitempty"`
+}
+
+// SendPoll sends a poll to chatID and returns its id. A non-nil correctOptionID
+// turns the poll into a quiz. Polls are never anonymous so that every answer
+// is delivered to the webhook.
+func (b *BotImpl) SendPoll(chatID int64, question string, options []string, correctOptionID *int) (string, error) {
+	if len(options) < 2 {
+		return "", fmt.Errorf("poll needs at least 2 options, got %d", len(options))
+	}
+	if correctOptionID != nil && (*correctOptionID < 0 || *correctOptionID >= len(options)) {
+		return "", fmt.Errorf("correct option %d is out of range", *correctOptionID)
+	}
+
+	req := SendPollRequest{
+		ChatID:          chatID,
+		Question:        question,
+		Options:         options,
+		Type:            models.PollRegular,
+		CorrectOptionID: correctOptionID,
+	}
+	if correctOptionID != nil {
+		req.Type = models.PollQuiz
+	}
+
+	var sent tgbotapi.Message
+	if err := b.callAPI("sendPoll", req, &sent); err != nil {
+		return "", err
+	}
+	if sent.Poll == nil {
+		return "", fmt.Errorf("telegram sendPoll returned no poll")
+	}
+
+	poll := models.Poll{
+		ID:              sent.Poll.ID,
+		ChatID:          chatID,
+		MessageID:       sent.MessageID,
+		Question:        question,
+		Options:         options,
+		Type:            req.Type,
+		CorrectOptionID: correctOptionID,
+	}
+	if err := b.database.SavePoll(context.Background(), poll); err != nil {
+		b.logger.LogEvent("Error while saving poll: " + err.Error())
+	}
+	b.logger.LogEvent("Poll sent! id: " + poll.ID)
+	return poll.ID, nil
+}
+
+// handlePoll processes poll state updates, only closing is of interest as answers
+// are counted from poll_answer updates
+func (b *BotImpl) handlePoll(ctx context.Context, poll *tgbotapi.Poll) {
+	if !poll.IsClosed {
+		return
+	}
+	if err := b.database.ClosePoll(ctx, poll.ID); err != nil {
+		b.logger.LogEvent("Error while closing poll: " + err.Error())
+	}
+}
+
+// handlePollAnswer stores the answer of a user
+func (b *BotImpl) handlePollAnswer(ctx context.Context, answer *tgbotapi.PollAnswer) {
+	vote := models.PollVote{
+		PollID:    answer.PollID,
+		UserID:    answer.User.ID,
+		UserName:  answer.User.UserName,
+		OptionIDs: answer.OptionIDs,
+	}
+	if err := b.database.SavePollVote(ctx, vote); err != nil {
+		b.logger.LogEvent("Error while saving poll answer: " + err.Error())
+		return
+	}
+	b.logger.LogEvent("Poll answer saved, poll: " + answer.PollID + ", user: " + strconv.FormatInt(answer.User.ID, 10))
+}
@@ -0,0 +1,125 @@
+package crashreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Crashreport sends panics recovered by the server to the places set up in
+// Config: a file getting one JSON crash per line and an HTTP collector
+// getting every crash POSTed as JSON. Crashes go to all of them.
+
+type Reporter interface {
+	Report(ctx context.Context, crash models.Crash) error
+}
+
+type Config struct {
+	// File is appended a line per crash when it is set
+	File string
+	// URL is POSTed every crash when it is set
+	URL string
+	// Token is sent to URL as a bearer token when it is set
+	Token      string
+	HTTPClient *http.Client
+}
+
+type ReporterImpl struct {
+	file   string
+	url    string
+	token  string
+	client *http.Client
+	// mu keeps lines of concurrent crashes apart
+	mu sync.Mutex
+}
+
+func defaultConfig() Config {
+	return Config{
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.File == "" && cfg.URL == "" {
+		return fmt.Errorf("file or url is required")
+	}
+	return nil
+}
+
+func NewReporter(cfg Config) (Reporter, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaultConfig().HTTPClient
+	}
+	return &ReporterImpl{
+		file:   cfg.File,
+		url:    cfg.URL,
+		token:  cfg.Token,
+		client: cfg.HTTPClient,
+	}, nil
+}
+
+// Report sends crash to every sink, a failing sink does not keep it from the others
+func (r *ReporterImpl) Report(ctx context.Context, crash models.Crash) error {
+	body, err := json.Marshal(crash)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if r.file != "" {
+		if err := r.writeFile(body); err != nil {
+			errs = append(errs, fmt.Errorf("write %s: %w", r.file, err))
+		}
+	}
+	if r.url != "" {
+		if err := r.post(ctx, body); err != nil {
+			errs = append(errs, fmt.Errorf("post crash: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *ReporterImpl) writeFile(body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.OpenFile(r.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(body, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *ReporterImpl) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
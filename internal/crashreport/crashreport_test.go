@@ -0,0 +1,66 @@
+package crashreport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"telegram_server/internal/models"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	if _, err := NewReporter(Config{}); err == nil {
+		t.Error("expected a reporter without sinks to be rejected")
+	}
+
+	var got []models.Crash
+	var auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		var crash models.Crash
+		if err := json.NewDecoder(r.Body).Decode(&crash); err != nil {
+			t.Errorf("collector got invalid JSON: %v", err)
+		}
+		got = append(got, crash)
+	}))
+	defer collector.Close()
+
+	file := filepath.Join(t.TempDir(), "crashes.jsonl")
+	reporter, err := NewReporter(Config{File: file, URL: collector.URL, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash := models.Crash{RequestID: "req-1", Method: "POST", Path: "/webhook", Panic: "boom", Stack: "goroutine 1"}
+	for range 2 {
+		if err := reporter.Report(context.Background(), crash); err != nil {
+			t.Fatalf("Report: %v", err)
+		}
+	}
+
+	if len(got) != 2 || got[0] != crash || auth != "Bearer secret" {
+		t.Errorf("expected the collector to get both crashes with the token, got %+v with %q", got, auth)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"request_id":"req-1"`) {
+		t.Errorf("expected a line per crash, got %q", lines)
+	}
+
+	// A failing collector is reported, the file still gets the crash
+	collector.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if err := reporter.Report(context.Background(), crash); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the 503 of the collector, got %v", err)
+	}
+	if data, _ := os.ReadFile(file); strings.Count(string(data), "\n") != 3 {
+		t.Errorf("expected the crash in the file, got %s", data)
+	}
+}
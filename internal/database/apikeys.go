@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const apiKeyColumns = "id, name, prefix, hash, scopes, rate_limit, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row rowScanner, key *models.APIKey) error {
+	return row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, &key.Scopes, &key.RateLimit,
+		&key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+}
+
+// CreateAPIKey stores a new API key and returns its id
+func (db DatabaseImpl) CreateAPIKey(ctx context.Context, key models.APIKey) (int64, error) {
+	var id int64
+	err := db.pool.QueryRow(ctx,
+		"INSERT INTO api_keys (name, prefix, hash, scopes, rate_limit) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		key.Name, key.Prefix, key.Hash, key.Scopes, key.RateLimit).Scan(&id)
+	if err != nil {
+		db.logger.LogEvent("Error while creating API key: " + err.Error())
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetAPIKeyByPrefix returns the key with the given public prefix, revoked keys included
+func (db DatabaseImpl) GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, bool, error) {
+	var key models.APIKey
+	err := scanAPIKey(db.pool.QueryRow(ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return key, false, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while getting API key: " + err.Error())
+		return key, false, err
+	}
+	return key, true, nil
+}
+
+// ListAPIKeys returns all keys, newest first
+func (db DatabaseImpl) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := db.pool.Query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id DESC")
+	if err != nil {
+		db.logger.LogEvent("Error while listing API keys: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			db.logger.LogEvent("Error while scanning API key: " + err.Error())
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records that a key was just used
+func (db DatabaseImpl) TouchAPIKey(ctx context.Context, id int64) error {
+	_, err := db.pool.Exec(ctx, "UPDATE api_keys SET last_used_at = now() WHERE id = $1", id)
+	if err != nil {
+		db.logger.LogEvent("Error while updating API key last use: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// RevokeAPIKey disables a key, it reports false if there is no active key with the id
+func (db DatabaseImpl) RevokeAPIKey(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.pool.Exec(ctx,
+		"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		db.logger.LogEvent("Error while revoking API key: " + err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
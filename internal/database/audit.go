@@ -0,0 +1,24 @@
+package database
+
+import (
+	"context"
+	"telegram_server/internal/models"
+)
+
+// SaveAuditEntry appends an entry to the audit log and returns its id
+func (db DatabaseImpl) SaveAuditEntry(ctx context.Context, entry models.AuditEntry) (int64, error) {
+	details := entry.Details
+	if details == nil {
+		details = []byte("{}")
+	}
+
+	var id int64
+	err := db.pool.QueryRow(ctx,
+		"INSERT INTO audit_log (actor, action, details) VALUES ($1, $2, $3) RETURNING id",
+		entry.Actor, entry.Action, details).Scan(&id)
+	if err != nil {
+		db.logger.LogEvent("Error while saving audit entry: " + err.Error())
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// SaveBotMessage stores an outgoing message so it can be updated or deleted later.
+// expiresAt may be nil for messages that should live forever.
+func (db DatabaseImpl) SaveBotMessage(ctx context.Context, chatID int64, messageID int, text string, expiresAt *time.Time) error {
+	_, err := db.pool.Exec(ctx,
+		`INSERT INTO bot_messages (chat_id, message_id, text, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat_id, message_id)
+		DO UPDATE SET text = EXCLUDED.text, expires_at = EXCLUDED.expires_at, updated_at = now()`,
+		chatID, messageID, text, expiresAt)
+	if err != nil {
+		db.logger.LogEvent("Error while saving bot message: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// UpdateBotMessageText records a new text for an already sent message
+func (db DatabaseImpl) UpdateBotMessageText(ctx context.Context, chatID int64, messageID int, text string) error {
+	_, err := db.pool.Exec(ctx,
+		"UPDATE bot_messages SET text = $3, updated_at = now() WHERE chat_id = $1 AND message_id = $2",
+		chatID, messageID, text)
+	if err != nil {
+		db.logger.LogEvent("Error while updating bot message: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// MarkBotMessageDeleted flags a message as removed from the chat
+func (db DatabaseImpl) MarkBotMessageDeleted(ctx context.Context, chatID int64, messageID int) error {
+	_, err := db.pool.Exec(ctx,
+		"UPDATE bot_messages SET deleted_at = now(), updated_at = now() WHERE chat_id = $1 AND message_id = $2",
+		chatID, messageID)
+	if err != nil {
+		db.logger.LogEvent("Error while marking bot message deleted: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetExpiredBotMessages returns messages whose TTL has passed and which are not deleted yet
+func (db DatabaseImpl) GetExpiredBotMessages(ctx context.Context, now time.Time) ([]models.BotMessage, error) {
+	rows, err := db.pool.Query(ctx,
+		`SELECT id, chat_id, message_id, text, expires_at, created_at
+		FROM bot_messages
+		WHERE deleted_at IS NULL AND expires_at IS NOT NULL AND expires_at <= $1
+		ORDER BY expires_at`, now)
+	if err != nil {
+		db.logger.LogEvent("Error while getting expired bot messages: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.BotMessage
+
+	for rows.Next() {
+		var message models.BotMessage
+		if err := rows.Scan(&message.ID, &message.ChatID, &message.MessageID, &message.Text,
+			&message.ExpiresAt, &message.CreatedAt); err != nil {
+			db.logger.LogEvent("Error while scanning bot message: " + err.Error())
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return nil, err
+	}
+	db.logger.LogEvent("Retrieved " + strconv.Itoa(len(messages)) + " expired bot messages")
+	return messages, nil
+}
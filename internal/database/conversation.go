@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"telegram_server/internal/models"
+)
+
+// messageColumns is the column list scanned by scanMessage
+const messageColumns = `id, username, text, direction, COALESCE(chat_id, 0), COALESCE(message_id, 0),
+	reply_to, status, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row rowScanner, message *models.Message) error {
+	var replyTo *int64
+	err := row.Scan(&message.ID, &message.UserName, &message.Text, &message.Direction, &message.ChatID,
+		&message.MessageID, &replyTo, &message.Status, &message.CreatedAt, &message.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if replyTo != nil {
+		r := int(*replyTo)
+		message.ReplyTo = &r
+	}
+	return nil
+}
+
+// SaveChatMessage stores a message of a Telegram conversation in either direction
+// and returns its id. Empty direction and status default to an incoming, received message.
+func (db DatabaseImpl) SaveChatMessage(ctx context.Context, msg models.Message) (int64, error) {
+	if msg.Direction == "" {
+		msg.Direction = models.DirectionIncoming
+	}
+	if msg.Status == "" {
+		msg.Status = models.StatusReceived
+	}
+	var messageID *int
+	if msg.MessageID != 0 {
+		messageID = &msg.MessageID
+	}
+
+	var id int64
+	err := db.pool.QueryRow(ctx,
+		`INSERT INTO messages (username, text, direction, chat_id, message_id, reply_to, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		msg.UserName, msg.Text, msg.Direction, msg.ChatID, messageID, msg.ReplyTo, msg.Status).Scan(&id)
+	if err != nil {
+		db.logger.LogEvent("Error while saving chat message: " + err.Error())
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateMessageStatus sets the delivery status of a stored message and, once known,
+// the Telegram message id.
+func (db DatabaseImpl) UpdateMessageStatus(ctx context.Context, id int64, status string, messageID int) error {
+	var tgID *int
+	if messageID != 0 {
+		tgID = &messageID
+	}
+	_, err := db.pool.Exec(ctx,
+		`UPDATE messages SET status = $2, message_id = COALESCE($3, message_id), updated_at = now()
+		WHERE id = $1`, id, status, tgID)
+	if err != nil {
+		db.logger.LogEvent("Error while updating message status: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetConversation returns the transcript of a chat in chronological order
+func (db DatabaseImpl) GetConversation(ctx context.Context, chatID int64) ([]models.Message, error) {
+	rows, err := db.pool.Query(ctx,
+		"SELECT "+messageColumns+" FROM messages WHERE chat_id = $1 ORDER BY created_at, id", chatID)
+	if err != nil {
+		db.logger.LogEvent("Error while getting conversation: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+
+	for rows.Next() {
+		var message models.Message
+		if err := scanMessage(rows, &message); err != nil {
+			db.logger.LogEvent("Error while scanning message: " + err.Error())
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return nil, err
+	}
+	db.logger.LogEvent("Retrieved " + strconv.Itoa(len(messages)) + " messages of chat " + strconv.FormatInt(chatID, 10))
+	return messages, nil
+}
@@ -17,13 +17,73 @@ type DatabaseImpl struct {
 	configPool *pgxpool.Config
 	pool       *pgxpool.Pool
 	logger     Logger
+	// searchConfig is the text search configuration of messages
+	searchConfig string
 }
 
 type Database interface {
 	Connect(ctx context.Context) error
-	SaveMessage(ctx context.Context, username, text string) error
+	SaveMessage(ctx context.Context, username, text string) (models.Message, error)
+	ImportMessages(ctx context.Context, next func() ([]models.Message, error)) (int64, error)
+	ExportMessages(ctx context.Context, filter models.ExportFilter, fetchSize int, fn func(models.Message) error) error
+	SearchMessages(ctx context.Context, filter models.SearchFilter) (models.SearchPage, error)
+	SetSearchConfig(ctx context.Context, config string) error
+	IndexSearchBatch(ctx context.Context, size int) (int64, bool, error)
 	GetMessages(ctx context.Context) ([]models.Message, error)
+	SaveChatMessage(ctx context.Context, msg models.Message) (int64, error)
+	UpdateMessageStatus(ctx context.Context, id int64, status string, messageID int) error
+	GetConversation(ctx context.Context, chatID int64) ([]models.Message, error)
+	ListMessages(ctx context.Context, filter models.MessageFilter) (models.MessagePage, error)
+	GetMessage(ctx context.Context, id int64) (models.Message, bool, error)
+	UpdateMessage(ctx context.Context, id int64, username, text *string) (models.Message, bool, error)
+	DeleteMessage(ctx context.Context, id int64) (bool, error)
+	SaveBotMessage(ctx context.Context, chatID int64, messageID int, text string, expiresAt *time.Time) error
+	UpdateBotMessageText(ctx context.Context, chatID int64, messageID int, text string) error
+	MarkBotMessageDeleted(ctx context.Context, chatID int64, messageID int) error
+	GetExpiredBotMessages(ctx context.Context, now time.Time) ([]models.BotMessage, error)
+	SetChatMode(ctx context.Context, chatID int64, mode, operator string) error
+	GetChatMode(ctx context.Context, chatID int64) (models.ChatMode, error)
+	TouchChat(ctx context.Context, chatID int64) error
+	ListChatsInMode(ctx context.Context, mode string) ([]models.ChatMode, error)
+	ReleaseInactiveChats(ctx context.Context, before time.Time) ([]int64, error)
+	SaveOperatorForward(ctx context.Context, groupMessageID int, chatID int64) error
+	GetForwardedChat(ctx context.Context, groupMessageID int) (int64, bool, error)
+	SavePoll(ctx context.Context, poll models.Poll) error
+	ClosePoll(ctx context.Context, pollID string) error
+	SavePollVote(ctx context.Context, vote models.PollVote) error
+	GetPoll(ctx context.Context, pollID string) (models.Poll, bool, error)
+	GetPollResults(ctx context.Context, poll models.Poll) (models.PollResults, error)
+	GetPollVotes(ctx context.Context, pollID string) ([]models.PollVote, error)
+	CreateSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (models.Session, bool, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+	CreateAPIKey(ctx context.Context, key models.APIKey) (int64, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, bool, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64) error
+	RevokeAPIKey(ctx context.Context, id int64) (bool, error)
+	GetMessagesSince(ctx context.Context, afterID int64, filter models.MessageStreamFilter, limit int) ([]models.Message, error)
+	ListenMessages(ctx context.Context, listening func(), notify func(id int64)) error
+	ClaimIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, lockTimeout time.Duration) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (bool, error)
+	DeleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+	TakeRateLimitToken(ctx context.Context, key string, limit int, window time.Duration) (models.RateLimit, error)
+	DeleteFullRateLimitBuckets(ctx context.Context) (int64, error)
+	SaveAuditEntry(ctx context.Context, entry models.AuditEntry) (int64, error)
+	CreateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (models.WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id int64) (bool, error)
+	EnqueueWebhookDeliveries(ctx context.Context, event string, payload []byte) (int, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64) error
+	FailWebhookDelivery(ctx context.Context, id int64, lastError string, retryAt *time.Time) error
+	ListWebhookDeliveries(ctx context.Context, status string, limit int) ([]models.WebhookDelivery, error)
+	ReplayWebhookDelivery(ctx context.Context, id int64) (bool, error)
 	Ping() error
+	PingContext(ctx context.Context) error
+	PoolStats() models.PoolStats
 	CloseDB()
 }
 
@@ -36,8 +96,14 @@ type Config struct {
 	AllowAutocreate *bool
 	Logger          Logger
 	WithSSL         bool
+	// MaxConns bounds the pool. Streaming messages holds a connection for
+	// LISTEN and every running export or import holds one until it is done.
 	MaxConns        int
 	MaxConnLifetime time.Duration
+	// SearchConfig is the Postgres text search configuration used to index
+	// messages, e.g. english or russian. It is stored on the first start, the
+	// search-config command changes it later.
+	SearchConfig string
 }
 
 func defaultConfig() Config {
@@ -48,8 +114,9 @@ func defaultConfig() Config {
 	defaultPassword := "postgres"
 	allowAutocreate := true
 	defaultWithSSL := false
-	defaultMaxConns := 5
+	defaultMaxConns := 10
 	defaultMaxConnLifetime := 15 * time.Minute
+	defaultSearchConfig := "simple"
 
 	return Config{
 		DBName:          defaultDBName,
@@ -61,6 +128,7 @@ func defaultConfig() Config {
 		WithSSL:         defaultWithSSL,
 		MaxConns:        defaultMaxConns,
 		MaxConnLifetime: defaultMaxConnLifetime,
+		SearchConfig:    defaultSearchConfig,
 	}
 }
 
@@ -90,6 +158,9 @@ func applyDefaultsIfNotSet(cfg Config) Config {
 	if cfg.MaxConnLifetime == 0 {
 		cfg.MaxConnLifetime = def.MaxConnLifetime
 	}
+	if cfg.SearchConfig == "" {
+		cfg.SearchConfig = def.SearchConfig
+	}
 	return cfg
 }
 
@@ -126,19 +197,44 @@ func NewDatabase(cfg Config) (Database, error) {
 
 	configPool.MaxConns = int32(cfg.MaxConns)
 	configPool.MaxConnLifetime = cfg.MaxConnLifetime
+	configPool.ConnConfig.Tracer = newQueryTracer()
 
 	return &DatabaseImpl{
-		pool:       nil,
-		configPool: configPool,
-		logger:     cfg.Logger,
+		pool:         nil,
+		configPool:   configPool,
+		logger:       cfg.Logger,
+		searchConfig: cfg.SearchConfig,
 	}, nil
 }
 
 func (d *DatabaseImpl) Ping() error {
+	return d.PingContext(context.Background())
+}
+
+// PingContext is Ping giving up when ctx is done
+func (d *DatabaseImpl) PingContext(ctx context.Context) error {
 	if d.pool == nil {
 		return fmt.Errorf("No database connection.")
 	}
-	return d.pool.Ping(context.Background())
+	return d.pool.Ping(ctx)
+}
+
+// PoolStats reports the connection pool, it is zero before Connect
+func (d *DatabaseImpl) PoolStats() models.PoolStats {
+	if d.pool == nil {
+		return models.PoolStats{}
+	}
+	stat := d.pool.Stat()
+	return models.PoolStats{
+		AcquiredConns:        stat.AcquiredConns(),
+		IdleConns:            stat.IdleConns(),
+		TotalConns:           stat.TotalConns(),
+		MaxConns:             stat.MaxConns(),
+		AcquireCount:         stat.AcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+	}
 }
 
 func (d *DatabaseImpl) Connect(ctx context.Context) error {
@@ -149,6 +245,12 @@ func (d *DatabaseImpl) Connect(ctx context.Context) error {
 		return err
 	}
 
+	if err := d.migrate(ctx); err != nil {
+		d.pool.Close()
+		d.pool = nil
+		return err
+	}
+
 	d.logger.LogEvent("Connected to database")
 	return nil
 }
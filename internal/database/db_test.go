@@ -51,8 +51,8 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.WithSSL != false {
 		t.Errorf("expected WithSSL false, got %v", cfg.WithSSL)
 	}
-	if cfg.MaxConns != 5 {
-		t.Errorf("expected MaxConns 5, got %d", cfg.MaxConns)
+	if cfg.MaxConns != 10 {
+		t.Errorf("expected MaxConns 10, got %d", cfg.MaxConns)
 	}
 	if cfg.MaxConnLifetime != 15*time.Minute {
 		t.Errorf("expected MaxConnLifetime 15m, got %v", cfg.MaxConnLifetime)
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"telegram_server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// buildExportQuery selects the messages of filter in id order
+func buildExportQuery(filter models.ExportFilter) (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.UserName != "" {
+		conds = append(conds, "username = "+arg(filter.UserName))
+	}
+	if filter.ChatID != nil {
+		conds = append(conds, "chat_id = "+arg(*filter.ChatID))
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(filter.To))
+	}
+	if filter.AfterID > 0 {
+		conds = append(conds, "id > "+arg(filter.AfterID))
+	}
+
+	query := "SELECT " + messageColumns + " FROM messages"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return query + " ORDER BY id", args
+}
+
+// ExportMessages calls fn for every message matching filter in id order. Rows
+// are read through a server side cursor fetchSize at a time, so memory does not
+// grow with the export, and from one snapshot. An error from fn stops the export.
+func (db DatabaseImpl) ExportMessages(ctx context.Context, filter models.ExportFilter, fetchSize int, fn func(models.Message) error) error {
+	tx, err := db.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		db.logger.LogEvent("Error while starting message export: " + err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query, args := buildExportQuery(filter)
+	if _, err := tx.Exec(ctx, "DECLARE message_export NO SCROLL CURSOR FOR "+query, args...); err != nil {
+		db.logger.LogEvent("Error while declaring export cursor: " + err.Error())
+		return err
+	}
+
+	fetch := "FETCH FORWARD " + strconv.Itoa(fetchSize) + " FROM message_export"
+	for {
+		rows, err := tx.Query(ctx, fetch)
+		if err != nil {
+			db.logger.LogEvent("Error while fetching exported messages: " + err.Error())
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			var message models.Message
+			if err := scanMessage(rows, &message); err != nil {
+				rows.Close()
+				db.logger.LogEvent("Error while scanning message: " + err.Error())
+				return err
+			}
+			fetched++
+			if err := fn(message); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			db.logger.LogEvent("Error after scanning rows: " + err.Error())
+			return err
+		}
+		if fetched < fetchSize {
+			return tx.Commit(ctx)
+		}
+	}
+}
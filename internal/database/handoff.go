@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SetChatMode switches a chat between the bot and a human operator
+func (db DatabaseImpl) SetChatMode(ctx context.Context, chatID int64, mode, operator string) error {
+	_, err := db.pool.Exec(ctx,
+		`INSERT INTO chat_modes (chat_id, mode, operator) VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id)
+		DO UPDATE SET mode = EXCLUDED.mode, operator = EXCLUDED.operator,
+			last_activity_at = now(), updated_at = now()`,
+		chatID, mode, operator)
+	if err != nil {
+		db.logger.LogEvent("Error while setting chat mode: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetChatMode returns the mode of a chat, chats never switched are answered by the bot
+func (db DatabaseImpl) GetChatMode(ctx context.Context, chatID int64) (models.ChatMode, error) {
+	mode := models.ChatMode{ChatID: chatID, Mode: models.ModeBot}
+	err := db.pool.QueryRow(ctx,
+		"SELECT mode, operator, last_activity_at, updated_at FROM chat_modes WHERE chat_id = $1", chatID).
+		Scan(&mode.Mode, &mode.Operator, &mode.LastActivityAt, &mode.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return mode, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while getting chat mode: " + err.Error())
+		return mode, err
+	}
+	return mode, nil
+}
+
+// TouchChat records activity in a chat, postponing the automatic return to the bot
+func (db DatabaseImpl) TouchChat(ctx context.Context, chatID int64) error {
+	_, err := db.pool.Exec(ctx,
+		"UPDATE chat_modes SET last_activity_at = now() WHERE chat_id = $1", chatID)
+	if err != nil {
+		db.logger.LogEvent("Error while updating chat activity: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// ListChatsInMode returns all chats currently in the given mode
+func (db DatabaseImpl) ListChatsInMode(ctx context.Context, mode string) ([]models.ChatMode, error) {
+	rows, err := db.pool.Query(ctx,
+		`SELECT chat_id, mode, operator, last_activity_at, updated_at
+		FROM chat_modes WHERE mode = $1 ORDER BY last_activity_at DESC`, mode)
+	if err != nil {
+		db.logger.LogEvent("Error while listing chats: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []models.ChatMode
+
+	for rows.Next() {
+		var chat models.ChatMode
+		if err := rows.Scan(&chat.ChatID, &chat.Mode, &chat.Operator, &chat.LastActivityAt, &chat.UpdatedAt); err != nil {
+			db.logger.LogEvent("Error while scanning chat mode: " + err.Error())
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return nil, err
+	}
+	return chats, nil
+}
+
+// ReleaseInactiveChats hands chats without activity since before back to the bot
+// and returns their ids.
+func (db DatabaseImpl) ReleaseInactiveChats(ctx context.Context, before time.Time) ([]int64, error) {
+	rows, err := db.pool.Query(ctx,
+		`UPDATE chat_modes SET mode = $1, operator = '', updated_at = now()
+		WHERE mode = $2 AND last_activity_at < $3
+		RETURNING chat_id`, models.ModeBot, models.ModeHuman, before)
+	if err != nil {
+		db.logger.LogEvent("Error while releasing inactive chats: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chatIDs []int64
+
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			db.logger.LogEvent("Error while scanning chat id: " + err.Error())
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return nil, err
+	}
+	return chatIDs, nil
+}
+
+// SaveOperatorForward remembers which chat a message in the operators' group came from
+func (db DatabaseImpl) SaveOperatorForward(ctx context.Context, groupMessageID int, chatID int64) error {
+	_, err := db.pool.Exec(ctx,
+		`INSERT INTO operator_forwards (group_message_id, chat_id) VALUES ($1, $2)
+		ON CONFLICT (group_message_id) DO UPDATE SET chat_id = EXCLUDED.chat_id`,
+		groupMessageID, chatID)
+	if err != nil {
+		db.logger.LogEvent("Error while saving operator forward: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetForwardedChat returns the chat a message in the operators' group was forwarded from
+func (db DatabaseImpl) GetForwardedChat(ctx context.Context, groupMessageID int) (int64, bool, error) {
+	var chatID int64
+	err := db.pool.QueryRow(ctx,
+		"SELECT chat_id FROM operator_forwards WHERE group_message_id = $1", groupMessageID).Scan(&chatID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while getting forwarded chat: " + err.Error())
+		return 0, false, err
+	}
+	return chatID, true, nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const idempotencyColumns = "scope, key, request_hash, status, content_type, body, created_at, expires_at"
+
+func scanIdempotencyRecord(row rowScanner, rec *models.IdempotencyRecord) error {
+	return row.Scan(&rec.Scope, &rec.Key, &rec.RequestHash, &rec.Status, &rec.ContentType, &rec.Body,
+		&rec.CreatedAt, &rec.ExpiresAt)
+}
+
+// ClaimIdempotencyKey reserves a key for the request described by record. It
+// returns true when the caller owns the key and must handle the request,
+// otherwise it returns the record of the request that used the key first.
+// Expired keys and keys of requests for the same body that did not finish
+// within lockTimeout are taken over, the claim token of record marks the new owner.
+func (db DatabaseImpl) ClaimIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, lockTimeout time.Duration) (models.IdempotencyRecord, bool, error) {
+	for {
+		tag, err := db.pool.Exec(ctx,
+			`INSERT INTO idempotency_keys (scope, key, request_hash, expires_at, claim_token) VALUES ($1, $2, $3, $4, $6)
+			ON CONFLICT (scope, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = 0,
+				content_type = '', body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at,
+				claim_token = EXCLUDED.claim_token
+			WHERE idempotency_keys.expires_at <= now()
+				OR (idempotency_keys.status = 0 AND idempotency_keys.request_hash = EXCLUDED.request_hash
+					AND idempotency_keys.created_at <= now() - make_interval(secs => $5))`,
+			record.Scope, record.Key, record.RequestHash, record.ExpiresAt, lockTimeout.Seconds(), record.ClaimToken)
+		if err != nil {
+			db.logger.LogEvent("Error while claiming idempotency key: " + err.Error())
+			return record, false, err
+		}
+		if tag.RowsAffected() > 0 {
+			return record, true, nil
+		}
+
+		var existing models.IdempotencyRecord
+		err = scanIdempotencyRecord(db.pool.QueryRow(ctx,
+			"SELECT "+idempotencyColumns+" FROM idempotency_keys WHERE scope = $1 AND key = $2",
+			record.Scope, record.Key), &existing)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Released by its owner in between, try again
+			continue
+		}
+		if err != nil {
+			db.logger.LogEvent("Error while getting idempotency key: " + err.Error())
+			return record, false, err
+		}
+		return existing, false, nil
+	}
+}
+
+// CompleteIdempotencyKey stores the response of the request owning the key. It
+// returns false when the key was taken over by a retry in the meantime.
+func (db DatabaseImpl) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (bool, error) {
+	tag, err := db.pool.Exec(ctx,
+		`UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5
+		WHERE scope = $1 AND key = $2 AND claim_token = $6 AND status = 0`,
+		record.Scope, record.Key, record.Status, record.ContentType, record.Body, record.ClaimToken)
+	if err != nil {
+		db.logger.LogEvent("Error while completing idempotency key: " + err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteIdempotencyKey releases a key so the request can be retried. A key
+// taken over by a retry is left to it.
+func (db DatabaseImpl) DeleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error {
+	_, err := db.pool.Exec(ctx,
+		"DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND claim_token = $3 AND status = 0",
+		record.Scope, record.Key, record.ClaimToken)
+	if err != nil {
+		db.logger.LogEvent("Error while deleting idempotency key: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys removes expired keys and returns how many were removed
+func (db DatabaseImpl) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+	if err != nil {
+		db.logger.LogEvent("Error while deleting expired idempotency keys: " + err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"telegram_server/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ImportMessages copies the batches returned by next into messages until next
+// returns an empty batch. All batches are saved in one transaction, so nothing
+// is saved when next or a copy fails, callers importing long histories call it
+// once per part to commit as they go. It returns the number of saved messages.
+// The chat, direction and creation time of the messages are kept, messages
+// without a creation time are created now. Imported rows are not announced to
+// message streams, see notify_message_saved.
+func (db DatabaseImpl) ImportMessages(ctx context.Context, next func() ([]models.Message, error)) (int64, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		db.logger.LogEvent("Error while starting message import: " + err.Error())
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// A notification per row would flood the streams when the import commits
+	if _, err := tx.Exec(ctx, "SET LOCAL app.importing = 'on'"); err != nil {
+		db.logger.LogEvent("Error while starting message import: " + err.Error())
+		return 0, err
+	}
+
+	var imported int64
+	for {
+		batch, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		now := time.Now()
+		n, err := tx.CopyFrom(ctx, pgx.Identifier{"messages"}, importColumns,
+			pgx.CopyFromSlice(len(batch), func(i int) ([]any, error) {
+				return importRow(batch[i], now), nil
+			}))
+		if err != nil {
+			db.logger.LogEvent("Error while importing messages: " + err.Error())
+			return 0, err
+		}
+		imported += n
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		db.logger.LogEvent("Error while committing message import: " + err.Error())
+		return 0, err
+	}
+	return imported, nil
+}
+
+var importColumns = []string{"username", "text", "chat_id", "message_id", "reply_to", "direction", "status", "created_at", "updated_at"}
+
+// importRow returns the values of importColumns for msg
+func importRow(msg models.Message, now time.Time) []any {
+	var chatID, messageID any
+	if msg.ChatID != 0 {
+		chatID = msg.ChatID
+	}
+	if msg.MessageID != 0 {
+		messageID = int64(msg.MessageID)
+	}
+	direction := msg.Direction
+	if direction == "" {
+		direction = models.DirectionIncoming
+	}
+	status := msg.Status
+	if status == "" {
+		status = models.StatusReceived
+	}
+	createdAt := msg.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	return []any{msg.UserName, msg.Text, chatID, messageID, msg.ReplyTo, direction, status, createdAt, createdAt}
+}
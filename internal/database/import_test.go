@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+// Imported rows are not streamed, the first notification after an import is
+// the next saved message. Needs the local database of defaultConfig.
+func TestImportMessages_NoNotifications(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Logger = &testLogger{}
+	cfg.DBName = "botdb_test"
+	db, err := NewDatabase(cfg)
+	if err != nil {
+		t.Skip("Requires actual database - skipping: " + err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.Connect(ctx); err != nil {
+		t.Skip("Requires actual database - skipping: " + err.Error())
+	}
+	defer db.CloseDB()
+
+	listening := make(chan struct{})
+	ids := make(chan int64, 16)
+	go db.ListenMessages(ctx, func() { close(listening) }, func(id int64) { ids <- id })
+	<-listening
+
+	batches := [][]models.Message{{{UserName: "old", Text: "first"}, {UserName: "old", Text: "second"}}, nil}
+	imported, err := db.ImportMessages(ctx, func() ([]models.Message, error) {
+		batch := batches[0]
+		batches = batches[1:]
+		return batch, nil
+	})
+	if err != nil || imported != 2 {
+		t.Fatalf("expected 2 imported messages, got %d, %v", imported, err)
+	}
+	id, err := db.SaveChatMessage(ctx, models.Message{UserName: "live", Text: "after the import",
+		Direction: models.DirectionIncoming, Status: models.StatusReceived})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-ids:
+		if got != id {
+			t.Errorf("expected only the saved message %d to be announced, got %d first", id, got)
+		}
+	case <-ctx.Done():
+		t.Fatal("the saved message was not announced")
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"telegram_server/internal/models"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// buildListMessagesQuery builds a keyset pagination query for filter. One row more than
+// the page size is requested to find out whether there is a next page.
+func buildListMessagesQuery(filter models.MessageFilter) (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.UserName != "" {
+		conds = append(conds, "username = "+arg(filter.UserName))
+	}
+	if filter.ChatID != nil {
+		conds = append(conds, "chat_id = "+arg(*filter.ChatID))
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(filter.To))
+	}
+	if filter.Text != "" {
+		conds = append(conds, "text ILIKE "+arg("%"+escapeLike(filter.Text)+"%"))
+	}
+
+	order, cmp := "DESC", "<"
+	if filter.Ascending {
+		order, cmp = "ASC", ">"
+	}
+	if filter.After != nil {
+		conds = append(conds, "(created_at, id) "+cmp+" ("+arg(filter.After.CreatedAt)+", "+arg(filter.After.ID)+")")
+	}
+
+	query := "SELECT " + messageColumns + " FROM messages"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY created_at " + order + ", id " + order
+	query += " LIMIT " + arg(pageSize(filter.Limit)+1)
+	return query, args
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+	return limit
+}
+
+// escapeLike escapes LIKE wildcards so that s is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ListMessages returns a page of messages matching filter
+func (db DatabaseImpl) ListMessages(ctx context.Context, filter models.MessageFilter) (models.MessagePage, error) {
+	var page models.MessagePage
+
+	query, args := buildListMessagesQuery(filter)
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		db.logger.LogEvent("Error while listing messages: " + err.Error())
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message models.Message
+		if err := scanMessage(rows, &message); err != nil {
+			db.logger.LogEvent("Error while scanning message: " + err.Error())
+			return page, err
+		}
+		page.Messages = append(page.Messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return page, err
+	}
+
+	if size := pageSize(filter.Limit); len(page.Messages) > size {
+		page.Messages = page.Messages[:size]
+		last := page.Messages[size-1]
+		page.Next = &models.MessageCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	return page, nil
+}
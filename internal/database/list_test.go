@@ -0,0 +1,76 @@
+package database
+
+import (
+	"reflect"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+func TestBuildListMessagesQuery(t *testing.T) {
+	chatID := int64(7)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	after := models.MessageCursor{CreatedAt: from.Add(time.Hour), ID: 10}
+
+	tests := []struct {
+		name      string
+		filter    models.MessageFilter
+		wantQuery string
+		wantArgs  []any
+	}{
+		{
+			name:      "no filters",
+			filter:    models.MessageFilter{},
+			wantQuery: "SELECT " + messageColumns + " FROM messages ORDER BY created_at DESC, id DESC LIMIT $1",
+			wantArgs:  []any{defaultPageSize + 1},
+		},
+		{
+			name: "all filters ascending after cursor",
+			filter: models.MessageFilter{
+				UserName:  "ann",
+				ChatID:    &chatID,
+				From:      from,
+				Text:      "50%_off",
+				Ascending: true,
+				Limit:     10,
+				After:     &after,
+			},
+			wantQuery: "SELECT " + messageColumns + " FROM messages WHERE username = $1 AND chat_id = $2 " +
+				"AND created_at >= $3 AND text ILIKE $4 AND (created_at, id) > ($5, $6) " +
+				"ORDER BY created_at ASC, id ASC LIMIT $7",
+			wantArgs: []any{"ann", int64(7), from, `%50\%\_off%`, after.CreatedAt, int64(10), 11},
+		},
+		{
+			name:      "limit is capped",
+			filter:    models.MessageFilter{Limit: 100000},
+			wantQuery: "SELECT " + messageColumns + " FROM messages ORDER BY created_at DESC, id DESC LIMIT $1",
+			wantArgs:  []any{maxPageSize + 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := buildListMessagesQuery(tt.filter)
+			if query != tt.wantQuery {
+				t.Errorf("query:\n got %s\nwant %s", query, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args: got %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	cursor := models.MessageCursor{CreatedAt: time.Date(2025, 5, 6, 7, 8, 9, 123456000, time.UTC), ID: 42}
+	decoded, err := models.DecodeMessageCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.ID != cursor.ID {
+		t.Errorf("expected %+v, got %+v", cursor, decoded)
+	}
+	if _, err := models.DecodeMessageCursor("not a cursor"); err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+}
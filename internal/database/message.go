@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"telegram_server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetMessage returns a message by id, found is false if there is no such message
+func (db DatabaseImpl) GetMessage(ctx context.Context, id int64) (models.Message, bool, error) {
+	var message models.Message
+	err := scanMessage(db.pool.QueryRow(ctx, "SELECT "+messageColumns+" FROM messages WHERE id = $1", id), &message)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return message, false, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while getting message " + strconv.FormatInt(id, 10) + ": " + err.Error())
+		return message, false, err
+	}
+	return message, true, nil
+}
+
+// UpdateMessage changes the username and/or text of a message, nil fields are left as they are.
+// It returns the updated message, found is false if there is no such message.
+func (db DatabaseImpl) UpdateMessage(ctx context.Context, id int64, username, text *string) (models.Message, bool, error) {
+	var message models.Message
+
+	sets := []string{"updated_at = now()"}
+	args := []any{id}
+	if username != nil {
+		args = append(args, *username)
+		sets = append(sets, "username = $"+strconv.Itoa(len(args)))
+	}
+	if text != nil {
+		args = append(args, *text)
+		sets = append(sets, "text = $"+strconv.Itoa(len(args)))
+	}
+
+	row := db.pool.QueryRow(ctx,
+		"UPDATE messages SET "+strings.Join(sets, ", ")+" WHERE id = $1 RETURNING "+messageColumns, args...)
+	err := scanMessage(row, &message)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return message, false, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while updating message " + strconv.FormatInt(id, 10) + ": " + err.Error())
+		return message, false, err
+	}
+	return message, true, nil
+}
+
+// DeleteMessage removes a message, found is false if there was no such message
+func (db DatabaseImpl) DeleteMessage(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.pool.Exec(ctx, "DELETE FROM messages WHERE id = $1", id)
+	if err != nil {
+		db.logger.LogEvent("Error while deleting message " + strconv.FormatInt(id, 10) + ": " + err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SavePoll stores a poll sent by the bot
+func (db DatabaseImpl) SavePoll(ctx context.Context, poll models.Poll) error {
+	if poll.Type == "" {
+		poll.Type = models.PollRegular
+	}
+	_, err := db.pool.Exec(ctx,
+		`INSERT INTO polls (poll_id, chat_id, message_id, question, options, type, correct_option_id, is_closed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (poll_id) DO NOTHING`,
+		poll.ID, poll.ChatID, poll.MessageID, poll.Question, poll.Options, poll.Type, poll.CorrectOptionID, poll.IsClosed)
+	if err != nil {
+		db.logger.LogEvent("Error while saving poll: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// ClosePoll marks a poll as closed
+func (db DatabaseImpl) ClosePoll(ctx context.Context, pollID string) error {
+	_, err := db.pool.Exec(ctx,
+		"UPDATE polls SET is_closed = true, updated_at = now() WHERE poll_id = $1", pollID)
+	if err != nil {
+		db.logger.LogEvent("Error while closing poll: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// SavePollVote stores the current answer of a user, an empty optionIDs retracts the vote
+func (db DatabaseImpl) SavePollVote(ctx context.Context, vote models.PollVote) error {
+	var err error
+	if len(vote.OptionIDs) == 0 {
+		_, err = db.pool.Exec(ctx,
+			"DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2", vote.PollID, vote.UserID)
+	} else {
+		_, err = db.pool.Exec(ctx,
+			`INSERT INTO poll_votes (poll_id, user_id, username, option_ids) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (poll_id, user_id)
+			DO UPDATE SET username = EXCLUDED.username, option_ids = EXCLUDED.option_ids, updated_at = now()`,
+			vote.PollID, vote.UserID, vote.UserName, vote.OptionIDs)
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while saving poll vote: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetPoll returns a poll by its Telegram id, found is false if there is no such poll
+func (db DatabaseImpl) GetPoll(ctx context.Context, pollID string) (models.Poll, bool, error) {
+	var poll models.Poll
+	err := db.pool.QueryRow(ctx,
+		`SELECT poll_id, chat_id, message_id, question, options, type, correct_option_id, is_closed, created_at
+		FROM polls WHERE poll_id = $1`, pollID).
+		Scan(&poll.ID, &poll.ChatID, &poll.MessageID, &poll.Question, &poll.Options, &poll.Type,
+			&poll.CorrectOptionID, &poll.IsClosed, &poll.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return poll, false, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while getting poll: " + err.Error())
+		return poll, false, err
+	}
+	return poll, true, nil
+}
+
+// GetPollResults counts the votes for every option of a poll
+func (db DatabaseImpl) GetPollResults(ctx context.Context, poll models.Poll) (models.PollResults, error) {
+	results := models.PollResults{Poll: poll, Options: make([]models.PollOptionResult, len(poll.Options))}
+	for i, text := range poll.Options {
+		results.Options[i] = models.PollOptionResult{
+			ID:      i,
+			Text:    text,
+			Correct: poll.CorrectOptionID != nil && *poll.CorrectOptionID == i,
+		}
+	}
+
+	err := db.pool.QueryRow(ctx,
+		"SELECT count(*) FROM poll_votes WHERE poll_id = $1", poll.ID).Scan(&results.TotalVoters)
+	if err != nil {
+		db.logger.LogEvent("Error while counting poll voters: " + err.Error())
+		return results, err
+	}
+
+	rows, err := db.pool.Query(ctx,
+		`SELECT option_id, count(*) FROM poll_votes, unnest(option_ids) AS option_id
+		WHERE poll_id = $1 GROUP BY option_id`, poll.ID)
+	if err != nil {
+		db.logger.LogEvent("Error while getting poll results: " + err.Error())
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var optionID, votes int
+		if err := rows.Scan(&optionID, &votes); err != nil {
+			db.logger.LogEvent("Error while scanning poll result: " + err.Error())
+			return results, err
+		}
+		if optionID >= 0 && optionID < len(results.Options) {
+			results.Options[optionID].Votes = votes
+		}
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return results, err
+	}
+	return results, nil
+}
+
+// GetPollVotes returns every vote of a poll ordered by time
+func (db DatabaseImpl) GetPollVotes(ctx context.Context, pollID string) ([]models.PollVote, error) {
+	rows, err := db.pool.Query(ctx,
+		`SELECT poll_id, user_id, username, option_ids, updated_at
+		FROM poll_votes WHERE poll_id = $1 ORDER BY updated_at, user_id`, pollID)
+	if err != nil {
+		db.logger.LogEvent("Error while getting poll votes: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []models.PollVote
+
+	for rows.Next() {
+		var vote models.PollVote
+		if err := rows.Scan(&vote.PollID, &vote.UserID, &vote.UserName, &vote.OptionIDs, &vote.UpdatedAt); err != nil {
+			db.logger.LogEvent("Error while scanning poll vote: " + err.Error())
+			return nil, err
+		}
+		votes = append(votes, vote)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return nil, err
+	}
+	return votes, nil
+}
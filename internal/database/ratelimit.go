@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// refilled is the number of tokens in the bucket now, $2 tokens are added per $3 seconds
+const refilled = `LEAST($2::float8, rate_limit_buckets.tokens +
+	EXTRACT(EPOCH FROM now() - rate_limit_buckets.updated_at)::float8 * $2::float8 / $3::float8)`
+
+// TakeRateLimitToken takes a token from the bucket of key, which holds up to
+// limit tokens and refills completely in window. The bucket is updated only
+// when it has a token, refused requests read it afterwards to tell the wait.
+func (db DatabaseImpl) TakeRateLimitToken(ctx context.Context, key string, limit int, window time.Duration) (models.RateLimit, error) {
+	var tokens float64
+	err := db.pool.QueryRow(ctx,
+		`INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at)
+		VALUES ($1, $2::float8 - 1, now(), now() + make_interval(secs => $3::float8 / $2::float8))
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = `+refilled+` - 1,
+			updated_at = now(),
+			full_at = now() + make_interval(secs => ($2::float8 - `+refilled+` + 1) * $3::float8 / $2::float8)
+		WHERE `+refilled+` >= 1
+		RETURNING tokens`,
+		key, float64(limit), window.Seconds()).Scan(&tokens)
+	if err == nil {
+		return models.NewRateLimit(true, tokens, limit, window), nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		db.logger.LogEvent("Error while taking rate limit token: " + err.Error())
+		return models.RateLimit{}, err
+	}
+
+	err = db.pool.QueryRow(ctx, "SELECT "+refilled+" FROM rate_limit_buckets WHERE key = $1",
+		key, float64(limit), window.Seconds()).Scan(&tokens)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Deleted in between, it was full
+		tokens = float64(limit)
+	} else if err != nil {
+		db.logger.LogEvent("Error while reading rate limit bucket: " + err.Error())
+		return models.RateLimit{}, err
+	}
+	return models.NewRateLimit(false, tokens, limit, window), nil
+}
+
+// DeleteFullRateLimitBuckets removes buckets that refilled completely and
+// returns how many were removed
+func (db DatabaseImpl) DeleteFullRateLimitBuckets(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, "DELETE FROM rate_limit_buckets WHERE full_at <= now()")
+	if err != nil {
+		db.logger.LogEvent("Error while deleting full rate limit buckets: " + err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
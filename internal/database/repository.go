@@ -6,12 +6,15 @@ import (
 	"telegram_server/internal/models"
 )
 
-func (db DatabaseImpl) SaveMessage(ctx context.Context, username, text string) error {
-	_, err := db.pool.Exec(ctx, "INSERT INTO messages (username, text) VALUES ($1, $2)", username, text)
+// SaveMessage stores a message received over the API and returns it as saved
+func (db DatabaseImpl) SaveMessage(ctx context.Context, username, text string) (models.Message, error) {
+	var message models.Message
+	err := scanMessage(db.pool.QueryRow(ctx,
+		"INSERT INTO messages (username, text) VALUES ($1, $2) RETURNING "+messageColumns, username, text), &message)
 	if err != nil {
-		return err
+		return models.Message{}, err
 	}
-	return nil
+	return message, nil
 }
 
 func (db DatabaseImpl) GetMessages(ctx context.Context) ([]models.Message, error) {
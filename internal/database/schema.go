@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// schema contains statements that bring the database to the state expected
+// by the repository. Every statement must be idempotent.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		id       BIGSERIAL PRIMARY KEY,
+		username TEXT NOT NULL,
+		text     TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS bot_messages (
+		id         BIGSERIAL PRIMARY KEY,
+		chat_id    BIGINT NOT NULL,
+		message_id BIGINT NOT NULL,
+		text       TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMPTZ,
+		deleted_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (chat_id, message_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS bot_messages_expires_at_idx
+		ON bot_messages (expires_at) WHERE deleted_at IS NULL`,
+	`ALTER TABLE messages
+		ADD COLUMN IF NOT EXISTS direction  TEXT NOT NULL DEFAULT 'in',
+		ADD COLUMN IF NOT EXISTS chat_id    BIGINT,
+		ADD COLUMN IF NOT EXISTS message_id BIGINT,
+		ADD COLUMN IF NOT EXISTS reply_to   BIGINT,
+		ADD COLUMN IF NOT EXISTS status     TEXT NOT NULL DEFAULT 'received',
+		ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+	`CREATE INDEX IF NOT EXISTS messages_chat_id_created_at_idx
+		ON messages (chat_id, created_at, id)`,
+	`CREATE TABLE IF NOT EXISTS chat_modes (
+		chat_id          BIGINT PRIMARY KEY,
+		mode             TEXT NOT NULL DEFAULT 'bot',
+		operator         TEXT NOT NULL DEFAULT '',
+		last_activity_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS operator_forwards (
+		group_message_id BIGINT PRIMARY KEY,
+		chat_id          BIGINT NOT NULL,
+		created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS polls (
+		poll_id           TEXT PRIMARY KEY,
+		chat_id           BIGINT NOT NULL,
+		message_id        BIGINT NOT NULL,
+		question          TEXT NOT NULL,
+		options           TEXT[] NOT NULL,
+		type              TEXT NOT NULL DEFAULT 'regular',
+		correct_option_id INTEGER,
+		is_closed         BOOLEAN NOT NULL DEFAULT false,
+		created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS poll_votes (
+		poll_id    TEXT NOT NULL REFERENCES polls (poll_id) ON DELETE CASCADE,
+		user_id    BIGINT NOT NULL,
+		username   TEXT NOT NULL DEFAULT '',
+		option_ids INTEGER[] NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (poll_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id           TEXT PRIMARY KEY,
+		user_id      BIGINT NOT NULL,
+		username     TEXT NOT NULL DEFAULT '',
+		role         TEXT NOT NULL,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at   TIMESTAMPTZ NOT NULL,
+		last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id           BIGSERIAL PRIMARY KEY,
+		name         TEXT NOT NULL,
+		prefix       TEXT NOT NULL UNIQUE,
+		hash         BYTEA NOT NULL,
+		scopes       TEXT[] NOT NULL,
+		rate_limit   INTEGER NOT NULL DEFAULT 0,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_used_at TIMESTAMPTZ,
+		revoked_at   TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id         BIGSERIAL PRIMARY KEY,
+		actor      TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		details    JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope        TEXT NOT NULL,
+		key          TEXT NOT NULL,
+		request_hash BYTEA NOT NULL,
+		status       INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		body         BYTEA,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at   TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (scope, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at)`,
+	`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claim_token TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id         BIGSERIAL PRIMARY KEY,
+		url        TEXT NOT NULL,
+		events     TEXT[] NOT NULL,
+		secret     TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id              BIGSERIAL PRIMARY KEY,
+		subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+		event           TEXT NOT NULL,
+		payload         JSONB NOT NULL,
+		status          TEXT NOT NULL DEFAULT 'pending',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error      TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at    TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx
+		ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS webhook_deliveries_status_idx
+		ON webhook_deliveries (status, id)`,
+	// Every saved message is announced to the replicas streaming messages,
+	// except rows of imports which set app.importing
+	`CREATE OR REPLACE FUNCTION notify_message_saved() RETURNS trigger AS $$
+	BEGIN
+		IF current_setting('app.importing', true) = 'on' THEN
+			RETURN NEW;
+		END IF;
+		PERFORM pg_notify('` + messagesChannel + `', NEW.id::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'messages_saved_notify') THEN
+			CREATE TRIGGER messages_saved_notify AFTER INSERT ON messages
+				FOR EACH ROW EXECUTE FUNCTION notify_message_saved();
+		END IF;
+	END
+	$$`,
+	// Full-text search, the configuration is set by configureSearch and
+	// SetSearchConfig. reindexed_up_to is the last message id indexed with it,
+	// NULL once every message is.
+	`CREATE TABLE IF NOT EXISTS search_settings (
+		id     BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+		config REGCONFIG NOT NULL
+	)`,
+	`ALTER TABLE search_settings ADD COLUMN IF NOT EXISTS reindexed_up_to BIGINT`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector TSVECTOR`,
+	`CREATE OR REPLACE FUNCTION messages_search_vector() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector := to_tsvector(
+			COALESCE((SELECT config FROM search_settings), 'simple'::regconfig), COALESCE(NEW.text, ''));
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'messages_search_vector') THEN
+			CREATE TRIGGER messages_search_vector BEFORE INSERT OR UPDATE OF text ON messages
+				FOR EACH ROW EXECUTE FUNCTION messages_search_vector();
+		END IF;
+	END
+	$$`,
+	`CREATE INDEX IF NOT EXISTS messages_search_vector_idx ON messages USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS messages_created_at_id_idx ON messages (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS messages_username_created_at_idx ON messages (username, created_at, id)`,
+	// The q filter of GET /messages is a substring match, trigrams index it
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`CREATE INDEX IF NOT EXISTS messages_text_trgm_idx ON messages USING GIN (text gin_trgm_ops)`,
+	// Token buckets of the HTTP rate limits shared by the replicas, full buckets
+	// hold nothing worth keeping and are deleted
+	`CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+		key        TEXT PRIMARY KEY,
+		tokens     DOUBLE PRECISION NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL,
+		full_at    TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS rate_limit_buckets_full_at_idx ON rate_limit_buckets (full_at)`,
+}
+
+// migrate applies schema to the connected database
+func (d *DatabaseImpl) migrate(ctx context.Context) error {
+	for _, stmt := range schema {
+		if _, err := d.pool.Exec(ctx, stmt); err != nil {
+			d.logger.LogEvent("Error while applying database schema: " + err.Error())
+			return fmt.Errorf("apply schema: %w", err)
+		}
+	}
+	if err := d.configureSearch(ctx); err != nil {
+		d.logger.LogEvent("Error while configuring full-text search: " + err.Error())
+		return fmt.Errorf("configure search: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"telegram_server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+	// Matches are marked with control characters removed from the text, the
+	// snippet is HTML escaped before they become <mark> tags
+	snippetStart   = "\x02"
+	snippetStop    = "\x03"
+	headlineOption = `StartSel="` + snippetStart + `", StopSel="` + snippetStop + `", ` +
+		`MaxWords=35, MinWords=15, MaxFragments=3, FragmentDelimiter=" … "`
+)
+
+// configureSearch stores the text search configuration on the first start and
+// leaves the messages saved before search existed to IndexSearchBatch. Later
+// starts keep the stored configuration, SetSearchConfig changes it.
+func (d *DatabaseImpl) configureSearch(ctx context.Context) error {
+	if _, err := d.pool.Exec(ctx,
+		`INSERT INTO search_settings (config, reindexed_up_to) VALUES ($1::regconfig, 0)
+		ON CONFLICT (id) DO NOTHING`, d.searchConfig); err != nil {
+		return err
+	}
+	var config string
+	var same bool
+	err := d.pool.QueryRow(ctx, "SELECT config::text, config = $1::regconfig FROM search_settings", d.searchConfig).Scan(&config, &same)
+	if err != nil {
+		return err
+	}
+	if !same {
+		d.logger.LogEvent("Search uses the stored configuration " + config + " instead of " + d.searchConfig +
+			", run the search-config command to change it")
+	}
+	return nil
+}
+
+// SetSearchConfig changes the text search configuration of messages, new
+// messages use it at once and IndexSearchBatch indexes the others again
+func (db DatabaseImpl) SetSearchConfig(ctx context.Context, config string) error {
+	_, err := db.pool.Exec(ctx,
+		`INSERT INTO search_settings (config, reindexed_up_to) VALUES ($1::regconfig, 0)
+		ON CONFLICT (id) DO UPDATE SET config = EXCLUDED.config, reindexed_up_to = 0`, config)
+	if err != nil {
+		db.logger.LogEvent("Error while setting search configuration: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// IndexSearchBatch indexes the next size messages left to index with the
+// stored configuration. done is true once no message is left.
+func (db DatabaseImpl) IndexSearchBatch(ctx context.Context, size int) (indexed int64, done bool, err error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		db.logger.LogEvent("Error while starting search indexing: " + err.Error())
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The row lock keeps replicas from indexing the same batch
+	var config string
+	var upTo *int64
+	err = tx.QueryRow(ctx, "SELECT config::text, reindexed_up_to FROM search_settings FOR UPDATE").Scan(&config, &upTo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, true, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while reading search settings: " + err.Error())
+		return 0, false, err
+	}
+	if upTo == nil {
+		return 0, true, nil
+	}
+
+	var last *int64
+	err = tx.QueryRow(ctx,
+		"SELECT max(id) FROM (SELECT id FROM messages WHERE id > $1 ORDER BY id LIMIT $2) batch", *upTo, size).Scan(&last)
+	if err != nil {
+		db.logger.LogEvent("Error while finding messages to index: " + err.Error())
+		return 0, false, err
+	}
+	if last != nil {
+		tag, err := tx.Exec(ctx,
+			"UPDATE messages SET search_vector = to_tsvector($1::regconfig, COALESCE(text, '')) WHERE id > $2 AND id <= $3",
+			config, *upTo, *last)
+		if err != nil {
+			db.logger.LogEvent("Error while indexing messages for search: " + err.Error())
+			return 0, false, err
+		}
+		indexed = tag.RowsAffected()
+	}
+	// Messages saved from now on are indexed by the trigger, NULL marks the end
+	if _, err := tx.Exec(ctx, "UPDATE search_settings SET reindexed_up_to = $1", last); err != nil {
+		db.logger.LogEvent("Error while saving search indexing progress: " + err.Error())
+		return 0, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		db.logger.LogEvent("Error while committing search indexing: " + err.Error())
+		return 0, false, err
+	}
+	return indexed, last == nil, nil
+}
+
+// tsqueryTerm is the tsquery of one search term, config is the configuration column
+func tsqueryTerm(term models.SearchTerm, arg func(any) string) string {
+	var q string
+	switch {
+	case term.Phrase:
+		q = "phraseto_tsquery(config, " + arg(term.Text) + ")"
+	case term.Prefix:
+		// A quoted lexeme keeps the operators of to_tsquery out of user input
+		lexeme := "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(term.Text) + "':*"
+		q = "to_tsquery(config, " + arg(lexeme) + ")"
+	default:
+		q = "plainto_tsquery(config, " + arg(term.Text) + ")"
+	}
+	if term.Negate {
+		q = "!!" + q
+	}
+	return q
+}
+
+// buildSearchQuery builds the full-text search of filter, ranked best first.
+// One row more than the page size is requested to find out whether there is a
+// next page, snippets are only made for the returned rows.
+func buildSearchQuery(filter models.SearchFilter) (string, []any) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var groups []string
+	for _, group := range filter.Groups {
+		var terms []string
+		for _, term := range group {
+			terms = append(terms, tsqueryTerm(term, arg))
+		}
+		groups = append(groups, "("+strings.Join(terms, " && ")+")")
+	}
+	tsquery := strings.Join(groups, " || ")
+
+	conds := []string{"search_vector @@ query"}
+	if filter.UserName != "" {
+		conds = append(conds, "username = "+arg(filter.UserName))
+	}
+	if filter.ChatID != nil {
+		conds = append(conds, "chat_id = "+arg(*filter.ChatID))
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "created_at < "+arg(filter.To))
+	}
+	if filter.After != nil {
+		// Pages follow (rank, id), rows ranked like the cursor are split by id
+		conds = append(conds, "(ts_rank_cd(search_vector, query), id) < ("+arg(filter.After.Rank)+"::real, "+arg(filter.After.ID)+")")
+	}
+
+	query := "WITH settings AS (SELECT COALESCE((SELECT config FROM search_settings), 'simple'::regconfig) AS config), " +
+		"q AS (SELECT config, " + tsquery + " AS query FROM settings), " +
+		"hits AS (SELECT messages.*, ts_rank_cd(search_vector, query) AS rank FROM messages, q" +
+		" WHERE " + strings.Join(conds, " AND ") +
+		" ORDER BY rank DESC, id DESC LIMIT " + arg(searchPageSize(filter.Limit)+1) + ") " +
+		"SELECT " + messageColumns + ", rank, " +
+		"ts_headline(config, replace(replace(text, chr(2), ''), chr(3), ''), query, " + arg(headlineOption) + ") " +
+		"FROM hits, q ORDER BY rank DESC, id DESC"
+	return query, args
+}
+
+func searchPageSize(limit int) int {
+	if limit <= 0 {
+		return defaultSearchPageSize
+	}
+	return min(limit, maxSearchPageSize)
+}
+
+// highlight turns a headline into HTML with the matches in <mark> tags
+func highlight(headline string) string {
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(html.EscapeString(headline))
+}
+
+// SearchMessages finds the messages matching a full-text search
+func (db DatabaseImpl) SearchMessages(ctx context.Context, filter models.SearchFilter) (models.SearchPage, error) {
+	var page models.SearchPage
+
+	query, args := buildSearchQuery(filter)
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		db.logger.LogEvent("Error while searching messages: " + err.Error())
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit models.SearchHit
+		var headline string
+		scan := scannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &hit.Rank, &headline)...)
+		})
+		if err := scanMessage(scan, &hit.Message); err != nil {
+			db.logger.LogEvent("Error while scanning search result: " + err.Error())
+			return page, err
+		}
+		hit.Snippet = highlight(headline)
+		page.Hits = append(page.Hits, hit)
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.LogEvent("Error after scanning rows: " + err.Error())
+		return page, err
+	}
+
+	if size := searchPageSize(filter.Limit); len(page.Hits) > size {
+		page.Hits = page.Hits[:size]
+		last := page.Hits[size-1]
+		page.Next = &models.SearchCursor{Rank: last.Rank, ID: last.ID}
+	}
+	return page, nil
+}
+
+// scannerFunc adapts a function to rowScanner
+type scannerFunc func(dest ...any) error
+
+func (f scannerFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
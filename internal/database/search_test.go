@@ -0,0 +1,68 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"telegram_server/internal/models"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  [][]models.SearchTerm
+	}{
+		{`hello world`, [][]models.SearchTerm{{{Text: "hello"}, {Text: "world"}}}},
+		{`"order status" refund*`, [][]models.SearchTerm{{{Text: "order status", Phrase: true}, {Text: "refund", Prefix: true}}}},
+		{`invoice -"test mode" OR receipt -draft*`, [][]models.SearchTerm{
+			{{Text: "invoice"}, {Text: "test mode", Phrase: true, Negate: true}},
+			{{Text: "receipt"}, {Text: "draft", Prefix: true, Negate: true}},
+		}},
+		{`привет "" мир`, [][]models.SearchTerm{{{Text: "привет"}, {Text: "мир"}}}},
+	}
+	for _, tt := range tests {
+		got, err := models.ParseSearchQuery(tt.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{``, `  `, `"unterminated`, `OR refund`, `refund OR`, `-refund`, `a OR -b`, strings.Repeat("a ", models.MaxSearchTerms+1)} {
+		if _, err := models.ParseSearchQuery(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestBuildSearchQuery(t *testing.T) {
+	chatID := int64(7)
+	groups, err := models.ParseSearchQuery(`"order status" it's* OR -spam refund`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args := buildSearchQuery(models.SearchFilter{Groups: groups, ChatID: &chatID, Limit: 500,
+		After: &models.SearchCursor{Rank: 0.5, ID: 40}})
+
+	wantQuery := "(phraseto_tsquery(config, $1) && to_tsquery(config, $2)) || (!!plainto_tsquery(config, $3) && plainto_tsquery(config, $4)) AS query"
+	if !strings.Contains(query, wantQuery) {
+		t.Errorf("query does not contain %s:\n%s", wantQuery, query)
+	}
+	if !strings.Contains(query, "WHERE search_vector @@ query AND chat_id = $5 AND (ts_rank_cd(search_vector, query), id) < ($6::real, $7) ORDER BY rank DESC, id DESC LIMIT $8") {
+		t.Errorf("unexpected conditions or paging:\n%s", query)
+	}
+	wantArgs := []any{"order status", `'it''s':*`, "spam", "refund", int64(7), float32(0.5), int64(40), maxSearchPageSize + 1, headlineOption}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args: got %q, want %q", args, wantArgs)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("a <b>" + snippetStart + "refund" + snippetStop + " & more")
+	if want := "a &lt;b&gt;<mark>refund</mark> &amp; more"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateSession stores a new dashboard session
+func (db DatabaseImpl) CreateSession(ctx context.Context, session models.Session) error {
+	_, err := db.pool.Exec(ctx,
+		"INSERT INTO sessions (id, user_id, username, role, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		session.ID, session.UserID, session.UserName, session.Role, session.ExpiresAt)
+	if err != nil {
+		db.logger.LogEvent("Error while creating session: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetSession returns a session that has not expired yet and updates its last use time
+func (db DatabaseImpl) GetSession(ctx context.Context, id string) (models.Session, bool, error) {
+	var session models.Session
+	err := db.pool.QueryRow(ctx,
+		`UPDATE sessions SET last_seen_at = now()
+		WHERE id = $1 AND expires_at > now()
+		RETURNING id, user_id, username, role, created_at, expires_at, last_seen_at`, id).
+		Scan(&session.ID, &session.UserID, &session.UserName, &session.Role,
+			&session.CreatedAt, &session.ExpiresAt, &session.LastSeenAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return session, false, nil
+	}
+	if err != nil {
+		db.logger.LogEvent("Error while getting session: " + err.Error())
+		return session, false, err
+	}
+	return session, true, nil
+}
+
+// DeleteSession removes a session, e.g. on logout
+func (db DatabaseImpl) DeleteSession(ctx context.Context, id string) error {
+	_, err := db.pool.Exec(ctx, "DELETE FROM sessions WHERE id = $1", id)
+	if err != nil {
+		db.logger.LogEvent("Error while deleting session: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredSessions removes expired sessions and returns how many were removed
+func (db DatabaseImpl) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, "DELETE FROM sessions WHERE expires_at <= now()")
+	if err != nil {
+		db.logger.LogEvent("Error while deleting expired sessions: " + err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
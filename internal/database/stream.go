@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"telegram_server/internal/models"
+)
+
+// messagesChannel is the NOTIFY channel the ids of saved messages are sent on
+const messagesChannel = "messages_saved"
+
+// GetMessagesSince returns up to limit messages with an id above afterID in id order,
+// it is used to resume message streams.
+func (db DatabaseImpl) GetMessagesSince(ctx context.Context, afterID int64, filter models.MessageStreamFilter, limit int) ([]models.Message, error) {
+	query := "SELECT " + messageColumns + " FROM messages WHERE id > $1"
+	args := []any{afterID}
+	if filter.ChatID != nil {
+		args = append(args, *filter.ChatID)
+		query += " AND chat_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.UserName != "" {
+		args = append(args, filter.UserName)
+		query += " AND username = $" + strconv.Itoa(len(args))
+	}
+	args = append(args, limit)
+	query += " ORDER BY id LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		db.logger.LogEvent("Error while getting messages since " + strconv.FormatInt(afterID, 10) + ": " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var message models.Message
+		if err := scanMessage(rows, &message); err != nil {
+			db.logger.LogEvent("Error while scanning message: " + err.Error())
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// ListenMessages holds a connection listening for saved messages and calls notify
+// with their ids until ctx is done or the connection fails. listening is called
+// once notifications are delivered.
+func (db DatabaseImpl) ListenMessages(ctx context.Context, listening func(), notify func(id int64)) error {
+	conn, err := db.pool.Acquire(ctx)
+	if err != nil {
+		db.logger.LogEvent("Error while acquiring connection for LISTEN: " + err.Error())
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+messagesChannel); err != nil {
+		db.logger.LogEvent("Error while listening for messages: " + err.Error())
+		return err
+	}
+	defer func() {
+		// The connection goes back to the pool, it must not keep listening
+		if _, err := conn.Exec(context.Background(), "UNLISTEN *"); err != nil {
+			conn.Conn().Close(context.Background())
+		}
+	}()
+	listening()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			db.logger.LogEvent("Error while waiting for message notifications: " + err.Error())
+			return err
+		}
+		id, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			db.logger.LogEvent("Invalid message notification payload: " + notification.Payload)
+			continue
+		}
+		notify(id)
+	}
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer makes a client span of every query and COPY. Only queries made
+// for a traced request or update are traced, the polling of background jobs
+// would bury them otherwise.
+type queryTracer struct {
+	tracer trace.Tracer
+}
+
+// querySpanKey holds the span of the query in the context given back to pgx,
+// the span of the caller must not be ended when the query has none
+type querySpanKey struct{}
+
+func newQueryTracer() queryTracer {
+	return queryTracer{tracer: otel.Tracer("telegram_server/internal/database")}
+}
+
+// operation is the first keyword of a statement, e.g. SELECT
+func operation(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if i := strings.IndexAny(sql, " \t\n("); i > 0 {
+		sql = sql[:i]
+	}
+	return strings.ToUpper(sql)
+}
+
+func (t queryTracer) start(ctx context.Context, name string, attrs ...attribute.KeyValue) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, semconv.DBSystemNamePostgreSQL)...),
+	)
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (t queryTracer) end(ctx context.Context, rows int64, err error) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.response.affected_rows", rows))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	op := operation(data.SQL)
+	return t.start(ctx, op, semconv.DBOperationName(op), semconv.DBQueryText(data.SQL))
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.CommandTag.RowsAffected(), data.Err)
+}
+
+func (t queryTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := data.TableName.Sanitize()
+	return t.start(ctx, "COPY "+table, semconv.DBOperationName("COPY"), semconv.DBCollectionName(table))
+}
+
+func (t queryTracer) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, data.CommandTag.RowsAffected(), data.Err)
+}
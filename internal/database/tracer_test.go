@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQueryTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := queryTracer{tracer: provider.Tracer("test")}
+
+	// Queries of background jobs have no span to join
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("expected no span without a parent, got %d", len(spans))
+	}
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "GET /messages")
+	queryCtx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "\n\tUPDATE messages SET text = $1 WHERE id = $2"})
+	tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("UPDATE 1")})
+	queryCtx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT(1)"})
+	tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{Err: errors.New("canceled")})
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 query spans, got %d", len(spans))
+	}
+	if spans[0].Name != "UPDATE" || spans[0].Parent.SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("unexpected span %s with parent %s", spans[0].Name, spans[0].Parent.SpanID())
+	}
+	if spans[1].Name != "SELECT" || spans[1].Status.Code != codes.Error {
+		t.Errorf("expected a failed SELECT span, got %s %v", spans[1].Name, spans[1].Status)
+	}
+	if !request.IsRecording() {
+		t.Error("the span of the caller must be left running")
+	}
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"telegram_server/internal/models"
+	"time"
+)
+
+const webhookSubscriptionColumns = "id, url, events, secret, created_at"
+
+const webhookDeliveryColumns = `d.id, d.subscription_id, s.url, s.secret, d.event, d.payload, d.status,
+	d.attempts, d.next_attempt_at, d.last_error, d.created_at, d.delivered_at`
+
+func scanWebhookSubscription(row rowScanner, sub *models.WebhookSubscription) error {
+	return row.Scan(&sub.ID, &sub.URL, &sub.Events, &sub.Secret, &sub.CreatedAt)
+}
+
+func scanWebhookDelivery(row rowScanner, d *models.WebhookDelivery) error {
+	return row.Scan(&d.ID, &d.SubscriptionID, &d.URL, &d.Secret, &d.Event, &d.Payload, &d.Status,
+		&d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+}
+
+// CreateWebhookSubscription stores a subscription and returns it with its id
+func (db DatabaseImpl) CreateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (models.WebhookSubscription, error) {
+	var created models.WebhookSubscription
+	err := scanWebhookSubscription(db.pool.QueryRow(ctx,
+		"INSERT INTO webhook_subscriptions (url, events, secret) VALUES ($1, $2, $3) RETURNING "+webhookSubscriptionColumns,
+		sub.URL, sub.Events, sub.Secret), &created)
+	if err != nil {
+		db.logger.LogEvent("Error while creating webhook subscription: " + err.Error())
+		return created, err
+	}
+	return created, nil
+}
+
+// ListWebhookSubscriptions returns all subscriptions, oldest first
+func (db DatabaseImpl) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	rows, err := db.pool.Query(ctx, "SELECT "+webhookSubscriptionColumns+" FROM webhook_subscriptions ORDER BY id")
+	if err != nil {
+		db.logger.LogEvent("Error while listing webhook subscriptions: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []models.WebhookSubscription
+	for rows.Next() {
+		var sub models.WebhookSubscription
+		if err := scanWebhookSubscription(rows, &sub); err != nil {
+			db.logger.LogEvent("Error while scanning webhook subscription: " + err.Error())
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// DeleteWebhookSubscription removes a subscription with its deliveries, false means it does not exist
+func (db DatabaseImpl) DeleteWebhookSubscription(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.pool.Exec(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	if err != nil {
+		db.logger.LogEvent("Error while deleting webhook subscription: " + err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// EnqueueWebhookDeliveries queues the event for every subscription of its type
+// and returns the number of deliveries created
+func (db DatabaseImpl) EnqueueWebhookDeliveries(ctx context.Context, event string, payload []byte) (int, error) {
+	tag, err := db.pool.Exec(ctx,
+		`INSERT INTO webhook_deliveries (subscription_id, event, payload)
+		SELECT id, $1, $2 FROM webhook_subscriptions WHERE $1 = ANY (events)`, event, payload)
+	if err != nil {
+		db.logger.LogEvent("Error while queueing webhook deliveries: " + err.Error())
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries and postpones them by
+// lease, so other replicas skip them while they are being sent
+func (db DatabaseImpl) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	rows, err := db.pool.Query(ctx,
+		`UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $2)
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns, limit, lease.Seconds())
+	if err != nil {
+		db.logger.LogEvent("Error while claiming webhook deliveries: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := scanWebhookDelivery(rows, &d); err != nil {
+			db.logger.LogEvent("Error while scanning webhook delivery: " + err.Error())
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// CompleteWebhookDelivery marks a delivery as sent
+func (db DatabaseImpl) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	_, err := db.pool.Exec(ctx,
+		`UPDATE webhook_deliveries SET status = 'succeeded', attempts = attempts + 1,
+		last_error = '', delivered_at = now() WHERE id = $1`, id)
+	if err != nil {
+		db.logger.LogEvent("Error while completing webhook delivery " + strconv.FormatInt(id, 10) + ": " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// FailWebhookDelivery records a failed attempt. The delivery is tried again at
+// retryAt, without it the delivery moves to the dead letters.
+func (db DatabaseImpl) FailWebhookDelivery(ctx context.Context, id int64, lastError string, retryAt *time.Time) error {
+	_, err := db.pool.Exec(ctx,
+		`UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = $2,
+		status = CASE WHEN $3::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+		next_attempt_at = COALESCE($3, next_attempt_at)
+		WHERE id = $1`, id, lastError, retryAt)
+	if err != nil {
+		db.logger.LogEvent("Error while failing webhook delivery " + strconv.FormatInt(id, 10) + ": " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns up to limit deliveries with the given status, newest first
+func (db DatabaseImpl) ListWebhookDeliveries(ctx context.Context, status string, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := db.pool.Query(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = $1 ORDER BY d.id DESC LIMIT $2`, status, limit)
+	if err != nil {
+		db.logger.LogEvent("Error while listing webhook deliveries: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := scanWebhookDelivery(rows, &d); err != nil {
+			db.logger.LogEvent("Error while scanning webhook delivery: " + err.Error())
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ReplayWebhookDelivery queues a dead delivery again with fresh attempts, false
+// means there is no dead delivery with this id
+func (db DatabaseImpl) ReplayWebhookDelivery(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.pool.Exec(ctx,
+		`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, last_error = '',
+		next_attempt_at = now() WHERE id = $1 AND status = 'dead'`, id)
+	if err != nil {
+		db.logger.LogEvent("Error while replaying webhook delivery: " + err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
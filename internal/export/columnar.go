@@ -0,0 +1,325 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// The columnar format stores messages the way Parquet does: rows are grouped,
+// every row group holds one chunk per column and a JSON footer at the end of the
+// file tells where the chunks are.
+//
+//	"MSGCOL1\n"
+//	row groups, their column chunks in footer column order
+//	footer JSON
+//	footer length, uint32 little endian
+//	"MSGCOL1\n"
+//
+// int64 and timestamp chunks hold zigzag varint deltas from the previous value
+// of the chunk, timestamps are unix microseconds. string chunks hold every value
+// after its uvarint length. A reply_to of 0 means none.
+
+const columnarMagic = "MSGCOL1\n"
+
+// ColumnarFooter describes the content of a columnar file
+type ColumnarFooter struct {
+	Version   int                `json:"version"`
+	Columns   []ColumnarColumn   `json:"columns"`
+	RowGroups []ColumnarRowGroup `json:"row_groups"`
+	Rows      int64              `json:"rows"`
+}
+
+type ColumnarColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ColumnarRowGroup lists the chunks of a row group in column order
+type ColumnarRowGroup struct {
+	Rows   int             `json:"rows"`
+	Chunks []ColumnarChunk `json:"chunks"`
+}
+
+// ColumnarChunk is where a column chunk is in the file
+type ColumnarChunk struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// Column types
+const (
+	columnInt64     = "int64"
+	columnTimestamp = "timestamp"
+	columnString    = "string"
+)
+
+type columnDef struct {
+	ColumnarColumn
+	getInt func(m *models.Message) int64
+	setInt func(m *models.Message, v int64)
+	getStr func(m *models.Message) string
+	setStr func(m *models.Message, v string)
+}
+
+func intColumn(name, typ string, get func(m *models.Message) int64, set func(m *models.Message, v int64)) columnDef {
+	return columnDef{ColumnarColumn: ColumnarColumn{Name: name, Type: typ}, getInt: get, setInt: set}
+}
+
+func stringColumn(name string, get func(m *models.Message) string, set func(m *models.Message, v string)) columnDef {
+	return columnDef{ColumnarColumn: ColumnarColumn{Name: name, Type: columnString}, getStr: get, setStr: set}
+}
+
+var columnDefs = []columnDef{
+	intColumn("id", columnInt64, func(m *models.Message) int64 { return m.ID }, func(m *models.Message, v int64) { m.ID = v }),
+	intColumn("chat_id", columnInt64, func(m *models.Message) int64 { return m.ChatID }, func(m *models.Message, v int64) { m.ChatID = v }),
+	intColumn("message_id", columnInt64, func(m *models.Message) int64 { return int64(m.MessageID) }, func(m *models.Message, v int64) { m.MessageID = int(v) }),
+	intColumn("reply_to", columnInt64, func(m *models.Message) int64 {
+		if m.ReplyTo == nil {
+			return 0
+		}
+		return int64(*m.ReplyTo)
+	}, func(m *models.Message, v int64) {
+		if v != 0 {
+			r := int(v)
+			m.ReplyTo = &r
+		}
+	}),
+	stringColumn("username", func(m *models.Message) string { return m.UserName }, func(m *models.Message, v string) { m.UserName = v }),
+	stringColumn("direction", func(m *models.Message) string { return m.Direction }, func(m *models.Message, v string) { m.Direction = v }),
+	stringColumn("status", func(m *models.Message) string { return m.Status }, func(m *models.Message, v string) { m.Status = v }),
+	stringColumn("text", func(m *models.Message) string { return m.Text }, func(m *models.Message, v string) { m.Text = v }),
+	intColumn("created_at", columnTimestamp, func(m *models.Message) int64 { return m.CreatedAt.UnixMicro() },
+		func(m *models.Message, v int64) { m.CreatedAt = time.UnixMicro(v).UTC() }),
+	intColumn("updated_at", columnTimestamp, func(m *models.Message) int64 { return m.UpdatedAt.UnixMicro() },
+		func(m *models.Message, v int64) { m.UpdatedAt = time.UnixMicro(v).UTC() }),
+}
+
+func appendChunk(buf []byte, col columnDef, rows []models.Message) []byte {
+	if col.getStr != nil {
+		for i := range rows {
+			v := col.getStr(&rows[i])
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+		return buf
+	}
+	var prev int64
+	for i := range rows {
+		v := col.getInt(&rows[i])
+		buf = binary.AppendVarint(buf, v-prev)
+		prev = v
+	}
+	return buf
+}
+
+var errCorrupt = errors.New("corrupt columnar chunk")
+
+func decodeChunk(chunk []byte, col columnDef, rows []models.Message) error {
+	if col.getStr != nil {
+		for i := range rows {
+			n, size := binary.Uvarint(chunk)
+			if size <= 0 || n > uint64(len(chunk)-size) {
+				return errCorrupt
+			}
+			col.setStr(&rows[i], string(chunk[size:size+int(n)]))
+			chunk = chunk[size+int(n):]
+		}
+		return nil
+	}
+	var prev int64
+	for i := range rows {
+		delta, size := binary.Varint(chunk)
+		if size <= 0 {
+			return errCorrupt
+		}
+		prev += delta
+		col.setInt(&rows[i], prev)
+		chunk = chunk[size:]
+	}
+	return nil
+}
+
+// countingWriter tracks the offset of the next byte written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+type columnarWriter struct {
+	w            *countingWriter
+	rowGroupSize int
+	rows         []models.Message
+	footer       ColumnarFooter
+	buf          []byte
+	started      bool
+}
+
+func newColumnarWriter(w io.Writer, rowGroupSize int) *columnarWriter {
+	footer := ColumnarFooter{Version: 1, RowGroups: []ColumnarRowGroup{}}
+	for _, col := range columnDefs {
+		footer.Columns = append(footer.Columns, col.ColumnarColumn)
+	}
+	return &columnarWriter{
+		w:            &countingWriter{w: w},
+		rowGroupSize: max(rowGroupSize, 1),
+		footer:       footer,
+	}
+}
+
+func (c *columnarWriter) start() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	_, err := io.WriteString(c.w, columnarMagic)
+	return err
+}
+
+func (c *columnarWriter) Write(msg models.Message) error {
+	c.rows = append(c.rows, msg)
+	if len(c.rows) >= c.rowGroupSize {
+		return c.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group
+func (c *columnarWriter) flush() error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	if len(c.rows) == 0 {
+		return nil
+	}
+	group := ColumnarRowGroup{Rows: len(c.rows)}
+	for _, col := range columnDefs {
+		c.buf = appendChunk(c.buf[:0], col, c.rows)
+		group.Chunks = append(group.Chunks, ColumnarChunk{Offset: c.w.n, Size: int64(len(c.buf))})
+		if _, err := c.w.Write(c.buf); err != nil {
+			return err
+		}
+	}
+	c.footer.RowGroups = append(c.footer.RowGroups, group)
+	c.footer.Rows += int64(len(c.rows))
+	c.rows = c.rows[:0]
+	return nil
+}
+
+// Flush keeps the rows of an incomplete row group, row groups have a fixed size
+func (c *columnarWriter) Flush() error {
+	return nil
+}
+
+func (c *columnarWriter) Buffered() int {
+	return len(c.rows)
+}
+
+func (c *columnarWriter) Close() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	footer, err := json.Marshal(c.footer)
+	if err != nil {
+		return err
+	}
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, columnarMagic...)
+	_, err = c.w.Write(footer)
+	return err
+}
+
+// ReadColumnarFooter returns the footer of a columnar file of the given size
+func ReadColumnarFooter(r io.ReaderAt, size int64) (ColumnarFooter, error) {
+	var footer ColumnarFooter
+	tailSize := int64(4 + len(columnarMagic))
+	if size < int64(len(columnarMagic))+tailSize {
+		return footer, errors.New("not a columnar export: file too short")
+	}
+	head := make([]byte, len(columnarMagic))
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return footer, err
+	}
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil {
+		return footer, err
+	}
+	if string(head) != columnarMagic || string(tail[4:]) != columnarMagic {
+		return footer, errors.New("not a columnar export: bad magic")
+	}
+
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if footerSize > size-tailSize-int64(len(columnarMagic)) {
+		return footer, errors.New("not a columnar export: bad footer size")
+	}
+	raw := make([]byte, footerSize)
+	if _, err := r.ReadAt(raw, size-tailSize-footerSize); err != nil {
+		return footer, err
+	}
+	if err := json.NewDecoder(bytes.NewReader(raw)).Decode(&footer); err != nil {
+		return footer, fmt.Errorf("invalid columnar footer: %w", err)
+	}
+	return footer, nil
+}
+
+// ReadColumnar calls fn for every message of a columnar file of the given size,
+// one row group is held in memory at a time
+func ReadColumnar(r io.ReaderAt, size int64, fn func(models.Message) error) error {
+	footer, err := ReadColumnarFooter(r, size)
+	if err != nil {
+		return err
+	}
+
+	defs := make([]*columnDef, len(footer.Columns))
+	for i, col := range footer.Columns {
+		for j := range columnDefs {
+			if columnDefs[j].ColumnarColumn == col {
+				defs[i] = &columnDefs[j]
+			}
+		}
+	}
+
+	var chunk []byte
+	for _, group := range footer.RowGroups {
+		if len(group.Chunks) != len(defs) || group.Rows < 0 {
+			return errors.New("invalid columnar footer: row group does not match the columns")
+		}
+		rows := make([]models.Message, group.Rows)
+		for i, c := range group.Chunks {
+			if defs[i] == nil {
+				// Columns added by newer writers are skipped
+				continue
+			}
+			if c.Offset < 0 || c.Size < 0 || c.Offset+c.Size > size {
+				return errCorrupt
+			}
+			if int64(cap(chunk)) < c.Size {
+				chunk = make([]byte, c.Size)
+			}
+			chunk = chunk[:c.Size]
+			if _, err := r.ReadAt(chunk, c.Offset); err != nil {
+				return err
+			}
+			if err := decodeChunk(chunk, *defs[i], rows); err != nil {
+				return fmt.Errorf("column %s: %w", defs[i].Name, err)
+			}
+		}
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
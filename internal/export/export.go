@@ -0,0 +1,324 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Export writes the messages table as CSV, NDJSON or a columnar format for
+// analysts. Messages are streamed from a database cursor in id order, so an
+// interrupted export is resumed by passing the last exported id as after_id.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	ExportMessages(ctx context.Context, filter models.ExportFilter, fetchSize int, fn func(models.Message) error) error
+}
+
+type Exporter interface {
+	// Export writes the messages selected by opts to w
+	Export(ctx context.Context, w io.Writer, opts Options) (Summary, error)
+	Handler(w http.ResponseWriter, r *http.Request)
+}
+
+type Config struct {
+	Logger   Logger
+	Database Database
+	// FetchSize is the number of rows read from the cursor at once
+	FetchSize int
+	// RowGroupSize is the number of rows of a columnar row group
+	RowGroupSize int
+	// MaxConcurrent bounds the downloads running at once, each holds a
+	// database connection and a snapshot until it is done
+	MaxConcurrent int
+}
+
+type ExporterImpl struct {
+	logger       Logger
+	database     Database
+	fetchSize    int
+	rowGroupSize int
+	// running has a slot per download
+	running chan struct{}
+}
+
+// Options selects the messages and the format of an export
+type Options struct {
+	Format string
+	Filter models.ExportFilter
+	Gzip   bool
+}
+
+// Summary reports the messages that reached the writer of an export, LastID
+// is where to resume it from. Rows still buffered by a failed export are not
+// counted.
+type Summary struct {
+	Count  int64
+	LastID int64
+}
+
+// Trailers sent after the body of GET /messages/export
+const (
+	TrailerCount  = "X-Export-Count"
+	TrailerLastID = "X-Export-Last-ID"
+)
+
+const (
+	// exportTimeout replaces the server write timeout for downloads
+	exportTimeout = time.Hour
+	// busyRetryAfter is the Retry-After of downloads refused while others run
+	busyRetryAfter = "30"
+)
+
+func defaultConfig() Config {
+	return Config{
+		FetchSize:     1000,
+		RowGroupSize:  8192,
+		MaxConcurrent: 2,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	if cfg.FetchSize < 0 || cfg.RowGroupSize < 0 || cfg.MaxConcurrent < 0 {
+		return fmt.Errorf("sizes must not be negative")
+	}
+	return nil
+}
+
+func NewExporter(cfg Config) (Exporter, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.FetchSize == 0 {
+		cfg.FetchSize = def.FetchSize
+	}
+	if cfg.RowGroupSize == 0 {
+		cfg.RowGroupSize = def.RowGroupSize
+	}
+	if cfg.MaxConcurrent == 0 {
+		cfg.MaxConcurrent = def.MaxConcurrent
+	}
+
+	return &ExporterImpl{
+		logger:       cfg.Logger,
+		database:     cfg.Database,
+		fetchSize:    cfg.FetchSize,
+		rowGroupSize: cfg.RowGroupSize,
+		running:      make(chan struct{}, cfg.MaxConcurrent),
+	}, nil
+}
+
+// ParseTime reads an RFC 3339 time or a date, which means its midnight in UTC
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// ParseQuery reads the options of GET /messages/export: format, from, to,
+// chat_id, username, after_id and compress=gzip
+func ParseQuery(query url.Values) (Options, []httperror.FieldError) {
+	var fields []httperror.FieldError
+	opts := Options{
+		Format: FormatCSV,
+		Filter: models.ExportFilter{UserName: query.Get("username")},
+	}
+
+	if s := query.Get("format"); s != "" {
+		if !slices.Contains(Formats, s) {
+			fields = append(fields, httperror.FieldError{Field: "format", Message: "must be one of " + strings.Join(Formats, ", ")})
+		}
+		opts.Format = s
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &opts.Filter.From}, {"to", &opts.Filter.To}} {
+		if s := query.Get(p.name); s != "" {
+			t, err := ParseTime(s)
+			if err != nil {
+				fields = append(fields, httperror.FieldError{Field: p.name, Message: "must be an RFC 3339 time or a date"})
+			}
+			*p.dst = t
+		}
+	}
+	if !opts.Filter.From.IsZero() && !opts.Filter.To.IsZero() && !opts.Filter.From.Before(opts.Filter.To) {
+		fields = append(fields, httperror.FieldError{Field: "to", Message: "must be after from"})
+	}
+	if s := query.Get("chat_id"); s != "" {
+		chatID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fields = append(fields, httperror.FieldError{Field: "chat_id", Message: "must be an integer"})
+		}
+		opts.Filter.ChatID = &chatID
+	}
+	if s := query.Get("after_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 0 {
+			fields = append(fields, httperror.FieldError{Field: "after_id", Message: "must be a non-negative integer"})
+		}
+		opts.Filter.AfterID = id
+	}
+	switch query.Get("compress") {
+	case "":
+	case "gzip":
+		opts.Gzip = true
+	default:
+		fields = append(fields, httperror.FieldError{Field: "compress", Message: "must be gzip"})
+	}
+	return opts, fields
+}
+
+// FileName is the suggested name of an export file
+func FileName(opts Options) string {
+	name := "messages." + Extension(opts.Format)
+	if opts.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+func (e *ExporterImpl) Export(ctx context.Context, w io.Writer, opts Options) (Summary, error) {
+	var summary Summary
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	out, err := NewWriter(opts.Format, w, e.rowGroupSize)
+	if err != nil {
+		return summary, err
+	}
+
+	// The summary moves every fetchSize rows, after the rows were flushed to w
+	var pending []int64
+	var rows int
+	written := func(n int) {
+		if n > 0 {
+			summary.Count += int64(n)
+			summary.LastID = pending[n-1]
+			pending = append(pending[:0], pending[n:]...)
+		}
+	}
+	flush := func() error {
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+		written(len(pending) - out.Buffered())
+		return nil
+	}
+
+	err = e.database.ExportMessages(ctx, opts.Filter, e.fetchSize, func(msg models.Message) error {
+		if err := out.Write(msg); err != nil {
+			return err
+		}
+		pending = append(pending, msg.ID)
+		if rows++; rows%e.fetchSize == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+	if err := out.Close(); err != nil {
+		return summary, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return summary, err
+		}
+	}
+	written(len(pending))
+	return summary, nil
+}
+
+// GET Handler /messages/export (download messages as CSV, NDJSON or columnar)
+func (e *ExporterImpl) Handler(w http.ResponseWriter, r *http.Request) {
+	opts, fields := ParseQuery(r.URL.Query())
+	if fields != nil {
+		httperror.Write(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "invalid query parameters", fields...)
+		return
+	}
+
+	// Downloads beyond MaxConcurrent would take the connections of other requests
+	select {
+	case e.running <- struct{}{}:
+		defer func() { <-e.running }()
+	default:
+		w.Header().Set("Retry-After", busyRetryAfter)
+		httperror.Write(w, r, http.StatusServiceUnavailable, httperror.CodeUnavailable, "too many exports running, retry later")
+		return
+	}
+
+	// Large exports take longer than the server write timeout allows
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+
+	contentType := ContentType(opts.Format)
+	if opts.Gzip {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+FileName(opts)+`"`)
+	w.Header().Set("Trailer", TrailerCount+", "+TrailerLastID)
+
+	// Nothing is sent before the first rows arrive, so a failing query still gets an error response
+	sw := &startWriter{w: w}
+	summary, err := e.Export(r.Context(), sw, opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// The client went away
+			return
+		}
+		e.logger.LogEvent("Error while exporting messages: " + err.Error())
+		if !sw.started {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Trailer")
+			httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not export messages")
+			return
+		}
+		// The status is gone, cut the connection so the client does not take the file as complete
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Header().Set(TrailerCount, strconv.FormatInt(summary.Count, 10))
+	w.Header().Set(TrailerLastID, strconv.FormatInt(summary.LastID, 10))
+	e.logger.LogEvent("Exported " + strconv.FormatInt(summary.Count, 10) + " messages as " + opts.Format)
+}
+
+// startWriter records whether anything was written to the response
+type startWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startWriter) Write(b []byte) (int, error) {
+	s.started = true
+	return s.w.Write(b)
+}
@@ -0,0 +1,342 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+// fakeDatabase filters messages by chat and AfterID, err fails the export.
+// With hold set exports signal entered and wait for hold to be closed.
+type fakeDatabase struct {
+	messages []models.Message
+	err      error
+	entered  chan struct{}
+	hold     chan struct{}
+}
+
+func (f *fakeDatabase) ExportMessages(ctx context.Context, filter models.ExportFilter, fetchSize int, fn func(models.Message) error) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.hold != nil {
+		f.entered <- struct{}{}
+		<-f.hold
+	}
+	for _, msg := range f.messages {
+		if msg.ID <= filter.AfterID || (filter.ChatID != nil && msg.ChatID != *filter.ChatID) {
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testMessages(n int) []models.Message {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	var messages []models.Message
+	for i := 1; i <= n; i++ {
+		msg := models.Message{
+			ID:        int64(i),
+			UserName:  "user" + strconv.Itoa(i%3),
+			Text:      "message, \"quoted\"\n" + strings.Repeat("я", i),
+			ChatID:    int64(100 + i%2),
+			MessageID: 1000 + i,
+			Direction: "in",
+			Status:    "sent",
+			CreatedAt: created.Add(time.Duration(i) * time.Minute),
+			UpdatedAt: created.Add(time.Duration(i) * time.Hour),
+		}
+		if i%4 == 0 {
+			replyTo := 1000 + i - 1
+			msg.ReplyTo = &replyTo
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func newTestExporter(t *testing.T, db *fakeDatabase) *ExporterImpl {
+	t.Helper()
+	e, err := NewExporter(Config{Logger: testLogger{}, Database: db, RowGroupSize: 3})
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	return e.(*ExporterImpl)
+}
+
+func equalMessages(t *testing.T, got, want []models.Message) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.ID != w.ID || g.UserName != w.UserName || g.Text != w.Text || g.ChatID != w.ChatID ||
+			g.MessageID != w.MessageID || g.Direction != w.Direction || g.Status != w.Status ||
+			!g.CreatedAt.Equal(w.CreatedAt) || !g.UpdatedAt.Equal(w.UpdatedAt) ||
+			(g.ReplyTo == nil) != (w.ReplyTo == nil) || (g.ReplyTo != nil && *g.ReplyTo != *w.ReplyTo) {
+			t.Errorf("message %d: expected %+v, got %+v", i, w, g)
+		}
+	}
+}
+
+func TestExport_Formats(t *testing.T) {
+	messages := testMessages(8)
+	e := newTestExporter(t, &fakeDatabase{messages: messages})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		summary, err := e.Export(context.Background(), &buf, Options{Format: FormatCSV})
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 9 || strings.Join(records[0], ",") != strings.Join(csvColumns, ",") {
+			t.Fatalf("unexpected CSV header or length: %v", records[0])
+		}
+		if records[4][3] != "1003" || records[4][7] != messages[3].Text || records[1][8] != "2026-03-01T12:01:00.123456Z" {
+			t.Errorf("unexpected row %v", records[4])
+		}
+		if summary.Count != 8 || summary.LastID != 8 {
+			t.Errorf("unexpected summary %+v", summary)
+		}
+	})
+
+	t.Run("ndjson gzip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := e.Export(context.Background(), &buf, Options{Format: FormatNDJSON, Gzip: true}); err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []models.Message
+		decoder := json.NewDecoder(gz)
+		for {
+			var msg models.Message
+			if err := decoder.Decode(&msg); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, msg)
+		}
+		equalMessages(t, got, messages)
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := e.Export(context.Background(), &buf, Options{Format: FormatColumnar}); err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(buf.Bytes())
+		footer, err := ReadColumnarFooter(r, r.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if footer.Rows != 8 || len(footer.RowGroups) != 3 || footer.RowGroups[2].Rows != 2 {
+			t.Errorf("expected 8 rows in groups of 3, got %+v", footer)
+		}
+		var got []models.Message
+		if err := ReadColumnar(r, r.Size(), func(msg models.Message) error {
+			got = append(got, msg)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		equalMessages(t, got, messages)
+	})
+
+	t.Run("empty columnar", func(t *testing.T) {
+		var buf bytes.Buffer
+		empty := newTestExporter(t, &fakeDatabase{})
+		if _, err := empty.Export(context.Background(), &buf, Options{Format: FormatColumnar}); err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(buf.Bytes())
+		if footer, err := ReadColumnarFooter(r, r.Size()); err != nil || footer.Rows != 0 {
+			t.Errorf("expected an empty file, got %+v, %v", footer, err)
+		}
+	})
+}
+
+// limitedWriter fails once n bytes were written
+type limitedWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if l.buf.Len()+len(b) > l.n {
+		n, _ := l.buf.Write(b[:l.n-l.buf.Len()])
+		return n, errors.New("disk full")
+	}
+	return l.buf.Write(b)
+}
+
+// A failed export reports only rows that reached the writer, resuming after
+// LastID must not skip rows that were still buffered
+func TestExport_ResumeAfterFailure(t *testing.T) {
+	messages := testMessages(40)
+	e, err := NewExporter(Config{Logger: testLogger{}, Database: &fakeDatabase{messages: messages}, FetchSize: 2, RowGroupSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []Options{{Format: FormatCSV}, {Format: FormatNDJSON}, {Format: FormatNDJSON, Gzip: true}, {Format: FormatColumnar}} {
+		t.Run(FileName(opts), func(t *testing.T) {
+			var full bytes.Buffer
+			if _, err := e.Export(context.Background(), &full, opts); err != nil {
+				t.Fatal(err)
+			}
+			out := &limitedWriter{n: full.Len() / 2}
+			summary, err := e.Export(context.Background(), out, opts)
+			if err == nil || summary.Count == 0 || summary.LastID >= int64(len(messages)) {
+				t.Fatalf("expected the export to fail halfway, got %+v, %v", summary, err)
+			}
+			if summary.LastID != messages[summary.Count-1].ID {
+				t.Errorf("expected the last id of %d rows, got %d", summary.Count, summary.LastID)
+			}
+
+			data := out.buf.Bytes()
+			if opts.Gzip {
+				gz, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ = io.ReadAll(gz)
+			}
+			var ids []string
+			switch opts.Format {
+			case FormatCSV:
+				r := csv.NewReader(bytes.NewReader(data))
+				r.Read()
+				for record, err := r.Read(); err == nil; record, err = r.Read() {
+					ids = append(ids, record[0])
+				}
+			case FormatNDJSON:
+				for _, line := range strings.Split(string(data), "\n") {
+					var msg models.Message
+					if json.Unmarshal([]byte(line), &msg) == nil {
+						ids = append(ids, strconv.FormatInt(msg.ID, 10))
+					}
+				}
+			case FormatColumnar:
+				if summary.Count%3 != 0 {
+					t.Errorf("expected whole row groups, got %+v", summary)
+				}
+				return
+			}
+			if int64(len(ids)) < summary.Count || ids[summary.Count-1] != strconv.FormatInt(summary.LastID, 10) {
+				t.Errorf("expected rows up to %d in the output, got %v", summary.LastID, ids)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	messages := testMessages(6)
+	e := newTestExporter(t, &fakeDatabase{messages: messages})
+
+	// Resuming after id 4 of chat 100 leaves message 6
+	rr := httptest.NewRecorder()
+	e.Handler(rr, httptest.NewRequest("GET", "/messages/export?format=ndjson&chat_id=100&after_id=4", nil))
+	resp := rr.Result()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), `filename="messages.ndjson"`) {
+		t.Errorf("unexpected Content-Disposition %q", resp.Header.Get("Content-Disposition"))
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], `{"id":6,`) {
+		t.Errorf("expected message 6 only, got %s", body)
+	}
+	if resp.Trailer.Get(TrailerCount) != "1" || resp.Trailer.Get(TrailerLastID) != "6" {
+		t.Errorf("unexpected trailers %v", resp.Trailer)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		db     *fakeDatabase
+		status int
+		field  string
+	}{
+		{"unknown format", "format=xlsx", &fakeDatabase{}, http.StatusUnprocessableEntity, "format"},
+		{"bad from", "from=yesterday", &fakeDatabase{}, http.StatusUnprocessableEntity, "from"},
+		{"empty range", "from=2026-03-02&to=2026-03-01", &fakeDatabase{}, http.StatusUnprocessableEntity, "to"},
+		{"bad after_id", "after_id=-1", &fakeDatabase{}, http.StatusUnprocessableEntity, "after_id"},
+		{"bad compress", "compress=zstd", &fakeDatabase{}, http.StatusUnprocessableEntity, "compress"},
+		{"database failed", "", &fakeDatabase{err: errors.New("connection refused")}, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		newTestExporter(t, tt.db).Handler(rr, httptest.NewRequest("GET", "/messages/export?"+tt.query, nil))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, rr.Code)
+		}
+		if tt.field != "" && !strings.Contains(rr.Body.String(), `"field":"`+tt.field+`"`) {
+			t.Errorf("%s: expected error on %s: %s", tt.name, tt.field, rr.Body.String())
+		}
+		if rr.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%s: error responses must not be attachments", tt.name)
+		}
+	}
+}
+
+func TestHandler_Busy(t *testing.T) {
+	db := &fakeDatabase{messages: testMessages(2), entered: make(chan struct{}, 1), hold: make(chan struct{})}
+	e, err := NewExporter(Config{Logger: testLogger{}, Database: db, MaxConcurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		e.Handler(rr, httptest.NewRequest("GET", "/messages/export", nil))
+		done <- rr.Code
+	}()
+	<-db.entered
+
+	rr := httptest.NewRecorder()
+	e.Handler(rr, httptest.NewRequest("GET", "/messages/export", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After while another export runs, got %d %v", rr.Code, rr.Header())
+	}
+
+	close(db.hold)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected the running export to finish, got %d", code)
+	}
+	rr = httptest.NewRecorder()
+	e.Handler(rr, httptest.NewRequest("GET", "/messages/export", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the slot to be free again, got %d", rr.Code)
+	}
+}
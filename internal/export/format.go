@@ -0,0 +1,139 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV      = "csv"
+	FormatNDJSON   = "ndjson"
+	FormatColumnar = "columnar"
+)
+
+// Formats lists the supported formats
+var Formats = []string{FormatCSV, FormatNDJSON, FormatColumnar}
+
+// Writer writes messages in one format, Close writes what is buffered and any
+// trailing data but does not close the underlying writer. Flush writes the
+// buffered rows the format allows to, Buffered is the number it kept back.
+type Writer interface {
+	Write(msg models.Message) error
+	Flush() error
+	Buffered() int
+	Close() error
+}
+
+// NewWriter returns a writer for format
+func NewWriter(format string, w io.Writer, rowGroupSize int) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w), nil
+	case FormatNDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w)}, nil
+	case FormatColumnar:
+		return newColumnarWriter(w, rowGroupSize), nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// ContentType is the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// Extension is the file name extension of a format
+func Extension(format string) string {
+	if format == FormatColumnar {
+		return "msgcol"
+	}
+	return format
+}
+
+// csvColumns is the header row of CSV exports
+var csvColumns = []string{"id", "chat_id", "message_id", "reply_to", "username", "direction", "status", "text", "created_at", "updated_at"}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Write(msg models.Message) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	replyTo := ""
+	if msg.ReplyTo != nil {
+		replyTo = strconv.Itoa(*msg.ReplyTo)
+	}
+	return c.w.Write([]string{
+		strconv.FormatInt(msg.ID, 10),
+		strconv.FormatInt(msg.ChatID, 10),
+		strconv.Itoa(msg.MessageID),
+		replyTo,
+		msg.UserName,
+		msg.Direction,
+		msg.Status,
+		msg.Text,
+		msg.CreatedAt.UTC().Format(time.RFC3339Nano),
+		msg.UpdatedAt.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Buffered() int {
+	return 0
+}
+
+func (c *csvWriter) Close() error {
+	if !c.header {
+		// Empty exports still describe their columns
+		c.header = true
+		c.w.Write(csvColumns)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(msg models.Message) error {
+	return n.encoder.Encode(msg)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+func (n *ndjsonWriter) Buffered() int {
+	return 0
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,271 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health answers the liveness and readiness probes. Liveness only tells the
+// process is serving requests. Readiness runs the checks registered for the
+// components the server depends on: a failing component fails readiness unless
+// it is optional, then the server is reported degraded. Check results are kept
+// for CacheTTL so frequent probes do not hammer the dependencies, and readiness
+// fails as soon as Shutdown is called so load balancers stop sending traffic.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+// Check returns an error when a component cannot be used
+type Check func(ctx context.Context) error
+
+// Component is a dependency checked by readiness
+type Component struct {
+	Name  string
+	Check Check
+	// Optional components do not fail readiness
+	Optional bool
+}
+
+type Checker interface {
+	Register(c Component)
+	// Ready runs the checks, or reuses their cached results
+	Ready(ctx context.Context) Report
+	// Shutdown makes readiness fail from now on
+	Shutdown()
+	LiveHandler(w http.ResponseWriter, r *http.Request)
+	ReadyHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type Config struct {
+	Logger Logger
+	// Timeout bounds every check
+	Timeout time.Duration
+	// CacheTTL is how long a check result is reused
+	CacheTTL time.Duration
+}
+
+type CheckerImpl struct {
+	logger     Logger
+	timeout    time.Duration
+	cacheTTL   time.Duration
+	started    time.Time
+	now        func() time.Time
+	mu         sync.Mutex
+	components []*component
+	stopping   atomic.Bool
+}
+
+// Statuses of components and of the report
+const (
+	StatusOK           = "ok"
+	StatusDegraded     = "degraded"
+	StatusFail         = "fail"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Result is the outcome of one component check
+type Result struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Optional   bool      `json:"optional,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Report is the body of GET /readyz
+type Report struct {
+	Status     string   `json:"status"`
+	Components []Result `json:"components"`
+}
+
+// Liveness is the body of GET /healthz
+type Liveness struct {
+	Status        string `json:"status"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Timeout:  2 * time.Second,
+		CacheTTL: 5 * time.Second,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Timeout < 0 || cfg.CacheTTL < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	return nil
+}
+
+func NewChecker(cfg Config) (Checker, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.Timeout == 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = def.CacheTTL
+	}
+
+	return &CheckerImpl{
+		logger:   cfg.Logger,
+		timeout:  cfg.Timeout,
+		cacheTTL: cfg.CacheTTL,
+		started:  time.Now(),
+		now:      time.Now,
+	}, nil
+}
+
+// component keeps the last result of a check. Probes arriving while the check
+// runs wait for it instead of starting another one.
+type component struct {
+	Component
+	mu      sync.Mutex
+	last    Result
+	running chan struct{}
+}
+
+// Register adds a component to readiness, it panics on a duplicate name
+func (c *CheckerImpl) Register(comp Component) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.components {
+		if existing.Name == comp.Name {
+			panic("health: component " + comp.Name + " is already registered")
+		}
+	}
+	c.components = append(c.components, &component{Component: comp})
+}
+
+func (c *CheckerImpl) Shutdown() {
+	if !c.stopping.Swap(true) {
+		c.logger.LogEvent("Readiness is failing, the server is shutting down")
+	}
+}
+
+// Ready checks every component at the same time
+func (c *CheckerImpl) Ready(ctx context.Context) Report {
+	if c.stopping.Load() {
+		return Report{Status: StatusShuttingDown, Components: []Result{}}
+	}
+
+	c.mu.Lock()
+	components := append([]*component(nil), c.components...)
+	c.mu.Unlock()
+
+	report := Report{Status: StatusOK, Components: make([]Result, len(components))}
+	var wg sync.WaitGroup
+	for i, comp := range components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Components[i] = c.result(ctx, comp)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Components {
+		switch {
+		case result.Status == StatusOK:
+		case result.Optional:
+			if report.Status == StatusOK {
+				report.Status = StatusDegraded
+			}
+		default:
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// result returns the cached result of comp or checks it again
+func (c *CheckerImpl) result(ctx context.Context, comp *component) Result {
+	comp.mu.Lock()
+	if !comp.last.CheckedAt.IsZero() && c.now().Sub(comp.last.CheckedAt) < c.cacheTTL {
+		last := comp.last
+		comp.mu.Unlock()
+		return last
+	}
+	if running := comp.running; running != nil {
+		comp.mu.Unlock()
+		select {
+		case <-running:
+			comp.mu.Lock()
+			defer comp.mu.Unlock()
+			return comp.last
+		case <-ctx.Done():
+			return Result{Name: comp.Name, Status: StatusFail, Optional: comp.Optional, Error: ctx.Err().Error(), CheckedAt: c.now()}
+		}
+	}
+	running := make(chan struct{})
+	comp.running = running
+	comp.mu.Unlock()
+
+	// The result is shared, a probe giving up must not fail it for the others
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	start := c.now()
+	err := comp.Check(checkCtx)
+	result := Result{
+		Name:       comp.Name,
+		Status:     StatusOK,
+		Optional:   comp.Optional,
+		DurationMS: c.now().Sub(start).Milliseconds(),
+		CheckedAt:  c.now(),
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	comp.mu.Lock()
+	if err != nil && comp.last.Error != result.Error {
+		c.logger.LogEvent("Health check " + comp.Name + " failed: " + result.Error)
+	} else if err == nil && comp.last.Status == StatusFail {
+		c.logger.LogEvent("Health check " + comp.Name + " recovered")
+	}
+	comp.last = result
+	comp.running = nil
+	comp.mu.Unlock()
+	close(running)
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GET Handler /healthz (the process is alive)
+func (c *CheckerImpl) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Liveness{
+		Status:        StatusOK,
+		UptimeSeconds: int64(c.now().Sub(c.started).Seconds()),
+	})
+}
+
+// GET Handler /readyz (the server can take traffic)
+func (c *CheckerImpl) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	report := c.Ready(r.Context())
+	status := http.StatusOK
+	if report.Status == StatusFail || report.Status == StatusShuttingDown {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,122 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+func newTestChecker(t *testing.T) *CheckerImpl {
+	t.Helper()
+	c, err := NewChecker(Config{Logger: testLogger{}, Timeout: 50 * time.Millisecond, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewChecker: %v", err)
+	}
+	return c.(*CheckerImpl)
+}
+
+func readyz(t *testing.T, c *CheckerImpl) (int, Report) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	c.ReadyHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, report
+}
+
+func TestReadyHandler(t *testing.T) {
+	c := newTestChecker(t)
+	var dbErr, botErr error
+	c.Register(Component{Name: "database", Check: func(ctx context.Context) error { return dbErr }})
+	c.Register(Component{Name: "bot", Optional: true, Check: func(ctx context.Context) error { return botErr }})
+	c.Register(Component{Name: "slow", Optional: true, Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	tests := []struct {
+		name          string
+		dbErr, botErr error
+		status        int
+		report        string
+	}{
+		{"optional timeout", nil, nil, http.StatusOK, StatusDegraded},
+		{"optional failure", nil, errors.New("telegram getMe failed: 401 Unauthorized"), http.StatusOK, StatusDegraded},
+		{"required failure", errors.New("connection refused"), nil, http.StatusServiceUnavailable, StatusFail},
+	}
+	clock := time.Now()
+	c.now = func() time.Time { return clock }
+	for _, tt := range tests {
+		dbErr, botErr = tt.dbErr, tt.botErr
+		// Expire the cached results
+		clock = clock.Add(time.Hour)
+		status, report := readyz(t, c)
+		if status != tt.status || report.Status != tt.report {
+			t.Errorf("%s: expected %d %s, got %d %+v", tt.name, tt.status, tt.report, status, report)
+		}
+		if len(report.Components) != 3 || report.Components[0].Name != "database" || report.Components[2].Error == "" {
+			t.Errorf("%s: unexpected components %+v", tt.name, report.Components)
+		}
+	}
+
+	c.Shutdown()
+	if status, report := readyz(t, c); status != http.StatusServiceUnavailable || report.Status != StatusShuttingDown {
+		t.Errorf("expected readiness to fail during shutdown, got %d %+v", status, report)
+	}
+
+	rr := httptest.NewRecorder()
+	c.LiveHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected liveness to pass during shutdown, got %d", rr.Code)
+	}
+}
+
+// Probes within CacheTTL reuse the result, concurrent probes share one check
+func TestReady_Cache(t *testing.T) {
+	c := newTestChecker(t)
+	clock := time.Now()
+	c.now = func() time.Time { return clock }
+	var checks atomic.Int32
+	release := make(chan struct{})
+	c.Register(Component{Name: "database", Check: func(ctx context.Context) error {
+		checks.Add(1)
+		<-release
+		return nil
+	}})
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if report := c.Ready(context.Background()); report.Status != StatusOK {
+				t.Errorf("unexpected report %+v", report)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	c.Ready(context.Background())
+	if n := checks.Load(); n != 1 {
+		t.Errorf("expected one check, got %d", n)
+	}
+
+	clock = clock.Add(time.Hour)
+	c.Ready(context.Background())
+	if n := checks.Load(); n != 2 {
+		t.Errorf("expected an expired result to be checked again, got %d checks", n)
+	}
+}
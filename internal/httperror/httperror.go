@@ -0,0 +1,68 @@
+package httperror
+
+import (
+	"encoding/json"
+	"net/http"
+	"telegram_server/internal/requestid"
+)
+
+// Httperror writes the JSON error envelope shared by all HTTP handlers:
+// {"error": {"code": ..., "message": ..., "fields": [...], "request_id": ...}}
+
+// Error codes
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeTooLarge             = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeValidation           = "validation_failed"
+	CodeConflict             = "conflict"
+	// CodeIdempotencyMismatch is an Idempotency-Key reused with a different request
+	CodeIdempotencyMismatch = "idempotency_key_reused"
+	CodeTooManyRequests     = "too_many_requests"
+	CodeInternal            = "internal_error"
+	CodeUnavailable         = "service_unavailable"
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorBody is the content of the error envelope
+type ErrorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// ErrorResponse is the error envelope, the names keep OpenAPI schemas readable
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// RequestID returns the id the server gave the request. Handlers called
+// without the server fall back to the header of the caller.
+func RequestID(r *http.Request) string {
+	if id, ok := requestid.FromContext(r.Context()); ok {
+		return id
+	}
+	return r.Header.Get(requestid.Header)
+}
+
+// Write sends the error envelope with the given status
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string, fields ...FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		Fields:    fields,
+		RequestID: RequestID(r),
+	}})
+}
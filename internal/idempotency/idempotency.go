@@ -0,0 +1,313 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Idempotency makes retried POST requests safe. A client sends an
+// Idempotency-Key header and the first response for that key is stored, a retry
+// with the same key and body gets the stored response back instead of running
+// the handler again. Reusing a key with a different body is rejected with 422
+// and a retry made while the first request is still running gets 409.
+//
+// Responses with a 5xx status are not stored, the key is released so the
+// request can be retried.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	ClaimIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, lockTimeout time.Duration) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (bool, error)
+	DeleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+type Idempotency interface {
+	// Wrap handles the Idempotency-Key header of requests to next
+	Wrap(next http.HandlerFunc) http.HandlerFunc
+	RunCleanup(ctx context.Context, interval time.Duration)
+}
+
+type Config struct {
+	Logger   Logger
+	Database Database
+	// TTL is how long a key and its response are kept
+	TTL time.Duration
+	// LockTimeout is how long a request may hold a key before a retry takes it over
+	LockTimeout time.Duration
+	// MaxResponseSize bounds stored responses, larger ones release the key
+	MaxResponseSize int
+	// Scope names who made a request so clients cannot replay each other's keys
+	Scope func(r *http.Request) string
+}
+
+type IdempotencyImpl struct {
+	logger          Logger
+	database        Database
+	ttl             time.Duration
+	lockTimeout     time.Duration
+	maxResponseSize int
+	scope           func(r *http.Request) string
+	now             func() time.Time
+}
+
+const (
+	// Header is the request header carrying the key
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses served from the store
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+func defaultConfig() Config {
+	return Config{
+		TTL:             24 * time.Hour,
+		LockTimeout:     time.Minute,
+		MaxResponseSize: 64 << 10,
+		Scope:           func(r *http.Request) string { return "" },
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	if cfg.TTL < 0 || cfg.LockTimeout < 0 || cfg.MaxResponseSize < 0 {
+		return fmt.Errorf("durations and sizes must not be negative")
+	}
+	return nil
+}
+
+func NewIdempotency(cfg Config) (Idempotency, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.TTL == 0 {
+		cfg.TTL = def.TTL
+	}
+	if cfg.LockTimeout == 0 {
+		cfg.LockTimeout = def.LockTimeout
+	}
+	if cfg.MaxResponseSize == 0 {
+		cfg.MaxResponseSize = def.MaxResponseSize
+	}
+	if cfg.Scope == nil {
+		cfg.Scope = def.Scope
+	}
+
+	return &IdempotencyImpl{
+		logger:          cfg.Logger,
+		database:        cfg.Database,
+		ttl:             cfg.TTL,
+		lockTimeout:     cfg.LockTimeout,
+		maxResponseSize: cfg.MaxResponseSize,
+		scope:           cfg.Scope,
+		now:             time.Now,
+	}, nil
+}
+
+// validKey accepts printable ASCII keys, which covers UUIDs and random tokens
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newClaimToken returns a random token telling the claims of a key apart
+func newClaimToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestHash identifies a request by method, path and body
+func requestHash(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+func (i *IdempotencyImpl) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validKey(key) {
+			httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest,
+				"Idempotency-Key must be 1 to "+strconv.Itoa(maxKeyLength)+" printable ASCII characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				httperror.Write(w, r, http.StatusRequestEntityTooLarge, httperror.CodeTooLarge, "request body too large")
+				return
+			}
+			httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "could not read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := models.IdempotencyRecord{
+			Scope:       i.scope(r),
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			ClaimToken:  newClaimToken(),
+			ExpiresAt:   i.now().Add(i.ttl),
+		}
+		existing, claimed, err := i.database.ClaimIdempotencyKey(r.Context(), record, i.lockTimeout)
+		if err != nil {
+			httperror.Write(w, r, http.StatusServiceUnavailable, httperror.CodeUnavailable, "could not check Idempotency-Key")
+			return
+		}
+		if !claimed {
+			i.replay(w, r, record, existing)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, limit: i.maxResponseSize}
+		completed := false
+		defer func() {
+			if !completed {
+				// The handler panicked, let the client retry
+				i.release(r.Context(), record)
+			}
+		}()
+		next(rec, r)
+		completed = true
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= 500 || rec.overflow {
+			i.release(r.Context(), record)
+			return
+		}
+		record.Status = rec.status
+		record.ContentType = rec.Header().Get("Content-Type")
+		record.Body = rec.body.Bytes()
+		// The request is done, its response is stored even if the client went away
+		stored, err := i.database.CompleteIdempotencyKey(context.WithoutCancel(r.Context()), record)
+		if err != nil {
+			i.logger.LogEvent("Error while storing response for Idempotency-Key " + strconv.Quote(key) + ": " + err.Error())
+		} else if !stored {
+			i.logger.LogEvent("Response for Idempotency-Key " + strconv.Quote(key) + " not stored, a retry took the key over")
+		}
+	}
+}
+
+// replay answers a request whose key was used before
+func (i *IdempotencyImpl) replay(w http.ResponseWriter, r *http.Request, record, existing models.IdempotencyRecord) {
+	if !bytes.Equal(existing.RequestHash, record.RequestHash) {
+		httperror.Write(w, r, http.StatusUnprocessableEntity, httperror.CodeIdempotencyMismatch,
+			"Idempotency-Key was already used with a different request")
+		return
+	}
+	if existing.Status == 0 {
+		w.Header().Set("Retry-After", "1")
+		httperror.Write(w, r, http.StatusConflict, httperror.CodeConflict,
+			"a request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(existing.Status)
+	w.Write(existing.Body)
+}
+
+func (i *IdempotencyImpl) release(ctx context.Context, record models.IdempotencyRecord) {
+	if err := i.database.DeleteIdempotencyKey(context.WithoutCancel(ctx), record); err != nil {
+		i.logger.LogEvent("Error while releasing Idempotency-Key " + strconv.Quote(record.Key) + ": " + err.Error())
+	}
+}
+
+// RunCleanup deletes expired keys every interval until ctx is done
+func (i *IdempotencyImpl) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := i.database.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				i.logger.LogEvent("Error while deleting expired idempotency keys: " + err.Error())
+				continue
+			}
+			if deleted > 0 {
+				i.logger.LogEvent("Deleted " + strconv.FormatInt(deleted, 10) + " expired idempotency keys")
+			}
+		}
+	}
+}
+
+// recorder passes the response through and keeps a copy of it up to limit bytes
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > rec.limit {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,227 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+// fakeDatabase keeps keys in memory with the claim rules of the real table
+type fakeDatabase struct {
+	mu      sync.Mutex
+	records map[string]models.IdempotencyRecord
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{records: make(map[string]models.IdempotencyRecord)}
+}
+
+func (f *fakeDatabase) ClaimIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, lockTimeout time.Duration) (models.IdempotencyRecord, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := record.Scope + "/" + record.Key
+	if existing, ok := f.records[id]; ok && existing.ExpiresAt.After(time.Now()) {
+		return existing, false, nil
+	}
+	f.records[id] = record
+	return record, true, nil
+}
+
+func (f *fakeDatabase) CompleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := record.Scope + "/" + record.Key
+	if existing, ok := f.records[id]; !ok || existing.ClaimToken != record.ClaimToken || existing.Status != 0 {
+		return false, nil
+	}
+	f.records[id] = record
+	return true, nil
+}
+
+func (f *fakeDatabase) DeleteIdempotencyKey(ctx context.Context, record models.IdempotencyRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := record.Scope + "/" + record.Key
+	if existing, ok := f.records[id]; ok && existing.ClaimToken == record.ClaimToken && existing.Status == 0 {
+		delete(f.records, id)
+	}
+	return nil
+}
+
+// takeOver replaces the claim of a key as a retry does after the lock timeout
+func (f *fakeDatabase) takeOver(id string) models.IdempotencyRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	record := f.records[id]
+	record.ClaimToken = "retry"
+	f.records[id] = record
+	return record
+}
+
+func (f *fakeDatabase) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func newTestIdempotency(t *testing.T, db *fakeDatabase) *IdempotencyImpl {
+	t.Helper()
+	i, err := NewIdempotency(Config{
+		Logger:   testLogger{},
+		Database: db,
+		Scope:    func(r *http.Request) string { return r.Header.Get("X-Client") },
+	})
+	if err != nil {
+		t.Fatalf("NewIdempotency: %v", err)
+	}
+	return i.(*IdempotencyImpl)
+}
+
+func post(h http.HandlerFunc, key, client, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/message", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	req.Header.Set("X-Client", client)
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	return rr
+}
+
+func TestWrap_Replay(t *testing.T) {
+	i := newTestIdempotency(t, newFakeDatabase())
+	calls := 0
+	h := i.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":` + strconv.Itoa(calls) + `}`))
+	})
+
+	first := post(h, "key-1", "a", `{"text":"hi"}`)
+	second := post(h, "key-1", "a", `{"text":"hi"}`)
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("expected replay of %d %q, got %d %q", first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+	if second.Header().Get(ReplayedHeader) != "true" || second.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected replay headers %v", second.Header())
+	}
+
+	// Keys belong to the client that sent them
+	post(h, "key-1", "b", `{"text":"hi"}`)
+	// Requests without a key are not deduplicated
+	post(h, "", "a", `{"text":"hi"}`)
+	post(h, "", "a", `{"text":"hi"}`)
+	if calls != 4 {
+		t.Errorf("expected 4 handler runs, got %d", calls)
+	}
+}
+
+func TestWrap_Rejected(t *testing.T) {
+	i := newTestIdempotency(t, newFakeDatabase())
+	h := i.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	post(h, "key-1", "a", `{"text":"hi"}`)
+	rr := post(h, "key-1", "a", `{"text":"bye"}`)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), httperror.CodeIdempotencyMismatch) {
+		t.Errorf("expected 422 for a different body, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = post(h, "key with spaces", "a", `{}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid key, got %d", rr.Code)
+	}
+	rr = post(h, strings.Repeat("k", maxKeyLength+1), "a", `{}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a long key, got %d", rr.Code)
+	}
+}
+
+func TestWrap_Concurrent(t *testing.T) {
+	i := newTestIdempotency(t, newFakeDatabase())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := i.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("saved"))
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(h, "key-1", "a", `{}`) }()
+	<-started
+
+	rr := post(h, "key-1", "a", `{}`)
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 409 with Retry-After while the first request runs, got %d %v", rr.Code, rr.Header())
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusOK {
+		t.Fatalf("expected the first request to succeed, got %d", first.Code)
+	}
+	rr = post(h, "key-1", "a", `{}`)
+	if rr.Code != http.StatusOK || rr.Body.String() != "saved" {
+		t.Errorf("expected replay after the first request, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWrap_ServerErrorReleasesKey(t *testing.T) {
+	db := newFakeDatabase()
+	i := newTestIdempotency(t, db)
+	fail := true
+	h := i.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not save message")
+			return
+		}
+		w.Write([]byte("saved"))
+	})
+
+	if rr := post(h, "key-1", "a", `{}`); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	if len(db.records) != 0 {
+		t.Fatalf("expected the key to be released, got %v", db.records)
+	}
+
+	fail = false
+	if rr := post(h, "key-1", "a", `{}`); rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), []byte("saved")) {
+		t.Errorf("expected the retry to run the handler, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+// A request whose key was taken over by a retry leaves the key to the retry
+func TestWrap_TakenOver(t *testing.T) {
+	db := newFakeDatabase()
+	i := newTestIdempotency(t, db)
+	var retry models.IdempotencyRecord
+	status := http.StatusInternalServerError
+	h := i.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		retry = db.takeOver("a/key-1")
+		w.WriteHeader(status)
+	})
+
+	for _, status = range []int{http.StatusInternalServerError, http.StatusCreated} {
+		post(h, "key-1", "a", `{}`)
+		if got, ok := db.records["a/key-1"]; !ok || got.ClaimToken != "retry" || got.Status != 0 {
+			t.Errorf("%d: expected the key of the retry to be kept, got %+v", status, got)
+		}
+		db.DeleteIdempotencyKey(context.Background(), retry)
+	}
+}
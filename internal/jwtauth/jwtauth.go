@@ -0,0 +1,351 @@
+package jwtauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Jwtauth validates JWT bearer tokens issued by the platform identity provider
+// for service-to-service calls. Tokens must be signed with RS256 or ES256 by a
+// key of the configured JWKS.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Authenticator interface {
+	Validate(ctx context.Context, token string) (Claims, error)
+	Middleware(next http.Handler) http.Handler
+	RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc
+}
+
+type Config struct {
+	// JWKSURL or JWKSFile is the source of the signing keys
+	JWKSURL  string
+	JWKSFile string
+	Issuer   string
+	Audience string
+	// ClockSkew is tolerated when checking exp, nbf and iat
+	ClockSkew time.Duration
+	// CacheTTL is how long keys are used before they are loaded again
+	CacheTTL time.Duration
+	// MinRefreshInterval limits reloads caused by unknown key ids
+	MinRefreshInterval time.Duration
+	HTTPClient         *http.Client
+	Logger             Logger
+}
+
+type AuthenticatorImpl struct {
+	keys      *keySet
+	issuer    string
+	audience  string
+	clockSkew time.Duration
+	logger    Logger
+	now       func() time.Time
+}
+
+// Claims are the validated claims of a token, Raw holds all of them
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Scopes    []string
+	Raw       map[string]any
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpired      = errors.New("token is expired")
+)
+
+func defaultConfig() Config {
+	return Config{
+		ClockSkew:          time.Minute,
+		CacheTTL:           time.Hour,
+		MinRefreshInterval: time.Minute,
+		HTTPClient:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if (cfg.JWKSURL == "") == (cfg.JWKSFile == "") {
+		return fmt.Errorf("exactly one of JWKS URL and JWKS file is required")
+	}
+	if cfg.Issuer == "" {
+		return fmt.Errorf("issuer is required")
+	}
+	if cfg.Audience == "" {
+		return fmt.Errorf("audience is required")
+	}
+	return nil
+}
+
+func NewAuthenticator(cfg Config) (Authenticator, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.ClockSkew == 0 {
+		cfg.ClockSkew = def.ClockSkew
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = def.CacheTTL
+	}
+	if cfg.MinRefreshInterval == 0 {
+		cfg.MinRefreshInterval = def.MinRefreshInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = def.HTTPClient
+	}
+
+	a := &AuthenticatorImpl{
+		issuer:    cfg.Issuer,
+		audience:  cfg.Audience,
+		clockSkew: cfg.ClockSkew,
+		logger:    cfg.Logger,
+		now:       time.Now,
+	}
+	a.keys = &keySet{
+		url:        cfg.JWKSURL,
+		file:       cfg.JWKSFile,
+		client:     cfg.HTTPClient,
+		cacheTTL:   cfg.CacheTTL,
+		minRefresh: cfg.MinRefreshInterval,
+		now:        func() time.Time { return a.now() },
+	}
+	return a, nil
+}
+
+// Validate checks signature, issuer, audience and lifetime of a compact JWT
+func (a *AuthenticatorImpl) Validate(ctx context.Context, token string) (Claims, error) {
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return claims, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := a.keys.key(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	if err := verify(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeSegment(parts[1], &claims.Raw); err != nil {
+		return claims, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := a.checkClaims(&claims); err != nil {
+		return claims, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// verify checks the signature with the algorithm named in the header, the key
+// type must match it so an RSA key is never used to check an ES256 token.
+func verify(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key does not match alg %s", ErrInvalidToken, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("%w: key does not match alg %s", ErrInvalidToken, alg)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	}
+	return nil
+}
+
+func (a *AuthenticatorImpl) checkClaims(claims *Claims) error {
+	raw := claims.Raw
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+
+	switch aud := raw["aud"].(type) {
+	case string:
+		claims.Audience = []string{aud}
+	case []any:
+		for _, v := range aud {
+			if s, ok := v.(string); ok {
+				claims.Audience = append(claims.Audience, s)
+			}
+		}
+	}
+
+	// scope is space separated (RFC 8693), some issuers use an scp array
+	if scope, ok := raw["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	} else if scp, ok := raw["scp"].([]any); ok {
+		for _, v := range scp {
+			if s, ok := v.(string); ok {
+				claims.Scopes = append(claims.Scopes, s)
+			}
+		}
+	}
+
+	var ok bool
+	if claims.ExpiresAt, ok = numericDate(raw["exp"]); !ok {
+		return fmt.Errorf("%w: exp is required", ErrInvalidToken)
+	}
+	claims.NotBefore, _ = numericDate(raw["nbf"])
+	claims.IssuedAt, _ = numericDate(raw["iat"])
+
+	now := a.now()
+	if now.After(claims.ExpiresAt.Add(a.clockSkew)) {
+		return ErrExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(a.clockSkew).Before(claims.NotBefore) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(a.clockSkew).Before(claims.IssuedAt) {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	if claims.Issuer != a.issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, a.audience) {
+		return fmt.Errorf("%w: audience does not include %q", ErrInvalidToken, a.audience)
+	}
+	return nil
+}
+
+func numericDate(v any) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// HasScope tells whether claims grant scope, admin grants every scope
+func HasScope(claims Claims, scope string) bool {
+	return slices.Contains(claims.Scopes, scope) || slices.Contains(claims.Scopes, models.ScopeAdmin)
+}
+
+type contextKey struct{}
+
+// FromContext returns the claims put into the context by Middleware
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+// bearerToken returns a bearer token that looks like a JWT, API keys sent as
+// bearer tokens have no dots and are left to the apikey middleware.
+func bearerToken(r *http.Request) string {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || strings.Count(token, ".") != 2 {
+		return ""
+	}
+	return token
+}
+
+// Middleware validates JWT bearer tokens and puts their claims into the context.
+// Requests without one pass through, RequireScope rejects them.
+func (a *AuthenticatorImpl) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := a.Validate(r.Context(), token)
+		if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrExpired) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			a.logger.LogEvent("Error while validating bearer token: " + err.Error())
+			httperror.Write(w, r, http.StatusServiceUnavailable, httperror.CodeUnavailable, "could not verify bearer token")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, claims)))
+	})
+}
+
+// RequireScope lets through requests with a valid token granting scope
+func (a *AuthenticatorImpl) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := FromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "bearer token required")
+			return
+		}
+		if !HasScope(claims, scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			httperror.Write(w, r, http.StatusForbidden, httperror.CodeForbidden, "token lacks scope "+scope)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,289 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+const (
+	testIssuer   = "https://id.example.com"
+	testAudience = "telegram-server"
+)
+
+// testKey is a locally generated signing key published in a test JWKS
+type testKey struct {
+	kid string
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return testKey{kid: kid, rsa: key}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating EC key: %v", err)
+	}
+	return testKey{kid: kid, ec: key}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (k testKey) jwk() map[string]string {
+	if k.rsa != nil {
+		return map[string]string{
+			"kid": k.kid, "kty": "RSA", "alg": "RS256", "use": "sig",
+			"n": b64(k.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		}
+	}
+	return map[string]string{
+		"kid": k.kid, "kty": "EC", "alg": "ES256", "use": "sig", "crv": "P-256",
+		"x": b64(k.ec.X.FillBytes(make([]byte, 32))),
+		"y": b64(k.ec.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func jwks(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sign creates a compact JWT with the given claims
+func (k testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	alg := "RS256"
+	if k.ec != nil {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": k.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	if k.rsa != nil {
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(signature)
+}
+
+func validClaims(now time.Time) map[string]any {
+	return map[string]any{
+		"iss":   testIssuer,
+		"aud":   []string{testAudience, "other"},
+		"sub":   "billing-service",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"scope": "messages:read messages:write",
+	}
+}
+
+func newFileAuthenticator(t *testing.T, keys ...testKey) *AuthenticatorImpl {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwks(t, keys...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(Config{JWKSFile: file, Issuer: testIssuer, Audience: testAudience, Logger: testLogger{}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return a.(*AuthenticatorImpl)
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	ecKey := newECKey(t, "ec-1")
+	other := newRSAKey(t, "rsa-1")
+	a := newFileAuthenticator(t, rsaKey, ecKey)
+	now := time.Now()
+
+	with := func(change func(map[string]any)) map[string]any {
+		claims := validClaims(now)
+		change(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256", rsaKey.sign(t, validClaims(now)), nil},
+		{"ES256", ecKey.sign(t, validClaims(now)), nil},
+		{"expired within skew", rsaKey.sign(t, with(func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() })), nil},
+		{"expired", rsaKey.sign(t, with(func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), ErrExpired},
+		{"not yet valid", rsaKey.sign(t, with(func(c map[string]any) { c["nbf"] = now.Add(5 * time.Minute).Unix() })), ErrInvalidToken},
+		{"no exp", rsaKey.sign(t, with(func(c map[string]any) { delete(c, "exp") })), ErrInvalidToken},
+		{"wrong issuer", rsaKey.sign(t, with(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), ErrInvalidToken},
+		{"wrong audience", rsaKey.sign(t, with(func(c map[string]any) { c["aud"] = "someone-else" })), ErrInvalidToken},
+		{"unknown signer", other.sign(t, validClaims(now)), ErrInvalidToken},
+		{"unknown kid", newECKey(t, "ec-2").sign(t, validClaims(now)), ErrInvalidToken},
+		{"malformed", "a.b", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.Validate(context.Background(), tt.token)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims.Subject != "billing-service" || !HasScope(claims, "messages:write") {
+					t.Errorf("unexpected claims %+v", claims)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestValidate_RejectsAlgNone(t *testing.T) {
+	a := newFileAuthenticator(t, newRSAKey(t, "rsa-1"))
+	header := b64([]byte(`{"alg":"none","kid":"rsa-1"}`))
+	payload, _ := json.Marshal(validClaims(time.Now()))
+	if _, err := a.Validate(context.Background(), header+"."+b64(payload)+"."); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newRSAKey(t, "old")
+	newKey := newECKey(t, "new")
+
+	var published atomic.Value
+	published.Store(jwks(t, oldKey))
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(published.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	auth, err := NewAuthenticator(Config{JWKSURL: srv.URL, Issuer: testIssuer, Audience: testAudience, Logger: testLogger{}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	a := auth.(*AuthenticatorImpl)
+	start := time.Now()
+	a.now = func() time.Time { return start }
+	ctx := context.Background()
+
+	if _, err := a.Validate(ctx, oldKey.sign(t, validClaims(start))); err != nil {
+		t.Fatalf("old key: %v", err)
+	}
+	a.Validate(ctx, oldKey.sign(t, validClaims(start)))
+	if fetches.Load() != 1 {
+		t.Fatalf("expected keys to be cached, got %d fetches", fetches.Load())
+	}
+
+	// The issuer rotates, the new kid triggers a reload
+	published.Store(jwks(t, newKey))
+	if _, err := a.Validate(ctx, newKey.sign(t, validClaims(start))); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+
+	// Unknown kids do not reload again within the refresh interval
+	unknown := newECKey(t, "unknown")
+	if _, err := a.Validate(ctx, unknown.sign(t, validClaims(start))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetches.Load())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key := newECKey(t, "ec-1")
+	a := newFileAuthenticator(t, key)
+	now := time.Now()
+
+	readOnly := validClaims(now)
+	readOnly["scope"] = "messages:read"
+
+	var subject string
+	handler := a.Middleware(a.RequireScope("messages:write", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := FromContext(r.Context())
+		subject = claims.Subject
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer a.b.c", http.StatusUnauthorized},
+		{"missing scope", "Bearer " + key.sign(t, readOnly), http.StatusForbidden},
+		{"valid", "Bearer " + key.sign(t, validClaims(now)), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/message", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+	if subject != "billing-service" {
+		t.Errorf("expected claims in context, got subject %q", subject)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 type FileSystem interface {
@@ -18,9 +19,20 @@ type FileSystem interface {
 type Logger interface {
 	Start(ctx context.Context) error
 	LogEvent(logString string)
+	Stats() Stats
 	Close()
 }
 
+// Stats describes the log queue, Dropped counts the logs lost because the
+// logger was not started or the queue was full. Workers is the number of
+// workers writing the queue to the file, logs pile up when it is 0.
+type Stats struct {
+	Queued   int
+	Capacity int
+	Dropped  uint64
+	Workers  int
+}
+
 type LoggerImpl struct {
 	logger      *log.Logger
 	logFileName string
@@ -30,6 +42,8 @@ type LoggerImpl struct {
 	wg          sync.WaitGroup
 	logFile     *os.File
 	mu          sync.Mutex
+	dropped     atomic.Uint64
+	workers     atomic.Int32
 }
 
 type Config struct {
@@ -59,6 +73,7 @@ func (l *LoggerImpl) LogEvent(logString string) {
 	l.mu.Unlock()
 
 	if !running || logChan == nil {
+		l.dropped.Add(1)
 		fmt.Println("WARNING: logger not started, dropping log!")
 		return
 	}
@@ -68,10 +83,25 @@ func (l *LoggerImpl) LogEvent(logString string) {
 		// Log message successfully sent to log channel
 		// Do nothing
 	default:
+		l.dropped.Add(1)
 		fmt.Println("WARNING: log channel is full, dropping log!")
 	}
 }
 
+// Stats reports the log queue
+func (l *LoggerImpl) Stats() Stats {
+	l.mu.Lock()
+	logChan := l.logChan
+	l.mu.Unlock()
+
+	return Stats{
+		Queued:   len(logChan),
+		Capacity: cap(logChan),
+		Dropped:  l.dropped.Load(),
+		Workers:  int(l.workers.Load()),
+	}
+}
+
 func createLogsDirectory() (dir string, err error) {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -122,6 +152,7 @@ func (l *LoggerImpl) Start(ctx context.Context) error {
 	numWorkers := runtime.NumCPU()
 	for i := 1; i <= numWorkers; i++ {
 		l.wg.Add(1)
+		l.workers.Add(1)
 		go l.logWorker(ctx)
 	}
 	l.running = true
@@ -130,6 +161,7 @@ func (l *LoggerImpl) Start(ctx context.Context) error {
 
 func (l *LoggerImpl) logWorker(ctx context.Context) {
 	defer l.wg.Done()
+	defer l.workers.Add(-1)
 
 	for {
 		select {
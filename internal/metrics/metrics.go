@@ -0,0 +1,318 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics keeps counters, gauges and histograms of the server and serves them
+// in the Prometheus text exposition format (version 0.0.4). Metrics are
+// registered once at start up, label values are given when they are updated.
+
+// ContentType is the content type of the exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type family interface {
+	name() string
+	write(b *bytes.Buffer)
+}
+
+// Registry holds the metrics served by Handler
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name() == f.name() {
+			panic("metrics: " + f.name() + " is already registered")
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Histogram registers a histogram with the given upper bounds, in increasing order
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: slices.Clone(buckets)}
+	r.register(h)
+	return h
+}
+
+// GaugeFunc registers a gauge read from fn on every scrape
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcFamily{metricName: name, help: help, kind: "gauge", fn: fn})
+}
+
+// CounterFunc registers a counter read from fn on every scrape, fn must never
+// return less than it did before
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(&funcFamily{metricName: name, help: help, kind: "counter", fn: fn})
+}
+
+// WriteTo writes every metric in the exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	var b bytes.Buffer
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+// GET Handler /metrics (Prometheus scrape)
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// vec is the part shared by metrics with labels
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{metricName: name, help: help, kind: kind, labels: slices.Clone(labels)}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// key identifies the series of the label values, it panics when their number
+// does not match the label names as that is a bug of the caller
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.metricName, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (v *vec) writeHeader(b *bytes.Buffer) {
+	b.WriteString("# HELP " + v.metricName + " " + escapeHelp(v.help) + "\n")
+	b.WriteString("# TYPE " + v.metricName + " " + v.kind + "\n")
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct {
+	vec
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// Inc adds one to the series of the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the series of the label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.metricName + " cannot decrease")
+	}
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.series == nil {
+		c.series = map[string]*counterSeries{}
+	}
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: slices.Clone(values)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Value returns the value of the series of the label values
+func (c *CounterVec) Value(values ...string) float64 {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(b *bytes.Buffer) {
+	c.writeHeader(b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(c.series)) {
+		s := c.series[key]
+		b.WriteString(c.metricName + labelString(c.labels, s.values, "", "") + " " + formatFloat(s.value) + "\n")
+	}
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	// counts has the observations of each bucket, not cumulative
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records v in the series of the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.series == nil {
+		h.series = map[string]*histogramSeries{}
+	}
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations in the series of the label values
+func (h *HistogramVec) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(b *bytes.Buffer) {
+	h.writeHeader(b)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			b.WriteString(h.metricName + "_bucket" + labelString(h.labels, s.values, "le", formatFloat(upper)) +
+				" " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		b.WriteString(h.metricName + "_bucket" + labelString(h.labels, s.values, "le", "+Inf") +
+			" " + strconv.FormatUint(s.count, 10) + "\n")
+		b.WriteString(h.metricName + "_sum" + labelString(h.labels, s.values, "", "") + " " + formatFloat(s.sum) + "\n")
+		b.WriteString(h.metricName + "_count" + labelString(h.labels, s.values, "", "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+// funcFamily is a metric without labels read when it is scraped
+type funcFamily struct {
+	metricName string
+	help       string
+	kind       string
+	fn         func() float64
+}
+
+func (f *funcFamily) name() string {
+	return f.metricName
+}
+
+func (f *funcFamily) write(b *bytes.Buffer) {
+	b.WriteString("# HELP " + f.metricName + " " + escapeHelp(f.help) + "\n")
+	b.WriteString("# TYPE " + f.metricName + " " + f.kind + "\n")
+	b.WriteString(f.metricName + " " + formatFloat(f.fn()) + "\n")
+}
+
+// labelString formats the labels of a sample, extra is appended when it is set
+// (the le label of histogram buckets)
+func labelString(names, values []string, extra, extraValue string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra + `="` + escapeLabel(extraValue) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
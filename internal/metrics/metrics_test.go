@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("http_requests_total", "HTTP requests.", "route", "code")
+	latency := r.Histogram("http_request_duration_seconds", "HTTP request latency.", []float64{0.1, 1}, "route")
+	r.GaugeFunc("queue_depth", "Queued items.", func() float64 { return 3 })
+
+	requests.Inc("GET /messages", "200")
+	requests.Add(2, "GET /messages", "200")
+	requests.Inc(`say "hi"`+"\n", "500")
+	latency.Observe(0.1, "GET /messages")
+	latency.Observe(0.5, "GET /messages")
+	latency.Observe(3, "GET /messages")
+
+	rr := httptest.NewRecorder()
+	r.Handler(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	want := `# HELP http_requests_total HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{route="GET /messages",code="200"} 3
+http_requests_total{route="say \"hi\"\n",code="500"} 1
+# HELP http_request_duration_seconds HTTP request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="GET /messages",le="0.1"} 1
+http_request_duration_seconds_bucket{route="GET /messages",le="1"} 2
+http_request_duration_seconds_bucket{route="GET /messages",le="+Inf"} 3
+http_request_duration_seconds_sum{route="GET /messages"} 3.6
+http_request_duration_seconds_count{route="GET /messages"} 3
+# HELP queue_depth Queued items.
+# TYPE queue_depth gauge
+queue_depth 3
+`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("events_total", "Events.", "type")
+
+	for name, f := range map[string]func(){
+		"duplicate name":     func() { r.GaugeFunc("events_total", "Again.", func() float64 { return 0 }) },
+		"wrong label count":  func() { c.Inc("a", "b") },
+		"negative increment": func() { c.Add(-1, "a") },
+		"unsorted buckets":   func() { r.Histogram("latency_seconds", "Latency.", []float64{1, 0.5}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	if strings.Contains(b.String(), "events_total{") {
+		t.Errorf("rejected updates must not create series:\n%s", b.String())
+	}
+}
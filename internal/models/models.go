@@ -1,7 +1,327 @@
 package models
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Message directions
+const (
+	DirectionIncoming = "in"
+	DirectionOutgoing = "out"
+)
+
+// Message delivery statuses
+const (
+	StatusReceived = "received"
+	StatusPending  = "pending"
+	StatusSent     = "sent"
+	StatusFailed   = "failed"
+)
+
 type Message struct {
-	ID       int64
+	ID        int64     `json:"id"`
+	UserName  string    `json:"username"`
+	Text      string    `json:"text"`
+	ChatID    int64     `json:"chat_id,omitempty"`
+	MessageID int       `json:"message_id,omitempty"`
+	ReplyTo   *int      `json:"reply_to,omitempty"`
+	Direction string    `json:"direction,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MessageCursor points at the last message of a page, the next page starts after it
+type MessageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int64     `json:"id"`
+}
+
+// Encode returns the cursor as an opaque string for clients
+func (c MessageCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeMessageCursor parses a cursor returned by MessageCursor.Encode
+func DecodeMessageCursor(s string) (MessageCursor, error) {
+	var c MessageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// MessageFilter selects a page of messages, zero fields do not filter
+type MessageFilter struct {
+	UserName  string
+	ChatID    *int64
+	From      time.Time
+	To        time.Time
+	Text      string
+	Ascending bool
+	Limit     int
+	After     *MessageCursor
+}
+
+// ExportFilter selects the messages of an export, zero fields do not filter.
+// Exports are ordered by id and start after AfterID to resume an interrupted one.
+type ExportFilter struct {
 	UserName string
-	Text     string
+	ChatID   *int64
+	From     time.Time
+	To       time.Time
+	AfterID  int64
+}
+
+// MessageStreamFilter selects the messages pushed to a stream subscriber, zero fields do not filter
+type MessageStreamFilter struct {
+	ChatID   *int64
+	UserName string
+}
+
+// Matches tells whether msg passes the filter
+func (f MessageStreamFilter) Matches(msg Message) bool {
+	if f.ChatID != nil && *f.ChatID != msg.ChatID {
+		return false
+	}
+	return f.UserName == "" || f.UserName == msg.UserName
+}
+
+// MessagePage is one page of messages, Next is nil on the last page
+type MessagePage struct {
+	Messages []Message
+	Next     *MessageCursor
+}
+
+// BotMessage is a message sent by the bot that can still be edited or deleted
+type BotMessage struct {
+	ID        int64
+	ChatID    int64
+	MessageID int
+	Text      string
+	ExpiresAt *time.Time
+	CreatedAt time.Time
+}
+
+// Chat modes
+const (
+	ModeBot   = "bot"
+	ModeHuman = "human"
+)
+
+// ChatMode tells who is answering a chat: the bot or a human operator
+type ChatMode struct {
+	ChatID         int64     `json:"chat_id"`
+	Mode           string    `json:"mode"`
+	Operator       string    `json:"operator,omitempty"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Poll types
+const (
+	PollRegular = "regular"
+	PollQuiz    = "quiz"
+)
+
+// Poll is a Telegram poll or quiz sent by the bot
+type Poll struct {
+	ID              string    `json:"id"`
+	ChatID          int64     `json:"chat_id"`
+	MessageID       int       `json:"message_id"`
+	Question        string    `json:"question"`
+	Options         []string  `json:"options"`
+	Type            string    `json:"type"`
+	CorrectOptionID *int      `json:"correct_option_id,omitempty"`
+	IsClosed        bool      `json:"is_closed"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// PollVote is the current answer of one user in a poll
+type PollVote struct {
+	PollID    string    `json:"poll_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"username"`
+	OptionIDs []int     `json:"option_ids"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PollOptionResult is the number of votes for one poll option
+type PollOptionResult struct {
+	ID      int    `json:"id"`
+	Text    string `json:"text"`
+	Votes   int    `json:"votes"`
+	Correct bool   `json:"correct,omitempty"`
+}
+
+// PollResults aggregates the votes of a poll
+type PollResults struct {
+	Poll        Poll               `json:"poll"`
+	TotalVoters int                `json:"total_voters"`
+	Options     []PollOptionResult `json:"options"`
+}
+
+// Dashboard roles, admin can do everything a viewer can
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
+// Session is a logged in dashboard user
+type Session struct {
+	ID         string    `json:"-"`
+	UserID     int64     `json:"user_id"`
+	UserName   string    `json:"username"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// API key scopes, admin grants every scope
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeAdmin         = "admin"
+)
+
+// APIKey authenticates HTTP clients, only the SHA-256 hash of the key is stored
+type APIKey struct {
+	ID     int64    `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Hash   []byte   `json:"-"`
+	Scopes []string `json:"scopes"`
+	// RateLimit is the number of requests allowed per minute, 0 means no limit
+	RateLimit  int        `json:"rate_limit"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// AuditEntry records an action taken by staff or a service, Actor names who
+// took it and Details holds what was done
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// IdempotencyRecord is the stored outcome of a request made with an Idempotency-Key.
+// Status is 0 while the first request is still being handled.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	RequestHash []byte
+	// ClaimToken identifies the request holding the key, a request whose key
+	// was taken over by a retry must leave it alone
+	ClaimToken  string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Webhook event types
+const (
+	EventMessageCreated = "message.created"
+)
+
+// Webhook delivery statuses, dead deliveries ran out of attempts and wait for a replay
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription is a URL notified of the listed events, deliveries are
+// signed with Secret
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent to one subscription, URL and Secret are
+// those of the subscription
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	URL            string          `json:"url"`
+	Secret         string          `json:"-"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// PoolStats describes the database connection pool. The counts and
+// AcquireDuration add up since the pool was created.
+type PoolStats struct {
+	AcquiredConns int32
+	IdleConns     int32
+	TotalConns    int32
+	MaxConns      int32
+	AcquireCount  int64
+	// AcquireDuration is the time spent getting connections from the pool
+	AcquireDuration time.Duration
+	// EmptyAcquireCount counts acquires that waited for a connection
+	EmptyAcquireCount    int64
+	CanceledAcquireCount int64
+}
+
+// Crash is a panic recovered while serving a request, Stack is the stack of
+// the panicking goroutine
+type Crash struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route,omitempty"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+}
+
+// RateLimit is the state of a token bucket after a request took, or failed to
+// take, one of its tokens. The bucket holds up to Limit tokens and refills
+// completely in Window.
+type RateLimit struct {
+	Allowed   bool
+	Limit     int
+	Window    time.Duration
+	Remaining int
+	// RetryAfter is the wait for the next token of a refused request
+	RetryAfter time.Duration
+	// Reset is the wait until the bucket is full again
+	Reset time.Duration
+}
+
+// NewRateLimit describes a bucket left with tokens
+func NewRateLimit(allowed bool, tokens float64, limit int, window time.Duration) RateLimit {
+	perToken := float64(window) / float64(limit)
+	rl := RateLimit{
+		Allowed:   allowed,
+		Limit:     limit,
+		Window:    window,
+		Remaining: int(tokens),
+		Reset:     time.Duration((float64(limit) - tokens) * perToken),
+	}
+	if !allowed {
+		rl.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	return rl
 }
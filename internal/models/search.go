@@ -0,0 +1,157 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchTerm is a word, "a phrase" or a prefix* of a search query, Negate
+// excludes messages containing it
+type SearchTerm struct {
+	Text   string
+	Phrase bool
+	Prefix bool
+	Negate bool
+}
+
+// SearchFilter selects the messages of a full-text search. Groups are joined
+// by OR, the terms of a group by AND.
+type SearchFilter struct {
+	Groups   [][]SearchTerm
+	UserName string
+	ChatID   *int64
+	From     time.Time
+	To       time.Time
+	Limit    int
+	After    *SearchCursor
+}
+
+// SearchCursor points at the last hit of a page, the next page starts after it
+type SearchCursor struct {
+	Rank float32 `json:"r"`
+	ID   int64   `json:"id"`
+}
+
+// Encode returns the cursor as an opaque string for clients
+func (c SearchCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeSearchCursor parses a cursor returned by SearchCursor.Encode
+func DecodeSearchCursor(s string) (SearchCursor, error) {
+	var c SearchCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// SearchHit is a message found by a search with its rank and a snippet of its
+// text, matches in the snippet are HTML escaped text wrapped in <mark> tags
+type SearchHit struct {
+	Message
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchPage is a page of search results, Next is nil on the last page
+type SearchPage struct {
+	Hits []SearchHit
+	Next *SearchCursor
+}
+
+// MaxSearchTerms bounds the terms of a search query
+const MaxSearchTerms = 32
+
+// ParseSearchQuery reads the search syntax:
+//
+//	word        messages containing the word
+//	"a phrase"  the words next to each other
+//	pre*        words starting with pre
+//	-word       messages without the word, also -"a phrase" and -pre*
+//	a OR b      either side
+//
+// Terms not separated by OR must all match.
+func ParseSearchQuery(q string) ([][]SearchTerm, error) {
+	var groups [][]SearchTerm
+	var group []SearchTerm
+	terms := 0
+	rest := q
+
+	endGroup := func() error {
+		if len(group) == 0 {
+			return errors.New("OR needs a term on both sides")
+		}
+		positive := false
+		for _, t := range group {
+			positive = positive || !t.Negate
+		}
+		if !positive {
+			return errors.New("every part of the query needs a term that is not excluded")
+		}
+		groups = append(groups, group)
+		group = nil
+		return nil
+	}
+
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+
+		var term SearchTerm
+		if rest[0] == '-' {
+			term.Negate = true
+			rest = rest[1:]
+		}
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated phrase")
+			}
+			term.Text, term.Phrase = strings.TrimSpace(rest[1:end+1]), true
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+			if end < 0 {
+				end = len(rest)
+			}
+			term.Text = rest[:end]
+			rest = rest[end:]
+
+			if term.Text == "OR" && !term.Negate {
+				if err := endGroup(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if trimmed := strings.TrimRight(term.Text, "*"); trimmed != term.Text {
+				term.Text, term.Prefix = trimmed, true
+			}
+		}
+		if term.Text == "" {
+			continue
+		}
+
+		if terms++; terms > MaxSearchTerms {
+			return nil, errors.New("too many terms")
+		}
+		group = append(group, term)
+	}
+
+	if len(group) == 0 && len(groups) == 0 {
+		return nil, errors.New("no search terms")
+	}
+	if err := endGroup(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,311 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI builds an OpenAPI 3 document from a table of routes and serves it
+
+// Route documents a pattern registered with HttpServer.SetHandler
+type Route struct {
+	// Pattern is the http.ServeMux pattern, e.g. "GET /messages/{id}"
+	Pattern     string
+	Summary     string
+	Description string
+	Tags        []string
+	// Security names security schemes from Config.SecuritySchemes, any of them is enough
+	Security []string
+	// Params describes query parameters and path parameters that are not strings,
+	// remaining path parameters are documented as strings.
+	Params []Param
+	// Request is a value of the request body type or a *Schema, nil for no body
+	Request     any
+	RequestType string
+	Responses   []Response
+}
+
+// Param is a query or path parameter
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Schema      *Schema
+}
+
+// Response is a documented response, Body is a value of the body type or a *Schema
+type Response struct {
+	Status      int
+	Description string
+	Body        any
+	ContentType string
+}
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower case HTTP methods to operations
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                    `json:"operationId"`
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	Parameters  []Parameter               `json:"parameters,omitempty"`
+	RequestBody *RequestBody              `json:"requestBody,omitempty"`
+	Responses   map[string]ResponseObject `json:"responses"`
+	Security    []map[string][]string     `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type ResponseObject struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+type Config struct {
+	Title           string
+	Version         string
+	Description     string
+	Routes          []Route
+	SecuritySchemes map[string]SecurityScheme
+	// ErrorBody is the body of responses with status >= 400 that declare no Body
+	ErrorBody any
+}
+
+type Spec interface {
+	Document() *Document
+	Handler(w http.ResponseWriter, r *http.Request)
+}
+
+type SpecImpl struct {
+	document *Document
+	encoded  []byte
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	if len(cfg.Routes) == 0 {
+		return fmt.Errorf("routes are required")
+	}
+	return nil
+}
+
+func NewSpec(cfg Config) (Spec, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.Version == "" {
+		cfg.Version = "1.0.0"
+	}
+
+	document, err := build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding document: %w", err)
+	}
+
+	return &SpecImpl{document: document, encoded: encoded}, nil
+}
+
+func build(cfg Config) (*Document, error) {
+	registry := newSchemaRegistry()
+	document := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: cfg.Title, Version: cfg.Version, Description: cfg.Description},
+		Paths:   make(map[string]PathItem),
+	}
+
+	for _, route := range cfg.Routes {
+		method, path, err := ParsePattern(route.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		item, ok := document.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			document.Paths[path] = item
+		}
+		key := strings.ToLower(method)
+		if _, dup := item[key]; dup {
+			return nil, fmt.Errorf("route %q is documented twice", route.Pattern)
+		}
+		for _, name := range route.Security {
+			if _, ok := cfg.SecuritySchemes[name]; !ok {
+				return nil, fmt.Errorf("route %q uses unknown security scheme %q", route.Pattern, name)
+			}
+		}
+
+		item[key] = newOperation(registry, route, method, path, cfg.ErrorBody)
+	}
+
+	document.Components = Components{
+		Schemas:         registry.schemas,
+		SecuritySchemes: cfg.SecuritySchemes,
+	}
+	return document, nil
+}
+
+func newOperation(registry *schemaRegistry, route Route, method, path string, errorBody any) *Operation {
+	op := &Operation{
+		OperationID: operationID(method, path),
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Responses:   make(map[string]ResponseObject),
+	}
+
+	documented := make(map[string]bool)
+	for _, p := range route.Params {
+		schema := p.Schema
+		if schema == nil {
+			schema = &Schema{Type: "string"}
+		}
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == "path",
+			Schema:      schema,
+		})
+		if p.In == "path" {
+			documented[p.Name] = true
+		}
+	}
+	for _, name := range pathParams(path) {
+		if !documented[name] {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+
+	if route.Request != nil {
+		contentType := route.RequestType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{contentType: {Schema: registry.schemaOf(route.Request)}},
+		}
+	}
+
+	for _, resp := range route.Responses {
+		description := resp.Description
+		if description == "" {
+			description = http.StatusText(resp.Status)
+		}
+		obj := ResponseObject{Description: description}
+
+		body := resp.Body
+		if body == nil && resp.Status >= 400 {
+			body = errorBody
+		}
+		if body != nil {
+			contentType := resp.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			obj.Content = map[string]MediaType{contentType: {Schema: registry.schemaOf(body)}}
+		}
+		op.Responses[strconv.Itoa(resp.Status)] = obj
+	}
+
+	for _, name := range route.Security {
+		op.Security = append(op.Security, map[string][]string{name: {}})
+	}
+	return op
+}
+
+// ParsePattern splits an http.ServeMux pattern into method and OpenAPI path
+func ParsePattern(pattern string) (string, string, error) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found || method == "" || !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("pattern %q must have the form \"METHOD /path\"", pattern)
+	}
+	path = strings.TrimSuffix(path, "{$}")
+	path = strings.ReplaceAll(path, "...}", "}")
+	return method, path, nil
+}
+
+// pathParams returns the {names} of an OpenAPI path
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, segment[1:len(segment)-1])
+		}
+	}
+	return names
+}
+
+// operationID turns "GET /messages/{id}" into "getMessagesId"
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func (s *SpecImpl) Document() *Document {
+	return s.document
+}
+
+// GET Handler /openapi.json
+func (s *SpecImpl) Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.encoded)
+}
@@ -0,0 +1,101 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Parent    *testItem `json:"parent"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"-"`
+	internal  int
+}
+
+func TestSchemaFor(t *testing.T) {
+	registry := newSchemaRegistry()
+	ref := registry.schemaOf(testItem{})
+	if ref.Ref != "#/components/schemas/testItem" {
+		t.Fatalf("expected $ref to testItem, got %+v", ref)
+	}
+
+	schema := registry.schemas["testItem"]
+	if schema.Type != "object" {
+		t.Fatalf("expected object, got %q", schema.Type)
+	}
+	if len(schema.Properties) != 5 {
+		t.Errorf("expected 5 properties, got %v", schema.Properties)
+	}
+	if got := schema.Properties["id"]; got.Type != "integer" || got.Format != "int64" {
+		t.Errorf("unexpected id schema %+v", got)
+	}
+	if got := schema.Properties["created_at"]; got.Type != "string" || got.Format != "date-time" {
+		t.Errorf("unexpected created_at schema %+v", got)
+	}
+	if got := schema.Properties["tags"]; got.Type != "array" || got.Items.Type != "string" {
+		t.Errorf("unexpected tags schema %+v", got)
+	}
+	if got := schema.Properties["parent"]; got.Ref != "#/components/schemas/testItem" {
+		t.Errorf("expected recursive $ref for parent, got %+v", got)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"id", "tags", "created_at"}) {
+		t.Errorf("unexpected required fields %v", schema.Required)
+	}
+}
+
+func TestNewSpec(t *testing.T) {
+	spec, err := NewSpec(Config{
+		Title: "test",
+		Routes: []Route{
+			{Pattern: "GET /items/{id}", Params: []Param{{Name: "id", In: "path", Schema: &Schema{Type: "integer"}}},
+				Responses: []Response{{Status: 200, Body: testItem{}}, {Status: 404}}},
+			{Pattern: "GET /files/{path...}", Responses: []Response{{Status: 200}}},
+		},
+		ErrorBody: struct {
+			Error string `json:"error"`
+		}{},
+	})
+	if err != nil {
+		t.Fatalf("NewSpec: %v", err)
+	}
+	doc := spec.Document()
+
+	op := doc.Paths["/items/{id}"]["get"]
+	if op == nil {
+		t.Fatalf("expected GET /items/{id}, got %v", doc.Paths)
+	}
+	if op.OperationID != "getItemsId" {
+		t.Errorf("unexpected operation id %q", op.OperationID)
+	}
+	if len(op.Parameters) != 1 || op.Parameters[0].Schema.Type != "integer" || !op.Parameters[0].Required {
+		t.Errorf("unexpected parameters %+v", op.Parameters)
+	}
+	if op.Responses["404"].Content["application/json"].Schema.Properties["error"] == nil {
+		t.Errorf("expected error body for 404, got %+v", op.Responses["404"])
+	}
+
+	files := doc.Paths["/files/{path}"]["get"]
+	if files == nil || len(files.Parameters) != 1 || files.Parameters[0].Name != "path" {
+		t.Errorf("expected wildcard path parameter, got %+v", doc.Paths)
+	}
+}
+
+func TestNewSpec_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+	}{
+		{"no method", []Route{{Pattern: "/items"}}},
+		{"duplicate", []Route{{Pattern: "GET /items"}, {Pattern: "GET /items"}}},
+		{"unknown security", []Route{{Pattern: "GET /items", Security: []string{"missing"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewSpec(Config{Title: "test", Routes: tt.routes}); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
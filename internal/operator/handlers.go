@@ -0,0 +1,130 @@
+package operator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+)
+
+// ChatList lists chats answered by operators
+type ChatList struct {
+	Chats []models.ChatMode `json:"chats"`
+}
+
+// HandoffRequest is the body of POST /operator/chats/{chatID}/handoff
+type HandoffRequest struct {
+	Operator string `json:"operator"`
+}
+
+// ReplyRequest is the body of POST /operator/chats/{chatID}/reply
+type ReplyRequest struct {
+	Operator string `json:"operator"`
+	Text     string `json:"text"`
+}
+
+// ModeResponse reports who answers a chat after a handoff or release
+type ModeResponse struct {
+	Mode string `json:"mode"`
+}
+
+// StatusResponse reports the result of a reply
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// GET Handler /operator/chats (chats answered by operators)
+func (o *OperatorImpl) ListChatsHandler(w http.ResponseWriter, r *http.Request) {
+	chats, err := o.ListChats(r.Context())
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+		return
+	}
+	if chats == nil {
+		chats = []models.ChatMode{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ChatList{Chats: chats})
+}
+
+// POST Handler /operator/chats/{chatID}/handoff (take the chat over)
+func (o *OperatorImpl) HandoffHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := chatIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req HandoffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+
+	if err := o.StartHandoff(r.Context(), chatID, req.Operator); err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModeResponse{Mode: models.ModeHuman})
+}
+
+// DELETE Handler /operator/chats/{chatID}/handoff (give the chat back to the bot)
+func (o *OperatorImpl) ReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := chatIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := o.Release(r.Context(), chatID); err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ModeResponse{Mode: models.ModeBot})
+}
+
+// POST Handler /operator/chats/{chatID}/reply (send operator answer to the user)
+func (o *OperatorImpl) ReplyHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, ok := chatIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req ReplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if req.Text == "" {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeValidation, "text is required",
+			httperror.FieldError{Field: "text", Message: "is required"})
+		return
+	}
+
+	if err := o.Reply(r.Context(), chatID, req.Operator, req.Text); err != nil {
+		if errors.Is(err, ErrNotHandedOff) {
+			httperror.Write(w, r, http.StatusConflict, httperror.CodeConflict, "chat is not in operator mode")
+			return
+		}
+		o.logger.LogEvent("Error while relaying operator reply: " + err.Error())
+		httperror.Write(w, r, http.StatusBadGateway, httperror.CodeUnavailable, "could not send the reply to Telegram")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatusResponse{Status: "sent"})
+}
+
+func chatIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	chatID, err := strconv.ParseInt(r.PathValue("chatID"), 10, 64)
+	if err != nil {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid chat id")
+		return 0, false
+	}
+	return chatID, true
+}
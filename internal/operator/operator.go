@@ -0,0 +1,274 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"telegram_server/internal/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Operator lets human operators take over conversations from the bot
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	SetChatMode(ctx context.Context, chatID int64, mode, operator string) error
+	GetChatMode(ctx context.Context, chatID int64) (models.ChatMode, error)
+	TouchChat(ctx context.Context, chatID int64) error
+	ListChatsInMode(ctx context.Context, mode string) ([]models.ChatMode, error)
+	ReleaseInactiveChats(ctx context.Context, before time.Time) ([]int64, error)
+	SaveOperatorForward(ctx context.Context, groupMessageID int, chatID int64) error
+	GetForwardedChat(ctx context.Context, groupMessageID int) (int64, bool, error)
+}
+
+type Bot interface {
+	SendMessage(chatID int64, text string) (int, error)
+	ForwardMessage(chatID, fromChatID int64, messageID int) (int, error)
+	CopyMessage(chatID, fromChatID int64, messageID int) (int, error)
+}
+
+type Operator interface {
+	HandleMessage(ctx context.Context, msg *tgbotapi.Message) (bool, error)
+	StartHandoff(ctx context.Context, chatID int64, operator string) error
+	Release(ctx context.Context, chatID int64) error
+	Reply(ctx context.Context, chatID int64, operator, text string) error
+	ListChats(ctx context.Context) ([]models.ChatMode, error)
+	ReleaseInactive(ctx context.Context) error
+	RunAutoRelease(ctx context.Context, interval time.Duration)
+	ListChatsHandler(w http.ResponseWriter, r *http.Request)
+	HandoffHandler(w http.ResponseWriter, r *http.Request)
+	ReleaseHandler(w http.ResponseWriter, r *http.Request)
+	ReplyHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type Config struct {
+	// OperatorChatID is the group where operators see forwarded messages,
+	// zero disables forwarding and operators use the HTTP API only.
+	OperatorChatID    int64
+	InactivityTimeout time.Duration
+	Logger            Logger
+	Database          Database
+	Bot               Bot
+}
+
+type OperatorImpl struct {
+	operatorChatID    int64
+	inactivityTimeout time.Duration
+	logger            Logger
+	database          Database
+	bot               Bot
+}
+
+// ErrNotHandedOff is returned when an operator answers a chat that is handled by the bot
+var ErrNotHandedOff = errors.New("chat is not in operator mode")
+
+// Texts sent to users and operators
+const (
+	handoffCommand = "/operator"
+	releaseCommand = "/release"
+
+	userHandoffText  = "An operator will answer you shortly."
+	userReleaseText  = "You are talking to the bot again."
+	groupHandoffText = "Chat %d is waiting for an operator. Reply to forwarded messages to answer, reply with /release to hand the chat back to the bot."
+	groupReleaseText = "Chat %d is handled by the bot again."
+)
+
+func defaultConfig() Config {
+	return Config{
+		InactivityTimeout: 15 * time.Minute,
+	}
+}
+
+func NewOperator(cfg Config) (Operator, error) {
+	if cfg.Logger == nil {
+		return nil, fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return nil, fmt.Errorf("database is required")
+	}
+	if cfg.Bot == nil {
+		return nil, fmt.Errorf("bot is required")
+	}
+	if cfg.InactivityTimeout == 0 {
+		cfg.InactivityTimeout = defaultConfig().InactivityTimeout
+	}
+
+	return &OperatorImpl{
+		operatorChatID:    cfg.OperatorChatID,
+		inactivityTimeout: cfg.InactivityTimeout,
+		logger:            cfg.Logger,
+		database:          cfg.Database,
+		bot:               cfg.Bot,
+	}, nil
+}
+
+// HandleMessage is called by the bot for every incoming message. It returns true
+// when the message was taken care of and the bot must not answer it itself.
+func (o *OperatorImpl) HandleMessage(ctx context.Context, msg *tgbotapi.Message) (bool, error) {
+	if msg == nil || msg.Chat == nil {
+		return false, nil
+	}
+
+	if o.operatorChatID != 0 && msg.Chat.ID == o.operatorChatID {
+		return true, o.handleGroupMessage(ctx, msg)
+	}
+
+	if msg.Text == handoffCommand {
+		return true, o.StartHandoff(ctx, msg.Chat.ID, "")
+	}
+
+	mode, err := o.database.GetChatMode(ctx, msg.Chat.ID)
+	if err != nil {
+		return false, err
+	}
+	if mode.Mode != models.ModeHuman {
+		return false, nil
+	}
+
+	if err := o.database.TouchChat(ctx, msg.Chat.ID); err != nil {
+		return true, err
+	}
+	if o.operatorChatID == 0 {
+		// Operators read the transcript over HTTP
+		return true, nil
+	}
+
+	forwardedID, err := o.bot.ForwardMessage(o.operatorChatID, msg.Chat.ID, msg.MessageID)
+	if err != nil {
+		return true, err
+	}
+	return true, o.database.SaveOperatorForward(ctx, forwardedID, msg.Chat.ID)
+}
+
+// handleGroupMessage relays operator replies from the operators' group to users
+func (o *OperatorImpl) handleGroupMessage(ctx context.Context, msg *tgbotapi.Message) error {
+	if msg.ReplyToMessage == nil {
+		return nil
+	}
+
+	chatID, found, err := o.database.GetForwardedChat(ctx, msg.ReplyToMessage.MessageID)
+	if err != nil || !found {
+		return err
+	}
+
+	if msg.Text == releaseCommand {
+		return o.Release(ctx, chatID)
+	}
+
+	operator := ""
+	if msg.From != nil {
+		operator = msg.From.UserName
+	}
+	if msg.Text == "" {
+		// Photos, stickers, documents and other media are copied as they are
+		return o.relay(ctx, chatID, operator, func() (int, error) {
+			return o.bot.CopyMessage(chatID, msg.Chat.ID, msg.MessageID)
+		})
+	}
+	return o.Reply(ctx, chatID, operator, msg.Text)
+}
+
+// StartHandoff switches chatID to human mode and notifies the user and operators
+func (o *OperatorImpl) StartHandoff(ctx context.Context, chatID int64, operator string) error {
+	if err := o.database.SetChatMode(ctx, chatID, models.ModeHuman, operator); err != nil {
+		return err
+	}
+	o.logger.LogEvent("Chat " + strconv.FormatInt(chatID, 10) + " handed off to operator " + operator)
+
+	if _, err := o.bot.SendMessage(chatID, userHandoffText); err != nil {
+		o.logger.LogEvent("Error while notifying user about handoff: " + err.Error())
+	}
+	o.notifyGroup(fmt.Sprintf(groupHandoffText, chatID))
+	return nil
+}
+
+// Release hands chatID back to the bot
+func (o *OperatorImpl) Release(ctx context.Context, chatID int64) error {
+	if err := o.database.SetChatMode(ctx, chatID, models.ModeBot, ""); err != nil {
+		return err
+	}
+	o.released(chatID)
+	return nil
+}
+
+// Reply sends an operator's answer to the user through the bot
+func (o *OperatorImpl) Reply(ctx context.Context, chatID int64, operator, text string) error {
+	return o.relay(ctx, chatID, operator, func() (int, error) {
+		return o.bot.SendMessage(chatID, text)
+	})
+}
+
+// relay sends an operator's answer with send when chatID is handed off
+func (o *OperatorImpl) relay(ctx context.Context, chatID int64, operator string, send func() (int, error)) error {
+	mode, err := o.database.GetChatMode(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if mode.Mode != models.ModeHuman {
+		return fmt.Errorf("chat %d: %w", chatID, ErrNotHandedOff)
+	}
+
+	if _, err := send(); err != nil {
+		return err
+	}
+	o.logger.LogEvent("Operator " + operator + " replied to chat " + strconv.FormatInt(chatID, 10))
+	return o.database.TouchChat(ctx, chatID)
+}
+
+// ListChats returns chats currently answered by operators
+func (o *OperatorImpl) ListChats(ctx context.Context) ([]models.ChatMode, error) {
+	return o.database.ListChatsInMode(ctx, models.ModeHuman)
+}
+
+// ReleaseInactive hands chats idle for longer than the inactivity timeout back to the bot
+func (o *OperatorImpl) ReleaseInactive(ctx context.Context) error {
+	chatIDs, err := o.database.ReleaseInactiveChats(ctx, time.Now().Add(-o.inactivityTimeout))
+	if err != nil {
+		return err
+	}
+	for _, chatID := range chatIDs {
+		o.released(chatID)
+	}
+	return nil
+}
+
+// RunAutoRelease calls ReleaseInactive every interval until ctx is done
+func (o *OperatorImpl) RunAutoRelease(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.ReleaseInactive(ctx); err != nil {
+				o.logger.LogEvent("Error while releasing inactive chats: " + err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *OperatorImpl) released(chatID int64) {
+	o.logger.LogEvent("Chat " + strconv.FormatInt(chatID, 10) + " handed back to the bot")
+	if _, err := o.bot.SendMessage(chatID, userReleaseText); err != nil {
+		o.logger.LogEvent("Error while notifying user about release: " + err.Error())
+	}
+	o.notifyGroup(fmt.Sprintf(groupReleaseText, chatID))
+}
+
+func (o *OperatorImpl) notifyGroup(text string) {
+	if o.operatorChatID == 0 {
+		return
+	}
+	if _, err := o.bot.SendMessage(o.operatorChatID, text); err != nil {
+		o.logger.LogEvent("Error while notifying operators: " + err.Error())
+	}
+}
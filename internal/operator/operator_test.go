@@ -0,0 +1,224 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type testLogger struct {
+	events []string
+}
+
+func (l *testLogger) LogEvent(event string) {
+	l.events = append(l.events, event)
+}
+
+// fakeDatabase keeps chat modes and forwards in memory.
+type fakeDatabase struct {
+	modes    map[int64]models.ChatMode
+	forwards map[int]int64
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{modes: map[int64]models.ChatMode{}, forwards: map[int]int64{}}
+}
+
+func (f *fakeDatabase) SetChatMode(ctx context.Context, chatID int64, mode, operator string) error {
+	f.modes[chatID] = models.ChatMode{ChatID: chatID, Mode: mode, Operator: operator, LastActivityAt: time.Now()}
+	return nil
+}
+
+func (f *fakeDatabase) GetChatMode(ctx context.Context, chatID int64) (models.ChatMode, error) {
+	if m, ok := f.modes[chatID]; ok {
+		return m, nil
+	}
+	return models.ChatMode{ChatID: chatID, Mode: models.ModeBot}, nil
+}
+
+func (f *fakeDatabase) TouchChat(ctx context.Context, chatID int64) error {
+	m := f.modes[chatID]
+	m.LastActivityAt = time.Now()
+	f.modes[chatID] = m
+	return nil
+}
+
+func (f *fakeDatabase) ListChatsInMode(ctx context.Context, mode string) ([]models.ChatMode, error) {
+	var chats []models.ChatMode
+	for _, m := range f.modes {
+		if m.Mode == mode {
+			chats = append(chats, m)
+		}
+	}
+	return chats, nil
+}
+
+func (f *fakeDatabase) ReleaseInactiveChats(ctx context.Context, before time.Time) ([]int64, error) {
+	var released []int64
+	for id, m := range f.modes {
+		if m.Mode == models.ModeHuman && m.LastActivityAt.Before(before) {
+			m.Mode = models.ModeBot
+			f.modes[id] = m
+			released = append(released, id)
+		}
+	}
+	return released, nil
+}
+
+func (f *fakeDatabase) SaveOperatorForward(ctx context.Context, groupMessageID int, chatID int64) error {
+	f.forwards[groupMessageID] = chatID
+	return nil
+}
+
+func (f *fakeDatabase) GetForwardedChat(ctx context.Context, groupMessageID int) (int64, bool, error) {
+	chatID, ok := f.forwards[groupMessageID]
+	return chatID, ok, nil
+}
+
+type sentMessage struct {
+	chatID int64
+	text   string
+}
+
+// fakeBot records sent, forwarded and copied messages.
+type fakeBot struct {
+	sent      []sentMessage
+	forwarded int
+	copied    []int
+}
+
+func (b *fakeBot) SendMessage(chatID int64, text string) (int, error) {
+	b.sent = append(b.sent, sentMessage{chatID, text})
+	return len(b.sent), nil
+}
+
+func (b *fakeBot) ForwardMessage(chatID, fromChatID int64, messageID int) (int, error) {
+	b.forwarded++
+	return 1000 + b.forwarded, nil
+}
+
+func (b *fakeBot) CopyMessage(chatID, fromChatID int64, messageID int) (int, error) {
+	b.copied = append(b.copied, messageID)
+	return 2000 + len(b.copied), nil
+}
+
+const groupID = -100
+
+func newTestOperator(t *testing.T) (*OperatorImpl, *fakeDatabase, *fakeBot) {
+	t.Helper()
+	db := newFakeDatabase()
+	bot := &fakeBot{}
+	op, err := NewOperator(Config{
+		OperatorChatID:    groupID,
+		InactivityTimeout: time.Minute,
+		Logger:            &testLogger{},
+		Database:          db,
+		Bot:               bot,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return op.(*OperatorImpl), db, bot
+}
+
+func message(chatID int64, messageID int, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: messageID,
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		From:      &tgbotapi.User{UserName: "someone"},
+		Text:      text,
+	}
+}
+
+func TestHandleMessage_BotModeNotHandled(t *testing.T) {
+	op, _, bot := newTestOperator(t)
+
+	handled, err := op.HandleMessage(context.Background(), message(1, 1, "hello"))
+	if err != nil || handled {
+		t.Fatalf("expected message to be left to the bot, handled=%v err=%v", handled, err)
+	}
+	if len(bot.sent) != 0 {
+		t.Errorf("expected no messages sent, got %v", bot.sent)
+	}
+}
+
+func TestHandleMessage_HandoffAndRelay(t *testing.T) {
+	op, db, bot := newTestOperator(t)
+	ctx := context.Background()
+
+	if handled, err := op.HandleMessage(ctx, message(1, 1, handoffCommand)); err != nil || !handled {
+		t.Fatalf("expected handoff command to be handled, handled=%v err=%v", handled, err)
+	}
+	if db.modes[1].Mode != models.ModeHuman {
+		t.Fatalf("expected chat in human mode, got %q", db.modes[1].Mode)
+	}
+
+	// User message is forwarded to the operators' group.
+	if handled, err := op.HandleMessage(ctx, message(1, 2, "help me")); err != nil || !handled {
+		t.Fatalf("expected message to be handled, handled=%v err=%v", handled, err)
+	}
+	if db.forwards[1001] != 1 {
+		t.Fatalf("expected forward to be recorded, got %v", db.forwards)
+	}
+
+	// Operator replies to the forwarded message in the group.
+	reply := message(groupID, 50, "how can I help?")
+	reply.ReplyToMessage = &tgbotapi.Message{MessageID: 1001}
+	bot.sent = nil
+	if handled, err := op.HandleMessage(ctx, reply); err != nil || !handled {
+		t.Fatalf("expected operator reply to be handled, handled=%v err=%v", handled, err)
+	}
+	if len(bot.sent) != 1 || bot.sent[0].chatID != 1 || bot.sent[0].text != "how can I help?" {
+		t.Errorf("expected reply relayed to user, got %v", bot.sent)
+	}
+
+	// A sticker has no text and is copied.
+	sticker := message(groupID, 52, "")
+	sticker.Sticker = &tgbotapi.Sticker{FileID: "sticker"}
+	sticker.ReplyToMessage = &tgbotapi.Message{MessageID: 1001}
+	if _, err := op.HandleMessage(ctx, sticker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bot.copied) != 1 || bot.copied[0] != 52 || len(bot.sent) != 1 {
+		t.Errorf("expected sticker copied to user, copied %v sent %v", bot.copied, bot.sent)
+	}
+
+	// Operator releases the chat.
+	release := message(groupID, 51, releaseCommand)
+	release.ReplyToMessage = &tgbotapi.Message{MessageID: 1001}
+	if _, err := op.HandleMessage(ctx, release); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db.modes[1].Mode != models.ModeBot {
+		t.Errorf("expected chat back in bot mode, got %q", db.modes[1].Mode)
+	}
+}
+
+func TestReply_NotHandedOff(t *testing.T) {
+	op, _, _ := newTestOperator(t)
+
+	err := op.Reply(context.Background(), 1, "op", "hi")
+	if !errors.Is(err, ErrNotHandedOff) {
+		t.Errorf("expected ErrNotHandedOff, got %v", err)
+	}
+}
+
+func TestReleaseInactive(t *testing.T) {
+	op, db, _ := newTestOperator(t)
+	db.modes[1] = models.ChatMode{ChatID: 1, Mode: models.ModeHuman, LastActivityAt: time.Now().Add(-time.Hour)}
+	db.modes[2] = models.ChatMode{ChatID: 2, Mode: models.ModeHuman, LastActivityAt: time.Now()}
+
+	if err := op.ReleaseInactive(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db.modes[1].Mode != models.ModeBot {
+		t.Error("expected inactive chat to be released")
+	}
+	if db.modes[2].Mode != models.ModeHuman {
+		t.Error("expected active chat to stay with the operator")
+	}
+}
@@ -0,0 +1,55 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Requestid carries the id of an HTTP request in its context. The server
+// takes the id from the X-Request-ID header of the caller or makes a new one,
+// it is sent back in the response and in error bodies and written to the
+// access log so logs of one request can be found together.
+
+// Header is the request and response header of the id
+const Header = "X-Request-ID"
+
+// MaxLength is the longest id accepted from callers
+const MaxLength = 128
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id put into the context by NewContext
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+// New returns a random id
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Valid tells whether an id given by a caller can be used: up to MaxLength
+// letters, digits and "-_.:", so it is safe in headers and logs
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
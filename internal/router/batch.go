@@ -0,0 +1,306 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+	"unicode/utf8"
+)
+
+// BatchMessage is a line of POST /messages:batch. Besides the fields of
+// NewMessage it takes what history migrated from another system knows about
+// a message, a missing created_at means now.
+type BatchMessage struct {
+	NewMessage
+	ChatID    int64      `json:"chat_id,omitempty"`
+	Direction string     `json:"direction,omitempty"`
+	MessageID int        `json:"message_id,omitempty"`
+	ReplyTo   *int       `json:"reply_to,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// BatchResult summarizes POST /messages:batch. Lines are NDJSON lines or JSON
+// array elements counted from 1, only rejected lines are listed.
+type BatchResult struct {
+	Received int         `json:"received"`
+	Inserted int64       `json:"inserted"`
+	Rejected int         `json:"rejected"`
+	Errors   []LineError `json:"errors"`
+	// ErrorsTruncated is set when more lines were rejected than are listed
+	ErrorsTruncated bool `json:"errors_truncated,omitempty"`
+	// LastLine is the last line that was committed or rejected. A failed
+	// import has saved the lines up to it, it resumes with the lines after.
+	LastLine int `json:"last_line"`
+	// Error tells why the import stopped, the summary covers the lines up to LastLine
+	Error *httperror.ErrorBody `json:"error,omitempty"`
+}
+
+// LineError tells why a line of a batch was not inserted
+type LineError struct {
+	Line    int          `json:"line"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+const (
+	// importBatchSize is the number of messages copied at once
+	importBatchSize = 1000
+	// importCommitBatches is the number of batches committed together
+	importCommitBatches = 10
+	// maxBatchErrors bounds the rejected lines listed in a BatchResult
+	maxBatchErrors = 1000
+	// importTimeout replaces the server read and write timeouts for imports
+	importTimeout = 30 * time.Minute
+)
+
+var errEmptyBatch = errors.New("request body is empty")
+
+// batchReader returns the lines of a batch body one by one and io.EOF after the last
+type batchReader interface {
+	next() (line int, raw []byte, err error)
+}
+
+// ndjsonReader reads one JSON value per line, blank lines are skipped
+type ndjsonReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (n *ndjsonReader) next() (int, []byte, error) {
+	for {
+		raw, err := n.r.ReadBytes('\n')
+		if len(raw) == 0 && err != nil {
+			if err == io.EOF && n.line == 0 {
+				return 0, nil, errEmptyBatch
+			}
+			return 0, nil, err
+		}
+		n.line++
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 {
+			return n.line, raw, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// arrayReader reads the elements of a JSON array
+type arrayReader struct {
+	decoder *json.Decoder
+	line    int
+	started bool
+	done    bool
+}
+
+func (a *arrayReader) next() (int, []byte, error) {
+	if a.done {
+		return 0, nil, io.EOF
+	}
+	if !a.started {
+		token, err := a.decoder.Token()
+		if err == io.EOF {
+			return 0, nil, errEmptyBatch
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		if token != json.Delim('[') {
+			return 0, nil, errors.New("invalid JSON body: expected an array of messages")
+		}
+		a.started = true
+	}
+
+	if !a.decoder.More() {
+		if _, err := a.decoder.Token(); err != nil {
+			return 0, nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		if _, err := a.decoder.Token(); err != io.EOF {
+			return 0, nil, errors.New("invalid JSON body: data after the array")
+		}
+		a.done = true
+		return 0, nil, io.EOF
+	}
+	a.line++
+	var raw json.RawMessage
+	if err := a.decoder.Decode(&raw); err != nil {
+		return 0, nil, fmt.Errorf("invalid JSON body at element %d: %w", a.line, err)
+	}
+	return a.line, raw, nil
+}
+
+// newBatchReader picks the reader for the request content type, JSON arrays
+// are the default
+func newBatchReader(r *http.Request) (batchReader, bool) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, false
+		}
+	}
+	switch mediaType {
+	case "application/json":
+		return &arrayReader{decoder: json.NewDecoder(r.Body)}, true
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return &ndjsonReader{r: bufio.NewReader(r.Body)}, true
+	}
+	return nil, false
+}
+
+// parseBatchLine decodes and validates one message of a batch
+func parseBatchLine(line int, raw []byte) (models.Message, *LineError) {
+	// encoding/json silently replaces invalid UTF-8 with U+FFFD
+	if !utf8.Valid(raw) {
+		return models.Message{}, &LineError{Line: line, Message: "must be valid UTF-8"}
+	}
+	var msg BatchMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return models.Message{}, &LineError{Line: line, Message: "invalid JSON: " + err.Error()}
+	}
+	fields := validateMessage(&msg.Username, &msg.Text)
+	switch msg.Direction {
+	case "", models.DirectionIncoming, models.DirectionOutgoing:
+	default:
+		fields = append(fields, FieldError{Field: "direction", Message: "must be in or out"})
+	}
+	if msg.MessageID < 0 {
+		fields = append(fields, FieldError{Field: "message_id", Message: "must be positive"})
+	}
+	if msg.ReplyTo != nil && *msg.ReplyTo <= 0 {
+		fields = append(fields, FieldError{Field: "reply_to", Message: "must be positive"})
+	}
+	if msg.ChatID == 0 && (msg.MessageID != 0 || msg.ReplyTo != nil) {
+		fields = append(fields, FieldError{Field: "chat_id", Message: "is required with message_id and reply_to"})
+	}
+	if msg.CreatedAt != nil && msg.CreatedAt.After(time.Now()) {
+		fields = append(fields, FieldError{Field: "created_at", Message: "must not be in the future"})
+	}
+	if fields != nil {
+		return models.Message{}, &LineError{Line: line, Message: "validation failed", Fields: fields}
+	}
+
+	imported := models.Message{
+		UserName:  msg.Username,
+		Text:      msg.Text,
+		ChatID:    msg.ChatID,
+		MessageID: msg.MessageID,
+		ReplyTo:   msg.ReplyTo,
+		Direction: msg.Direction,
+		Status:    models.StatusReceived,
+	}
+	if msg.Direction == models.DirectionOutgoing {
+		imported.Status = models.StatusSent
+	}
+	if msg.CreatedAt != nil {
+		imported.CreatedAt = *msg.CreatedAt
+	}
+	return imported, nil
+}
+
+func (b *BatchResult) reject(e LineError) {
+	b.Rejected++
+	if len(b.Errors) < maxBatchErrors {
+		b.Errors = append(b.Errors, e)
+	} else {
+		b.ErrorsTruncated = true
+	}
+}
+
+// POST Handler /messages:batch (import messages from a JSON array or NDJSON)
+func (rt *RouterImpl) BatchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	reader, ok := newBatchReader(r)
+	if !ok {
+		writeError(w, r, http.StatusUnsupportedMediaType, httperror.CodeUnsupportedMediaType,
+			"Content-Type must be application/json or application/x-ndjson")
+		return
+	}
+
+	// Large imports take longer than the server timeouts allow
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(importTimeout))
+	rc.SetWriteDeadline(time.Now().Add(importTimeout))
+
+	// Every importCommitBatches batches are committed, a failure keeps what
+	// was committed and tells the client where to resume
+	result := BatchResult{Errors: []LineError{}}
+	var (
+		readErr  error
+		lastLine int
+		done     bool
+	)
+	for !done {
+		var received int
+		var rejected []LineError
+		batches := 0
+		inserted, err := rt.database.ImportMessages(r.Context(), func() ([]models.Message, error) {
+			if done || batches == importCommitBatches {
+				return nil, nil
+			}
+			batches++
+			batch := make([]models.Message, 0, importBatchSize)
+			for len(batch) < importBatchSize {
+				line, raw, err := reader.next()
+				if err == io.EOF {
+					done = true
+					break
+				}
+				if err != nil {
+					readErr = err
+					return nil, err
+				}
+				lastLine = line
+				received++
+				msg, lineErr := parseBatchLine(line, raw)
+				if lineErr != nil {
+					rejected = append(rejected, *lineErr)
+					continue
+				}
+				batch = append(batch, msg)
+			}
+			return batch, nil
+		})
+
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(readErr, &maxBytesErr):
+			writeBatchError(w, r, http.StatusRequestEntityTooLarge, httperror.CodeTooLarge,
+				"request body too large, at most "+strconv.FormatInt(maxBytesErr.Limit, 10)+" bytes, the lines after last_line were not imported", result)
+			return
+		case readErr != nil:
+			writeBatchError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, readErr.Error(), result)
+			return
+		case err != nil:
+			status, code, message := rt.databaseError("importing messages", err)
+			writeBatchError(w, r, status, code, message, result)
+			return
+		}
+
+		result.Received += received
+		result.Inserted += inserted
+		for _, e := range rejected {
+			result.reject(e)
+		}
+		result.LastLine = lastLine
+	}
+
+	// Imported history is not new, it is neither streamed nor announced as message.created
+	rt.logger.LogEvent("Imported " + strconv.FormatInt(result.Inserted, 10) + " messages, rejected " + strconv.Itoa(result.Rejected))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// writeBatchError answers a failed import with the summary of the lines up to
+// LastLine and the error envelope
+func writeBatchError(w http.ResponseWriter, r *http.Request, status int, code, message string, result BatchResult) {
+	result.Error = &httperror.ErrorBody{Code: code, Message: message, RequestID: httperror.RequestID(r)}
+	writeJSON(w, status, result)
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"telegram_server/internal/httperror"
+	"unicode/utf8"
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError = httperror.FieldError
+
+// writeError sends the error envelope with the given status
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, fields ...FieldError) {
+	httperror.Write(w, r, status, code, message, fields...)
+}
+
+// writeValidationError sends 422 with the rejected fields
+func writeValidationError(w http.ResponseWriter, r *http.Request, fields []FieldError) {
+	writeError(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "request validation failed", fields...)
+}
+
+// writeDatabaseError answers a failed database call: 503 when the database
+// cannot be reached at all, 500 otherwise.
+func (rt *RouterImpl) writeDatabaseError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	status, code, message := rt.databaseError(action, err)
+	writeError(w, r, status, code, message)
+}
+
+// databaseError logs a failed database call and picks the answer of writeDatabaseError
+func (rt *RouterImpl) databaseError(action string, err error) (int, string, string) {
+	rt.logger.LogEvent("Error while " + action + ": " + err.Error())
+	if pingErr := rt.database.Ping(); pingErr != nil {
+		return http.StatusServiceUnavailable, httperror.CodeUnavailable, "database unavailable"
+	}
+	return http.StatusInternalServerError, httperror.CodeInternal, "internal server error"
+}
+
+// decodeJSON reads the request body into dst, answering 413 for bodies over
+// the server limit and 400 for empty, non UTF-8 or malformed JSON bodies.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any, strict bool) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, httperror.CodeTooLarge, "request body too large")
+		} else {
+			writeError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "could not read request body")
+		}
+		return false
+	}
+	if len(body) == 0 {
+		writeError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "request body is empty")
+		return false
+	}
+	// encoding/json silently replaces invalid UTF-8 with U+FFFD
+	if !utf8.Valid(body) {
+		writeError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "request body must be valid UTF-8")
+		return false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(dst); err != nil {
+		writeError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// validateField checks a required string field for UTF-8 and its length in characters
+func validateField(name, value string, maxLength int) *FieldError {
+	switch {
+	case value == "":
+		return &FieldError{Field: name, Message: "is required"}
+	case !utf8.ValidString(value):
+		return &FieldError{Field: name, Message: "must be valid UTF-8"}
+	case utf8.RuneCountInString(value) > maxLength:
+		return &FieldError{Field: name, Message: "must be at most " + strconv.Itoa(maxLength) + " characters"}
+	}
+	return nil
+}
+
+// validateMessage checks username and text of a message, nil fields are skipped
+func validateMessage(username, text *string) []FieldError {
+	var fields []FieldError
+	if username != nil {
+		if fe := validateField("username", *username, maxUsernameLength); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+	if text != nil {
+		if fe := validateField("text", *text, maxTextLength); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+	return fields
+}
+
+// writeJSON sends v as a JSON body with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
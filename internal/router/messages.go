@@ -0,0 +1,197 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageList is a page of messages, NextCursor is empty on the last page
+type MessageList struct {
+	Messages   []models.Message `json:"messages"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// MessagePatch is the body of PATCH /messages/{id}, omitted fields are kept
+type MessagePatch struct {
+	Username *string `json:"username"`
+	Text     *string `json:"text"`
+}
+
+// parseMessageFilter reads GET /messages query parameters:
+// username, chat_id, from, to (RFC 3339), q, order (asc|desc), limit and cursor.
+func parseMessageFilter(query url.Values) (models.MessageFilter, []FieldError) {
+	var fields []FieldError
+	filter := models.MessageFilter{
+		UserName: query.Get("username"),
+		Text:     query.Get("q"),
+	}
+
+	if s := query.Get("chat_id"); s != "" {
+		chatID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "chat_id", Message: "must be an integer"})
+		} else {
+			filter.ChatID = &chatID
+		}
+	}
+	for _, name := range []string{"from", "to"} {
+		dst := &filter.From
+		if name == "to" {
+			dst = &filter.To
+		}
+		if s := query.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				fields = append(fields, FieldError{Field: name, Message: "must be an RFC 3339 time"})
+				continue
+			}
+			*dst = t
+		}
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		fields = append(fields, FieldError{Field: "order", Message: "must be asc or desc"})
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			fields = append(fields, FieldError{Field: "limit", Message: "must be a positive integer"})
+		} else {
+			filter.Limit = limit
+		}
+	}
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := models.DecodeMessageCursor(s)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "cursor", Message: "is malformed"})
+		} else {
+			filter.After = &cursor
+		}
+	}
+	for _, name := range []string{"username", "q"} {
+		if !utf8.ValidString(query.Get(name)) {
+			fields = append(fields, FieldError{Field: name, Message: "must be valid UTF-8"})
+		}
+	}
+	return filter, fields
+}
+
+// GET Handler /messages (paginated and filtered list of messages)
+func (rt *RouterImpl) ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, fields := parseMessageFilter(r.URL.Query())
+	if fields != nil {
+		writeValidationError(w, r, fields)
+		return
+	}
+
+	page, err := rt.database.ListMessages(r.Context(), filter)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "listing messages", err)
+		return
+	}
+
+	resp := MessageList{Messages: page.Messages}
+	if resp.Messages == nil {
+		resp.Messages = []models.Message{}
+	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Limits for message fields
+const (
+	maxUsernameLength = 64
+	maxTextLength     = 4096
+)
+
+func messageIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid message id")
+		return 0, false
+	}
+	return id, true
+}
+
+// GET Handler /messages/{id}
+func (rt *RouterImpl) GetMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := messageIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	message, found, err := rt.database.GetMessage(r.Context(), id)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "getting message", err)
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, httperror.CodeNotFound, "message not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, message)
+}
+
+// PATCH Handler /messages/{id} (change username and/or text)
+func (rt *RouterImpl) UpdateMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := messageIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req MessagePatch
+	if !decodeJSON(w, r, &req, true) {
+		return
+	}
+	if req.Username == nil && req.Text == nil {
+		writeError(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "nothing to update, expected username or text")
+		return
+	}
+	if fields := validateMessage(req.Username, req.Text); fields != nil {
+		writeValidationError(w, r, fields)
+		return
+	}
+
+	message, found, err := rt.database.UpdateMessage(r.Context(), id, req.Username, req.Text)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "updating message", err)
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, httperror.CodeNotFound, "message not found")
+		return
+	}
+	rt.logger.LogEvent("Message " + strconv.FormatInt(id, 10) + " updated")
+	writeJSON(w, http.StatusOK, message)
+}
+
+// DELETE Handler /messages/{id}
+func (rt *RouterImpl) DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := messageIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	found, err := rt.database.DeleteMessage(r.Context(), id)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "deleting message", err)
+		return
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, httperror.CodeNotFound, "message not found")
+		return
+	}
+	rt.logger.LogEvent("Message " + strconv.FormatInt(id, 10) + " deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package router
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// GET Handler /polls/{pollID}/results (aggregated poll results)
+func (rt *RouterImpl) PollResultsHandler(w http.ResponseWriter, r *http.Request) {
+	poll, ok := rt.pollFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := rt.database.GetPollResults(r.Context(), poll)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "getting poll results", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// GET Handler /polls/{pollID}/export (CSV with the answer of every user)
+func (rt *RouterImpl) PollExportHandler(w http.ResponseWriter, r *http.Request) {
+	poll, ok := rt.pollFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	votes, err := rt.database.GetPollVotes(r.Context(), poll.ID)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "getting poll votes", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="poll-`+poll.ID+`.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"poll_id", "user_id", "username", "option_ids", "options", "answered_at"})
+	for _, vote := range votes {
+		ids := make([]string, len(vote.OptionIDs))
+		texts := make([]string, len(vote.OptionIDs))
+		for i, id := range vote.OptionIDs {
+			ids[i] = strconv.Itoa(id)
+			if id >= 0 && id < len(poll.Options) {
+				texts[i] = poll.Options[id]
+			}
+		}
+		cw.Write([]string{
+			vote.PollID,
+			strconv.FormatInt(vote.UserID, 10),
+			vote.UserName,
+			strings.Join(ids, ";"),
+			strings.Join(texts, ";"),
+			vote.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		rt.logger.LogEvent("Error while writing poll export: " + err.Error())
+	}
+}
+
+func (rt *RouterImpl) pollFromPath(w http.ResponseWriter, r *http.Request) (models.Poll, bool) {
+	poll, found, err := rt.database.GetPoll(r.Context(), r.PathValue("pollID"))
+	if err != nil {
+		rt.writeDatabaseError(w, r, "getting poll", err)
+		return poll, false
+	}
+	if !found {
+		writeError(w, r, http.StatusNotFound, httperror.CodeNotFound, "poll not found")
+		return poll, false
+	}
+	return poll, true
+}
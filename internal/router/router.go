@@ -2,9 +2,10 @@ package router
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"telegram_server/internal/httperror"
 	"telegram_server/internal/models"
 )
 
@@ -13,23 +14,71 @@ type Logger interface {
 }
 
 type Database interface {
-	SaveMessage(ctx context.Context, username, text string) error
+	Ping() error
+	SaveMessage(ctx context.Context, username, text string) (models.Message, error)
+	ImportMessages(ctx context.Context, next func() ([]models.Message, error)) (int64, error)
 	GetMessages(ctx context.Context) ([]models.Message, error)
+	GetConversation(ctx context.Context, chatID int64) ([]models.Message, error)
+	ListMessages(ctx context.Context, filter models.MessageFilter) (models.MessagePage, error)
+	SearchMessages(ctx context.Context, filter models.SearchFilter) (models.SearchPage, error)
+	GetMessage(ctx context.Context, id int64) (models.Message, bool, error)
+	UpdateMessage(ctx context.Context, id int64, username, text *string) (models.Message, bool, error)
+	DeleteMessage(ctx context.Context, id int64) (bool, error)
+	SaveChatMessage(ctx context.Context, msg models.Message) (int64, error)
+	GetPoll(ctx context.Context, pollID string) (models.Poll, bool, error)
+	GetPollResults(ctx context.Context, poll models.Poll) (models.PollResults, error)
+	GetPollVotes(ctx context.Context, pollID string) ([]models.PollVote, error)
+}
+
+// Notifier is told about every message saved through the router
+type Notifier interface {
+	MessageSaved(ctx context.Context, id int64)
 }
 
 type HttpServer interface {
 	SetHandler(string, http.HandlerFunc)
 }
 
+// NewMessage is the body of POST /message
+type NewMessage struct {
+	Username string `json:"username"`
+	Text     string `json:"text"`
+}
+
+// StatusResponse acknowledges an accepted message
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// Transcript is the conversation of a chat
+type Transcript struct {
+	ChatID   int64            `json:"chat_id"`
+	Messages []models.Message `json:"messages"`
+}
+
 // Router is a service that routes incoming requests
 type RouterImpl struct {
 	logger   Logger
 	database Database
+	notifier Notifier
 }
 
 type Router interface {
+	SetNotifier(n Notifier)
 	PingHandler(w http.ResponseWriter, r *http.Request)
 	MessageHandler(w http.ResponseWriter, r *http.Request)
+	BatchMessagesHandler(w http.ResponseWriter, r *http.Request)
+	TranscriptHandler(w http.ResponseWriter, r *http.Request)
+	ListMessagesHandler(w http.ResponseWriter, r *http.Request)
+	SearchMessagesHandler(w http.ResponseWriter, r *http.Request)
+	GetMessageHandler(w http.ResponseWriter, r *http.Request)
+	UpdateMessageHandler(w http.ResponseWriter, r *http.Request)
+	DeleteMessageHandler(w http.ResponseWriter, r *http.Request)
+	PollResultsHandler(w http.ResponseWriter, r *http.Request)
+	PollExportHandler(w http.ResponseWriter, r *http.Request)
+	WebAppMeHandler(w http.ResponseWriter, r *http.Request)
+	WebAppMessagesHandler(w http.ResponseWriter, r *http.Request)
+	WebAppPostMessageHandler(w http.ResponseWriter, r *http.Request)
 }
 
 // NewRouter creates a new Router
@@ -40,6 +89,18 @@ func NewRouter(l Logger, db Database) (Router, error) {
 	}, nil
 }
 
+// SetNotifier sets who is told about saved messages, nil tells nobody
+func (rt *RouterImpl) SetNotifier(n Notifier) {
+	rt.notifier = n
+}
+
+// messageSaved tells the notifier about a saved message
+func (rt *RouterImpl) messageSaved(ctx context.Context, id int64) {
+	if rt.notifier != nil {
+		rt.notifier.MessageSaved(ctx, id)
+	}
+}
+
 // GET Handler /ping (server check)
 func (rt *RouterImpl) PingHandler(w http.ResponseWriter, r *http.Request) {
 	rt.logger.LogEvent("Ping request received")
@@ -49,14 +110,14 @@ func (rt *RouterImpl) PingHandler(w http.ResponseWriter, r *http.Request) {
 
 // POST Handler /message (receive JSON-message)
 func (rt *RouterImpl) MessageHandler(w http.ResponseWriter, r *http.Request) {
-	var msg struct {
-		Username string `json:"username"`
-		Text     string `json:"text"`
-	}
+	var msg NewMessage
 
 	// Decode JSON-request to struct
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !decodeJSON(w, r, &msg, false) {
+		return
+	}
+	if fields := validateMessage(&msg.Username, &msg.Text); fields != nil {
+		writeValidationError(w, r, fields)
 		return
 	}
 
@@ -65,15 +126,33 @@ func (rt *RouterImpl) MessageHandler(w http.ResponseWriter, r *http.Request) {
 	rt.logger.LogEvent(logString)
 
 	// Saving message to database
-	if err := rt.database.SaveMessage(context.Background(), msg.Username, msg.Text); err != nil {
-		rt.logger.LogEvent("Error while saving message to database: " + err.Error())
-	} else {
-		rt.logger.LogEvent("Message saved successfully")
+	saved, err := rt.database.SaveMessage(r.Context(), msg.Username, msg.Text)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "saving message to database", err)
+		return
 	}
+	rt.logger.LogEvent("Message saved successfully")
+	rt.messageSaved(r.Context(), saved.ID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "received"})
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "received"})
+}
+
+// GET Handler /chats/{chatID}/messages (full transcript of a chat)
+func (rt *RouterImpl) TranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(r.PathValue("chatID"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid chat id")
+		return
+	}
+
+	messages, err := rt.database.GetConversation(r.Context(), chatID)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "getting conversation", err)
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
 
-	//TO DELETE
-	fmt.Println(rt.database.GetMessages(context.Background()))
+	writeJSON(w, http.StatusOK, Transcript{ChatID: chatID, Messages: messages})
 }
@@ -0,0 +1,457 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+// fakeDatabase stores plain messages in memory, saveErr and pingErr simulate failures.
+type fakeDatabase struct {
+	saved   []models.Message
+	saveErr error
+	pingErr error
+	batches int
+	// searched is the filter of the last search
+	searched *models.SearchFilter
+}
+
+func (f *fakeDatabase) Ping() error { return f.pingErr }
+
+func (f *fakeDatabase) SaveMessage(ctx context.Context, username, text string) (models.Message, error) {
+	if f.saveErr != nil {
+		return models.Message{}, f.saveErr
+	}
+	msg := models.Message{ID: int64(len(f.saved) + 1), UserName: username, Text: text}
+	f.saved = append(f.saved, msg)
+	return msg, nil
+}
+
+// ImportMessages keeps the batches only when all of them were read, like the transaction
+func (f *fakeDatabase) ImportMessages(ctx context.Context, next func() ([]models.Message, error)) (int64, error) {
+	var imported []models.Message
+	for {
+		batch, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if f.saveErr != nil {
+			return 0, f.saveErr
+		}
+		f.batches++
+		imported = append(imported, batch...)
+	}
+	f.saved = append(f.saved, imported...)
+	return int64(len(imported)), nil
+}
+
+func (f *fakeDatabase) GetMessages(ctx context.Context) ([]models.Message, error) {
+	return f.saved, nil
+}
+
+func (f *fakeDatabase) GetConversation(ctx context.Context, chatID int64) ([]models.Message, error) {
+	return nil, nil
+}
+
+func (f *fakeDatabase) ListMessages(ctx context.Context, filter models.MessageFilter) (models.MessagePage, error) {
+	return models.MessagePage{Messages: f.saved}, nil
+}
+
+// SearchMessages returns the saved messages containing the first term
+func (f *fakeDatabase) SearchMessages(ctx context.Context, filter models.SearchFilter) (models.SearchPage, error) {
+	if f.saveErr != nil {
+		return models.SearchPage{}, f.saveErr
+	}
+	f.searched = &filter
+	var page models.SearchPage
+	for _, msg := range f.saved {
+		if strings.Contains(msg.Text, filter.Groups[0][0].Text) {
+			page.Hits = append(page.Hits, models.SearchHit{Message: msg, Rank: 0.1, Snippet: "<mark>" + msg.Text + "</mark>"})
+		}
+	}
+	return page, nil
+}
+
+func (f *fakeDatabase) GetMessage(ctx context.Context, id int64) (models.Message, bool, error) {
+	return models.Message{}, false, nil
+}
+
+func (f *fakeDatabase) UpdateMessage(ctx context.Context, id int64, username, text *string) (models.Message, bool, error) {
+	return models.Message{}, false, nil
+}
+
+func (f *fakeDatabase) DeleteMessage(ctx context.Context, id int64) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeDatabase) SaveChatMessage(ctx context.Context, msg models.Message) (int64, error) {
+	return 1, nil
+}
+
+func (f *fakeDatabase) GetPoll(ctx context.Context, pollID string) (models.Poll, bool, error) {
+	return models.Poll{}, false, nil
+}
+
+func (f *fakeDatabase) GetPollResults(ctx context.Context, poll models.Poll) (models.PollResults, error) {
+	return models.PollResults{}, nil
+}
+
+func (f *fakeDatabase) GetPollVotes(ctx context.Context, pollID string) ([]models.PollVote, error) {
+	return nil, nil
+}
+
+func newTestRouter(t *testing.T, db *fakeDatabase) *RouterImpl {
+	t.Helper()
+	rt, err := NewRouter(testLogger{}, db)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	return rt.(*RouterImpl)
+}
+
+func decodeError(t *testing.T, rr *httptest.ResponseRecorder) httperror.ErrorBody {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON error, got content type %q: %s", ct, rr.Body.String())
+	}
+	var body struct {
+		Error httperror.ErrorBody `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body: %v", err)
+	}
+	return body.Error
+}
+
+func TestMessageHandler(t *testing.T) {
+	dbDown := errors.New("connection refused")
+
+	tests := []struct {
+		name    string
+		body    string
+		db      *fakeDatabase
+		status  int
+		code    string
+		fields  []string
+		limited bool
+	}{
+		{name: "ok", body: `{"username":"alice","text":"hi"}`, db: &fakeDatabase{}, status: http.StatusOK},
+		{name: "empty body", body: ``, db: &fakeDatabase{}, status: http.StatusBadRequest, code: httperror.CodeBadRequest},
+		{name: "malformed", body: `{"username":`, db: &fakeDatabase{}, status: http.StatusBadRequest, code: httperror.CodeBadRequest},
+		{name: "invalid utf-8", body: "{\"username\":\"a\xff\",\"text\":\"hi\"}", db: &fakeDatabase{}, status: http.StatusBadRequest, code: httperror.CodeBadRequest},
+		{name: "too large", body: `{"username":"alice","text":"` + strings.Repeat("a", 100) + `"}`, db: &fakeDatabase{}, status: http.StatusRequestEntityTooLarge, code: httperror.CodeTooLarge, limited: true},
+		{name: "missing fields", body: `{}`, db: &fakeDatabase{}, status: http.StatusUnprocessableEntity, code: httperror.CodeValidation, fields: []string{"username", "text"}},
+		{name: "text too long", body: `{"username":"alice","text":"` + strings.Repeat("я", maxTextLength+1) + `"}`, db: &fakeDatabase{}, status: http.StatusUnprocessableEntity, code: httperror.CodeValidation, fields: []string{"text"}},
+		{name: "insert failed", body: `{"username":"alice","text":"hi"}`, db: &fakeDatabase{saveErr: errors.New("constraint")}, status: http.StatusInternalServerError, code: httperror.CodeInternal},
+		{name: "database down", body: `{"username":"alice","text":"hi"}`, db: &fakeDatabase{saveErr: dbDown, pingErr: dbDown}, status: http.StatusServiceUnavailable, code: httperror.CodeUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestRouter(t, tt.db)
+			req := httptest.NewRequest("POST", "/message", strings.NewReader(tt.body))
+			req.Header.Set("X-Request-ID", "req-1")
+			rr := httptest.NewRecorder()
+			if tt.limited {
+				req.Body = http.MaxBytesReader(rr, req.Body, 32)
+			}
+
+			rt.MessageHandler(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			if tt.code == "" {
+				if len(tt.db.saved) != 1 {
+					t.Errorf("expected message to be saved, got %v", tt.db.saved)
+				}
+				return
+			}
+
+			body := decodeError(t, rr)
+			if body.Code != tt.code {
+				t.Errorf("expected code %q, got %q", tt.code, body.Code)
+			}
+			if body.RequestID != "req-1" {
+				t.Errorf("expected request id req-1, got %q", body.RequestID)
+			}
+			if len(body.Fields) != len(tt.fields) {
+				t.Fatalf("expected fields %v, got %v", tt.fields, body.Fields)
+			}
+			for i, field := range tt.fields {
+				if body.Fields[i].Field != field {
+					t.Errorf("expected field %q, got %q", field, body.Fields[i].Field)
+				}
+			}
+		})
+	}
+}
+
+// recordingNotifier remembers the ids of saved messages
+type recordingNotifier struct {
+	ids []int64
+}
+
+func (n *recordingNotifier) MessageSaved(ctx context.Context, id int64) {
+	n.ids = append(n.ids, id)
+}
+
+func TestMessageHandler_NotifiesSavedMessages(t *testing.T) {
+	db := &fakeDatabase{}
+	rt := newTestRouter(t, db)
+	notifier := &recordingNotifier{}
+	rt.SetNotifier(notifier)
+
+	for _, body := range []string{`{"username":"alice","text":"hi"}`, `{"username":"alice"}`} {
+		rt.MessageHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/message", strings.NewReader(body)))
+	}
+	db.saveErr = errors.New("constraint")
+	rt.MessageHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/message", strings.NewReader(`{"username":"bob","text":"hi"}`)))
+
+	if len(notifier.ids) != 1 || notifier.ids[0] != 1 {
+		t.Errorf("expected only message 1 to be announced, got %v", notifier.ids)
+	}
+}
+
+func TestListMessagesHandler_InvalidQuery(t *testing.T) {
+	rt := newTestRouter(t, &fakeDatabase{})
+	rr := httptest.NewRecorder()
+	rt.ListMessagesHandler(rr, httptest.NewRequest("GET", "/messages?chat_id=x&limit=0&order=up", nil))
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d", rr.Code)
+	}
+	body := decodeError(t, rr)
+	if len(body.Fields) != 3 {
+		t.Errorf("expected 3 field errors, got %v", body.Fields)
+	}
+}
+
+func TestBatchMessagesHandler(t *testing.T) {
+	var ndjson strings.Builder
+	for i := range importBatchSize + 1 {
+		ndjson.WriteString(`{"username":"alice","text":"message ` + strconv.Itoa(i) + `"}` + "\n")
+	}
+	ndjson.WriteString("\n" + `{"username":"","text":"hi"}` + "\n" + `{"username":` + "\n")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		received    int
+		inserted    int64
+		batches     int
+		errorLines  []int
+	}{
+		{"json array", "application/json", `[{"username":"alice","text":"hi"},{"username":"bob"},{"username":"carol","text":"hey"}]`, 3, 2, 1, []int{2}},
+		{"no content type", "", `[{"username":"alice","text":"hi"}]`, 1, 1, 1, nil},
+		{"ndjson", "application/x-ndjson", ndjson.String(), importBatchSize + 3, importBatchSize + 1, 2, []int{importBatchSize + 3, importBatchSize + 4}},
+		{"all rejected", "application/x-ndjson", `{"text":"hi"}`, 1, 0, 0, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDatabase{}
+			rt := newTestRouter(t, db)
+			req := httptest.NewRequest("POST", "/messages:batch", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+			rt.BatchMessagesHandler(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			var result BatchResult
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Received != tt.received || result.Inserted != tt.inserted || result.Rejected != len(tt.errorLines) {
+				t.Errorf("unexpected summary %+v", result)
+			}
+			if len(db.saved) != int(tt.inserted) || db.batches != tt.batches {
+				t.Errorf("expected %d messages in %d batches, got %d in %d", tt.inserted, tt.batches, len(db.saved), db.batches)
+			}
+			for i, line := range tt.errorLines {
+				if i >= len(result.Errors) || result.Errors[i].Line != line {
+					t.Errorf("expected line %d rejected, got %+v", line, result.Errors)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchMessagesHandler_History(t *testing.T) {
+	body := `[{"username":"alice","text":"hi","chat_id":42,"message_id":7,"created_at":"2020-01-02T03:04:05Z"},
+		{"username":"bot","text":"hello","chat_id":42,"message_id":8,"reply_to":7,"direction":"out","created_at":"2020-01-02T03:04:06Z"},
+		{"username":"alice","text":"hi","direction":"sideways"},
+		{"username":"alice","text":"hi","message_id":9},
+		{"username":"alice","text":"hi","created_at":"2999-01-01T00:00:00Z"}]`
+	db := &fakeDatabase{}
+	rt := newTestRouter(t, db)
+	rr := httptest.NewRecorder()
+	rt.BatchMessagesHandler(rr, httptest.NewRequest("POST", "/messages:batch", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var result BatchResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Inserted != 2 || result.Rejected != 3 || result.LastLine != 5 {
+		t.Errorf("unexpected summary %+v", result)
+	}
+	if len(db.saved) != 2 {
+		t.Fatalf("expected 2 saved messages, got %+v", db.saved)
+	}
+	reply := db.saved[1]
+	if reply.ChatID != 42 || reply.MessageID != 8 || reply.ReplyTo == nil || *reply.ReplyTo != 7 ||
+		reply.Direction != models.DirectionOutgoing || reply.Status != models.StatusSent ||
+		reply.CreatedAt.Format(time.RFC3339) != "2020-01-02T03:04:06Z" {
+		t.Errorf("expected the history of the reply to be kept, got %+v", reply)
+	}
+	for i, field := range []string{"direction", "chat_id", "created_at"} {
+		if i >= len(result.Errors) || len(result.Errors[i].Fields) != 1 || result.Errors[i].Fields[0].Field != field {
+			t.Errorf("expected line %d rejected for %s, got %+v", i+3, field, result.Errors)
+		}
+	}
+}
+
+// A failing import keeps the committed parts and tells where to resume
+func TestBatchMessagesHandler_Resume(t *testing.T) {
+	committed := importCommitBatches * importBatchSize
+	body := "[" + strings.Repeat(`{"username":"alice","text":"hi"},`, committed+1) + `{"username":`
+
+	db := &fakeDatabase{}
+	rt := newTestRouter(t, db)
+	req := httptest.NewRequest("POST", "/messages:batch", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	rt.BatchMessagesHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var result BatchResult
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Error == nil || result.Error.Code != httperror.CodeBadRequest {
+		t.Errorf("expected the error envelope, got %+v", result.Error)
+	}
+	if result.Inserted != int64(committed) || result.LastLine != committed || len(db.saved) != committed {
+		t.Errorf("expected %d lines committed, got %+v with %d saved", committed, result, len(db.saved))
+	}
+}
+
+func TestBatchMessagesHandler_Errors(t *testing.T) {
+	dbDown := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		db          *fakeDatabase
+		limited     bool
+		status      int
+		code        string
+	}{
+		{"unsupported type", "text/csv", "username,text", &fakeDatabase{}, false, http.StatusUnsupportedMediaType, httperror.CodeUnsupportedMediaType},
+		{"empty", "application/json", "", &fakeDatabase{}, false, http.StatusBadRequest, httperror.CodeBadRequest},
+		{"empty ndjson", "application/x-ndjson", "", &fakeDatabase{}, false, http.StatusBadRequest, httperror.CodeBadRequest},
+		{"not an array", "application/json", `{"username":"alice","text":"hi"}`, &fakeDatabase{}, false, http.StatusBadRequest, httperror.CodeBadRequest},
+		{"broken array", "application/json", `[{"username":"alice","text":"hi"},{"username":`, &fakeDatabase{}, false, http.StatusBadRequest, httperror.CodeBadRequest},
+		{"trailing data", "application/json", `[] []`, &fakeDatabase{}, false, http.StatusBadRequest, httperror.CodeBadRequest},
+		{"too large", "application/x-ndjson", strings.Repeat(`{"username":"alice","text":"hi"}`+"\n", 10), &fakeDatabase{}, true, http.StatusRequestEntityTooLarge, httperror.CodeTooLarge},
+		{"database down", "application/json", `[{"username":"alice","text":"hi"}]`, &fakeDatabase{saveErr: dbDown, pingErr: dbDown}, false, http.StatusServiceUnavailable, httperror.CodeUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestRouter(t, tt.db)
+			req := httptest.NewRequest("POST", "/messages:batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			if tt.limited {
+				req.Body = http.MaxBytesReader(rr, req.Body, 100)
+			}
+			rt.BatchMessagesHandler(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			var resp httperror.ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.Error.Code != tt.code {
+				t.Errorf("expected code %s, got %+v (%v)", tt.code, resp, err)
+			}
+			if len(tt.db.saved) != 0 {
+				t.Errorf("failed imports must not save messages, saved %d", len(tt.db.saved))
+			}
+		})
+	}
+}
+
+func TestSearchMessagesHandler(t *testing.T) {
+	db := &fakeDatabase{saved: []models.Message{
+		{ID: 1, UserName: "alice", Text: "where is my refund"},
+		{ID: 2, UserName: "bob", Text: "thanks"},
+	}}
+	rt := newTestRouter(t, db)
+
+	rr := httptest.NewRecorder()
+	cursor := models.SearchCursor{Rank: 0.1, ID: 20}.Encode()
+	rt.SearchMessagesHandler(rr, httptest.NewRequest("GET", `/messages/search?q=refund+-"gift+card"&chat_id=5&limit=10&cursor=`+cursor, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp SearchResults
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].ID != 1 || resp.Results[0].Snippet != "<mark>where is my refund</mark>" {
+		t.Errorf("unexpected results %+v", resp.Results)
+	}
+
+	want := [][]models.SearchTerm{{{Text: "refund"}, {Text: "gift card", Phrase: true, Negate: true}}}
+	f := db.searched
+	if f == nil || !reflect.DeepEqual(f.Groups, want) || f.ChatID == nil || *f.ChatID != 5 || f.Limit != 10 ||
+		f.After == nil || *f.After != (models.SearchCursor{Rank: 0.1, ID: 20}) {
+		t.Errorf("unexpected filter %+v", f)
+	}
+}
+
+func TestSearchMessagesHandler_Invalid(t *testing.T) {
+	rt := newTestRouter(t, &fakeDatabase{})
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"", "q"},
+		{"q=%22refund", "q"},
+		{"q=" + strings.Repeat("a", maxSearchQueryLength+1), "q"},
+		{"q=refund&cursor=!", "cursor"},
+		{"q=refund&limit=0", "limit"},
+		{"q=refund&from=yesterday", "from"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		rt.SearchMessagesHandler(rr, httptest.NewRequest("GET", "/messages/search?"+tt.query, nil))
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"field":"`+tt.field+`"`) {
+			t.Errorf("%q: expected 422 on %s, got %d: %s", tt.query, tt.field, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"telegram_server/internal/models"
+	"time"
+	"unicode/utf8"
+)
+
+// SearchResults is a page of search results, NextCursor is empty on the last page
+type SearchResults struct {
+	Results    []models.SearchHit `json:"results"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+const maxSearchQueryLength = 256
+
+// parseSearchFilter reads GET /messages/search query parameters: q, username,
+// chat_id, from, to (RFC 3339), limit and cursor.
+func parseSearchFilter(query url.Values) (models.SearchFilter, []FieldError) {
+	var fields []FieldError
+	filter := models.SearchFilter{UserName: query.Get("username")}
+
+	q := query.Get("q")
+	switch {
+	case !utf8.ValidString(q):
+		fields = append(fields, FieldError{Field: "q", Message: "must be valid UTF-8"})
+	case utf8.RuneCountInString(q) > maxSearchQueryLength:
+		fields = append(fields, FieldError{Field: "q", Message: "must be at most " + strconv.Itoa(maxSearchQueryLength) + " characters"})
+	default:
+		groups, err := models.ParseSearchQuery(q)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "q", Message: err.Error()})
+		}
+		filter.Groups = groups
+	}
+	if !utf8.ValidString(filter.UserName) {
+		fields = append(fields, FieldError{Field: "username", Message: "must be valid UTF-8"})
+	}
+
+	if s := query.Get("chat_id"); s != "" {
+		chatID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "chat_id", Message: "must be an integer"})
+		} else {
+			filter.ChatID = &chatID
+		}
+	}
+	for _, name := range []string{"from", "to"} {
+		dst := &filter.From
+		if name == "to" {
+			dst = &filter.To
+		}
+		if s := query.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				fields = append(fields, FieldError{Field: name, Message: "must be an RFC 3339 time"})
+				continue
+			}
+			*dst = t
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			fields = append(fields, FieldError{Field: "limit", Message: "must be a positive integer"})
+		} else {
+			filter.Limit = limit
+		}
+	}
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := models.DecodeSearchCursor(s)
+		if err != nil {
+			fields = append(fields, FieldError{Field: "cursor", Message: "is malformed"})
+		} else {
+			filter.After = &cursor
+		}
+	}
+	return filter, fields
+}
+
+// GET Handler /messages/search (full-text search, best matches first)
+func (rt *RouterImpl) SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, fields := parseSearchFilter(r.URL.Query())
+	if fields != nil {
+		writeValidationError(w, r, fields)
+		return
+	}
+
+	page, err := rt.database.SearchMessages(r.Context(), filter)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "searching messages", err)
+		return
+	}
+
+	resp := SearchResults{Results: page.Hits}
+	if resp.Results == nil {
+		resp.Results = []models.SearchHit{}
+	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.Encode()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
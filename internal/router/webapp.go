@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net/http"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"telegram_server/internal/tgauth"
+)
+
+// Mini App handlers expect the user put into the context by tgauth.InitDataAuth
+
+// WebAppMessage is the body of POST /webapp/messages
+type WebAppMessage struct {
+	Text string `json:"text"`
+}
+
+// CreatedMessage is the answer to a stored message
+type CreatedMessage struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+// GET Handler /webapp/me (authenticated Telegram user)
+func (rt *RouterImpl) WebAppMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := tgauth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// GET Handler /webapp/messages (conversation of the user with the bot)
+func (rt *RouterImpl) WebAppMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := tgauth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	// Private chat with the bot has the same id as the user
+	messages, err := rt.database.GetConversation(r.Context(), user.ID)
+	if err != nil {
+		rt.writeDatabaseError(w, r, "getting Mini App conversation", err)
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	writeJSON(w, http.StatusOK, MessageList{Messages: messages})
+}
+
+// POST Handler /webapp/messages (message posted from the Mini App)
+func (rt *RouterImpl) WebAppPostMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := tgauth.UserFromContext(r.Context())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req WebAppMessage
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	if fields := validateMessage(nil, &req.Text); fields != nil {
+		writeValidationError(w, r, fields)
+		return
+	}
+
+	id, err := rt.database.SaveChatMessage(r.Context(), models.Message{
+		UserName:  user.Username,
+		Text:      req.Text,
+		ChatID:    user.ID,
+		Direction: models.DirectionIncoming,
+		Status:    models.StatusReceived,
+	})
+	if err != nil {
+		rt.writeDatabaseError(w, r, "saving Mini App message", err)
+		return
+	}
+	rt.messageSaved(r.Context(), id)
+
+	writeJSON(w, http.StatusCreated, CreatedMessage{ID: id, Status: "received"})
+}
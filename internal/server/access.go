@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"telegram_server/internal/requestid"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AccessLogConfig sets which requests are written to the access log
+type AccessLogConfig struct {
+	// SampleRate is the share of requests logged, between 0 and 1 where 0
+	// means every request. Server errors are always logged.
+	SampleRate float64
+	// ExcludePaths are never logged, e.g. /healthz polled by probes
+	ExcludePaths []string
+}
+
+// AccessLogEntry is one line of the access log, written as JSON
+type AccessLogEntry struct {
+	RequestID  string  `json:"request_id"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Route      string  `json:"route,omitempty"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RemoteIP   string  `json:"remote_ip"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+func validateAccessLog(cfg *AccessLogConfig) error {
+	if cfg != nil && (cfg.SampleRate < 0 || cfg.SampleRate > 1) {
+		return fmt.Errorf("access log sample rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// requestID gives every request the id of the caller's X-Request-ID header,
+// or a new one when it is missing or unusable, and sends it back
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request.id", id))
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// accessLog writes an AccessLogEntry through logger for the requests selected by cfg
+func accessLog(mux *http.ServeMux, logger Logger, cfg *AccessLogConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg == nil {
+			return next
+		}
+		rate := cfg.SampleRate
+		if rate == 0 {
+			rate = 1
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(cfg.ExcludePaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				status := rec.status
+				switch {
+				case p != nil:
+					status = http.StatusInternalServerError
+				case status == 0:
+					status = http.StatusOK
+				}
+				if status >= 500 || rate == 1 || mathrand.Float64() < rate {
+					logger.LogEvent(accessLogLine(mux, r, status, rec.written, time.Since(start)))
+				}
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+func accessLogLine(mux *http.ServeMux, r *http.Request, status int, written int64, duration time.Duration) string {
+	_, route := mux.Handler(r)
+	id, _ := requestid.FromContext(r.Context())
+	line, _ := json.Marshal(AccessLogEntry{
+		RequestID:  id,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Route:      route,
+		Status:     status,
+		Bytes:      written,
+		DurationMS: float64(duration.Microseconds()) / 1000,
+		RemoteIP:   remoteIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	return string(line)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// RateLimitStore keeps the token buckets of the rate limits. The buckets of
+// MemoryRateLimitStore live in one process, a store shared by the replicas
+// such as the database is needed when there are several.
+type RateLimitStore interface {
+	// TakeRateLimitToken takes a token from the bucket of key, which holds up
+	// to limit tokens and refills completely in window
+	TakeRateLimitToken(ctx context.Context, key string, limit int, window time.Duration) (models.RateLimit, error)
+}
+
+// RateLimitPolicy lets Limit requests per Window from every caller, a caller
+// may use the whole Limit at once
+type RateLimitPolicy struct {
+	// Name keeps the buckets of policies apart, it defaults to the route pattern
+	Name string
+	// Limit is the number of requests allowed per Window, 0 turns the limit off
+	Limit  int
+	Window time.Duration
+	// Key names the caller of a request, it defaults to KeyByIP. Requests with
+	// an empty key are not limited.
+	Key func(r *http.Request) string
+}
+
+// RateLimitConfig sets the rate limits of the routes
+type RateLimitConfig struct {
+	Store RateLimitStore
+	// Default applies to routes without a policy of their own when it is set
+	Default *RateLimitPolicy
+	// Routes sets the policy of the routes with the given patterns
+	Routes map[string]RateLimitPolicy
+}
+
+// RateLimiter takes tokens of requests from the store and refuses requests
+// without one with 429 Too Many Requests. Every limited response carries the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers, refused ones Retry-After too. When the store fails requests are let
+// through, an outage of the store must not take the API down with it.
+type RateLimiter struct {
+	store  RateLimitStore
+	logger Logger
+}
+
+func NewRateLimiter(store RateLimitStore, logger Logger) *RateLimiter {
+	return &RateLimiter{store: store, logger: logger}
+}
+
+func validateRateLimit(cfg *RateLimitConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Store == nil {
+		return fmt.Errorf("rate limit store is required")
+	}
+	if cfg.Default != nil {
+		if err := validatePolicy(*cfg.Default); err != nil {
+			return fmt.Errorf("default rate limit: %w", err)
+		}
+	}
+	for pattern, policy := range cfg.Routes {
+		if err := validatePolicy(policy); err != nil {
+			return fmt.Errorf("rate limit of %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func validatePolicy(policy RateLimitPolicy) error {
+	if policy.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	if policy.Limit > 0 && policy.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	return nil
+}
+
+// KeyByIP names the caller by the IP address of the connection
+func KeyByIP(r *http.Request) string {
+	return "ip:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// Limit applies policy to next. It is meant for handlers that learn who the
+// caller is themselves, e.g. Mini App routes limited per Telegram user.
+func (l *RateLimiter) Limit(policy RateLimitPolicy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if policy.Limit == 0 {
+			next(w, r)
+			return
+		}
+		keyFunc := policy.Key
+		if keyFunc == nil {
+			keyFunc = KeyByIP
+		}
+		key := keyFunc(r)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		name := policy.Name
+		if name == "" {
+			name = r.Pattern
+		}
+
+		rl, err := l.store.TakeRateLimitToken(r.Context(), name+"|"+key, policy.Limit, policy.Window)
+		if err != nil {
+			l.logger.LogEvent("Error while checking rate limit: " + err.Error())
+			next(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(rl.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(rl.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(seconds(rl.Reset)))
+		h.Set("RateLimit-Policy", strconv.Itoa(rl.Limit)+";w="+strconv.Itoa(seconds(rl.Window)))
+		if !rl.Allowed {
+			h.Set("Retry-After", strconv.Itoa(max(1, seconds(rl.RetryAfter))))
+			httperror.Write(w, r, http.StatusTooManyRequests, httperror.CodeTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// seconds rounds d up to whole seconds as the headers want them
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimit applies the policy of the route of every request
+func rateLimit(mux *http.ServeMux, logger Logger, cfg *RateLimitConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg == nil {
+			return next
+		}
+		limiter := NewRateLimiter(cfg.Store, logger)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+			policy, ok := cfg.Routes[pattern]
+			switch {
+			case ok:
+				if policy.Name == "" {
+					policy.Name = pattern
+				}
+			case cfg.Default != nil:
+				policy = *cfg.Default
+				if policy.Name == "" {
+					policy.Name = "default"
+				}
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			limiter.Limit(policy, next.ServeHTTP)(w, r)
+		})
+	}
+}
+
+// MemoryRateLimitStore keeps the buckets in memory, full buckets are dropped
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// sweepInterval is how often full buckets are dropped
+const sweepInterval = time.Minute
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}, now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) TakeRateLimitToken(ctx context.Context, key string, limit int, window time.Duration) (models.RateLimit, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !b.full.After(now) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit), b.tokens+now.Sub(b.updated).Seconds()*float64(limit)/window.Seconds())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	rl := models.NewRateLimit(allowed, b.tokens, limit, window)
+	b.full = now.Add(rl.Reset)
+	return rl, nil
+}
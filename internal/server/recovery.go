@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"telegram_server/internal/requestid"
+	"time"
+)
+
+// ErrorReporter forwards crashes to an error tracking service
+type ErrorReporter interface {
+	Report(ctx context.Context, crash models.Crash) error
+}
+
+// reportTimeout bounds the time a crash report may hold the connection of
+// the request that panicked
+const reportTimeout = 5 * time.Second
+
+// recoverPanics turns a panic in a handler into a JSON 500. The panic and its
+// stack are logged with the request id, counted in m and sent to reporter
+// when they are set. A panic with http.ErrAbortHandler is passed on, it is
+// how handlers abort a response on purpose.
+func recoverPanics(mux *http.ServeMux, logger Logger, m Metrics, reporter ErrorReporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				_, route := mux.Handler(r)
+				id, _ := requestid.FromContext(r.Context())
+				crash := models.Crash{
+					Time:      time.Now().UTC(),
+					RequestID: id,
+					Method:    r.Method,
+					Path:      r.URL.Path,
+					Route:     route,
+					Panic:     fmt.Sprint(p),
+					Stack:     string(debug.Stack()),
+				}
+				logger.LogEvent(fmt.Sprintf("Panic while serving %s %s (request %s): %s\n%s",
+					crash.Method, crash.Path, crash.RequestID, crash.Panic, crash.Stack))
+				if m != nil {
+					m.PanicRecovered(route)
+				}
+
+				// The status line is gone when the handler wrote before panicking,
+				// the connection is cut so the client does not take it for complete
+				started := rec.status != 0
+				if !started {
+					httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+					http.NewResponseController(w).Flush()
+				}
+
+				if reporter != nil {
+					ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), reportTimeout)
+					if err := reporter.Report(ctx, crash); err != nil {
+						logger.LogEvent("Error while reporting panic: " + err.Error())
+					}
+					cancel()
+				}
+				if started {
+					panic(http.ErrAbortHandler)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
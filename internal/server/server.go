@@ -1,12 +1,21 @@
 package server
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"telegram_server/internal/httperror"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NetListener interface {
@@ -20,6 +29,14 @@ type Logger interface {
 	Close()
 }
 
+// Metrics records every request, route is the pattern of the route it matched
+// or empty when it matched none
+type Metrics interface {
+	ObserveRequest(route, method string, status int, duration time.Duration)
+	// PanicRecovered counts handlers that panicked
+	PanicRecovered(route string)
+}
+
 type Config struct {
 	Port           string
 	Logger         Logger
@@ -30,6 +47,20 @@ type Config struct {
 	UseTLS         bool
 	CertFile       string
 	KeyFile        string
+	// BodyLimits overrides MaxBodyBytes for the routes with the given patterns
+	BodyLimits map[string]int
+	// Middleware wraps every route, the first one runs first
+	Middleware []func(http.Handler) http.Handler
+	// Metrics is told about every request when it is set
+	Metrics Metrics
+	// AccessLog writes one line per request through Logger when it is set
+	AccessLog *AccessLogConfig
+	// ErrorReporter is sent the panics of handlers when it is set, they are
+	// logged either way
+	ErrorReporter ErrorReporter
+	// RateLimit limits requests per caller when it is set. It runs after
+	// Middleware so policies can tell callers by their credentials.
+	RateLimit *RateLimitConfig
 }
 
 type HttpServerImpl struct {
@@ -56,6 +87,7 @@ func defaultConfig() Config {
 		ReadTimeout:    15 * time.Second,
 		WriteTimeout:   15 * time.Second,
 		MaxHeaderBytes: 1 << 20,
+		MaxBodyBytes:   1 << 20,
 	}
 }
 
@@ -69,6 +101,20 @@ func validateConfig(cfg Config) error {
 	if cfg.UseTLS && (cfg.CertFile == "" || cfg.KeyFile == "") {
 		return fmt.Errorf("cert and key files are required")
 	}
+	if cfg.MaxBodyBytes < 0 {
+		return fmt.Errorf("max body bytes must not be negative")
+	}
+	if err := validateAccessLog(cfg.AccessLog); err != nil {
+		return err
+	}
+	if err := validateRateLimit(cfg.RateLimit); err != nil {
+		return err
+	}
+	for pattern, limit := range cfg.BodyLimits {
+		if limit <= 0 {
+			return fmt.Errorf("body limit of %s must be positive", pattern)
+		}
+	}
 	return nil
 }
 
@@ -79,13 +125,193 @@ func securityMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("X-Frame-Options", "DENY")
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
 
-		// Проверка размера тела
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
-
 		next.ServeHTTP(w, r)
 	})
 }
 
+// limitBody caps request bodies at maxBytes, or at the limit of the route
+// pattern the request matches when it has one
+func limitBody(mux *http.ServeMux, maxBytes int, limits map[string]int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := maxBytes
+			if len(limits) > 0 {
+				if _, pattern := mux.Handler(r); pattern != "" {
+					if l, ok := limits[pattern]; ok {
+						limit = l
+					}
+				}
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// observe reports the route, status and duration of every request to m
+func observe(mux *http.ServeMux, m Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if m == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			_, pattern := mux.Handler(r)
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				if p := recover(); p != nil {
+					m.ObserveRequest(pattern, r.Method, http.StatusInternalServerError, time.Since(start))
+					panic(p)
+				}
+				status := rec.status
+				if status == 0 {
+					// Nothing was written, net/http answers 200
+					status = http.StatusOK
+				}
+				m.ObserveRequest(pattern, r.Method, status, time.Since(start))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// traceRequests starts a server span for every request, continuing the trace
+// of the caller given in the W3C traceparent header
+func traceRequests(mux *http.ServeMux) func(http.Handler) http.Handler {
+	tracer := otel.Tracer("telegram_server/internal/server")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			_, pattern := mux.Handler(r)
+			name := r.Method
+			if pattern != "" {
+				name = pattern
+			}
+			ctx, span := tracer.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					semconv.UserAgentOriginal(r.UserAgent()),
+				),
+			)
+			if pattern != "" {
+				span.SetAttributes(semconv.HTTPRoute(pattern))
+			}
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				if p := recover(); p != nil {
+					span.SetStatus(codes.Error, fmt.Sprint(p))
+					span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusInternalServerError))
+					span.End()
+					panic(p)
+				}
+				status := rec.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+				if status >= 500 {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+				span.End()
+			}()
+			next.ServeHTTP(rec, r.WithContext(ctx))
+		})
+	}
+}
+
+// statusRecorder remembers the status of a response. The deadlines of
+// http.ResponseController reach the wrapped writer through Unwrap.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	// Informational responses are followed by the final one
+	if s.status == 0 && status >= 200 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.written += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// routeErrors answers requests matching no route (404) or no method of a route (405)
+// with JSON bodies instead of the plain text ones of http.ServeMux.
+func routeErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			w = &jsonErrorWriter{ResponseWriter: w, request: r}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// jsonErrorWriter replaces 404 and 405 bodies written by http.ServeMux with
+// the error envelope used by the handlers.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	request  *http.Request
+	replaced bool
+}
+
+func (j *jsonErrorWriter) WriteHeader(status int) {
+	if status != http.StatusNotFound && status != http.StatusMethodNotAllowed {
+		j.ResponseWriter.WriteHeader(status)
+		return
+	}
+	j.replaced = true
+	code := httperror.CodeNotFound
+	if status == http.StatusMethodNotAllowed {
+		code = httperror.CodeMethodNotAllowed
+	}
+	httperror.Write(j.ResponseWriter, j.request, status, code, strings.ToLower(http.StatusText(status)))
+}
+
+func (j *jsonErrorWriter) Write(b []byte) (int, error) {
+	if j.replaced {
+		return len(b), nil
+	}
+	return j.ResponseWriter.Write(b)
+}
+
+// chain wraps h with middleware so that middleware[0] is the outermost
+func chain(h http.Handler, middleware []func(http.Handler) http.Handler) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
 func NewHttpServer(cfg Config) (HttpServer, error) {
 	if err := validateConfig(cfg); err != nil {
 		return nil, fmt.Errorf("Invalid config %w", err)
@@ -106,6 +332,9 @@ func NewHttpServer(cfg Config) (HttpServer, error) {
 	if cfg.MaxHeaderBytes == 0 {
 		cfg.MaxHeaderBytes = defCfg.MaxHeaderBytes
 	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = defCfg.MaxBodyBytes
+	}
 
 	mux := http.NewServeMux()
 
@@ -117,9 +346,19 @@ func NewHttpServer(cfg Config) (HttpServer, error) {
 		keyFile:  cfg.KeyFile,
 	}
 
+	// Built from the inside out, requests go through securityMiddleware first
+	handler := chain(rateLimit(mux, cfg.Logger, cfg.RateLimit)(routeErrors(mux)), cfg.Middleware)
+	handler = limitBody(mux, cfg.MaxBodyBytes, cfg.BodyLimits)(handler)
+	handler = recoverPanics(mux, cfg.Logger, cfg.Metrics, cfg.ErrorReporter)(handler)
+	handler = observe(mux, cfg.Metrics)(handler)
+	handler = accessLog(mux, cfg.Logger, cfg.AccessLog)(handler)
+	handler = requestID(handler)
+	handler = traceRequests(mux)(handler)
+	handler = securityMiddleware(handler)
+
 	impl.srv = &http.Server{
 		Addr:           ":" + cfg.Port,
-		Handler:        securityMiddleware(mux),
+		Handler:        handler,
 		ReadTimeout:    cfg.ReadTimeout,
 		WriteTimeout:   cfg.WriteTimeout,
 		MaxHeaderBytes: cfg.MaxHeaderBytes,
@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"io"
@@ -17,9 +18,20 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"telegram_server/internal/requestid"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // -----------------------------
@@ -186,6 +198,20 @@ func TestValidateConfig(t *testing.T) {
 		t.Fatalf("expected error 'cert and key files are required', got: %v", err)
 	}
 
+	// A route policy without a window.
+	cfg = Config{
+		Logger: &dummyLogger{},
+		Port:   "8080",
+		RateLimit: &RateLimitConfig{
+			Store:  NewMemoryRateLimitStore(),
+			Routes: map[string]RateLimitPolicy{"POST /message": {Limit: 10}},
+		},
+	}
+	err = validateConfig(cfg)
+	if err == nil || err.Error() != "rate limit of POST /message: window must be positive" {
+		t.Fatalf("expected error 'rate limit of POST /message: window must be positive', got: %v", err)
+	}
+
 	// Valid configuration.
 	cfg = Config{
 		Logger:   &dummyLogger{},
@@ -234,6 +260,347 @@ func TestSecurityMiddleware(t *testing.T) {
 	}
 }
 
+// limitBody applies the route limit when the request matches a pattern with one
+func TestLimitBody(t *testing.T) {
+	mux := http.NewServeMux()
+	read := func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	mux.HandleFunc("POST /small", read)
+	mux.HandleFunc("POST /large", read)
+	h := limitBody(mux, 8, map[string]int{"POST /large": 64})(mux)
+
+	tests := []struct {
+		path   string
+		size   int
+		status int
+	}{
+		{"/small", 8, http.StatusOK},
+		{"/small", 9, http.StatusRequestEntityTooLarge},
+		{"/large", 64, http.StatusOK},
+		{"/large", 65, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", tt.path, strings.NewReader(strings.Repeat("a", tt.size))))
+		if rr.Code != tt.status {
+			t.Errorf("%s with %d bytes: expected %d, got %d", tt.path, tt.size, tt.status, rr.Code)
+		}
+	}
+}
+
+// recordingMetrics keeps the requests reported by observe and the routes that panicked
+type recordingMetrics struct {
+	observed []string
+	panics   []string
+}
+
+func (m *recordingMetrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	m.observed = append(m.observed, method+" "+route+" "+http.StatusText(status))
+}
+
+func (m *recordingMetrics) PanicRecovered(route string) {
+	m.panics = append(m.panics, route)
+}
+
+// observe reports the matched pattern and the status that reached the client
+func TestObserve(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	m := &recordingMetrics{}
+	h := observe(mux, m)(mux)
+
+	for _, path := range []string{"/messages/1", "/messages/0", "/unknown"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to reach net/http")
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}()
+
+	want := []string{
+		"GET GET /messages/{id} OK",
+		"GET GET /messages/{id} Not Found",
+		"GET  Not Found",
+		"GET GET /panic Internal Server Error",
+	}
+	if strings.Join(m.observed, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, m.observed)
+	}
+}
+
+// recordingReporter keeps the crashes given to it
+type recordingReporter struct {
+	crashes []models.Crash
+}
+
+func (r *recordingReporter) Report(ctx context.Context, crash models.Crash) error {
+	r.crashes = append(r.crashes, crash)
+	return nil
+}
+
+// recoverPanics answers with a JSON 500 and reports the crash, responses
+// already started are aborted
+func TestRecoverPanics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook", func(w http.ResponseWriter, r *http.Request) {
+		var from *struct{ UserName string }
+		_ = from.UserName
+	})
+	mux.HandleFunc("GET /export", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("id,text\n"))
+		panic("lost the database")
+	})
+	mux.HandleFunc("GET /abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	logger := &dummyLogger{}
+	m := &recordingMetrics{}
+	reporter := &recordingReporter{}
+	h := requestID(recoverPanics(mux, logger, m, reporter)(mux))
+
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), `"code":"internal_error"`) ||
+		!strings.Contains(rr.Body.String(), `"request_id":"req-1"`) {
+		t.Errorf("expected a JSON 500, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(reporter.crashes) != 1 {
+		t.Fatalf("expected one crash, got %d", len(reporter.crashes))
+	}
+	crash := reporter.crashes[0]
+	if crash.RequestID != "req-1" || crash.Route != "POST /webhook" || !strings.Contains(crash.Panic, "nil pointer") ||
+		!strings.Contains(crash.Stack, "TestRecoverPanics") {
+		t.Errorf("unexpected crash %+v", crash)
+	}
+	if events := logger.Events(); len(events) != 1 || !strings.Contains(events[0], "(request req-1)") ||
+		!strings.Contains(events[0], "goroutine") {
+		t.Errorf("expected the panic and its stack in the log, got %q", events)
+	}
+
+	for _, path := range []string{"/export", "/abort"} {
+		func() {
+			defer func() {
+				if p := recover(); p != http.ErrAbortHandler {
+					t.Errorf("%s: expected the response to be aborted, got %v", path, p)
+				}
+			}()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}()
+	}
+	if strings.Join(m.panics, "|") != "POST /webhook|GET /export" || len(reporter.crashes) != 2 {
+		t.Errorf("expected aborts not to count as crashes, got %q", m.panics)
+	}
+}
+
+// failingStore is a rate limit store that is down
+type failingStore struct{}
+
+func (failingStore) TakeRateLimitToken(ctx context.Context, key string, limit int, window time.Duration) (models.RateLimit, error) {
+	return models.RateLimit{}, errors.New("connection refused")
+}
+
+// rateLimit applies the policy of the route per caller and refills over the window
+func TestRateLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"POST /message", "GET /messages", "GET /healthz"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	cfg := &RateLimitConfig{
+		Store:   store,
+		Default: &RateLimitPolicy{Limit: 100, Window: time.Minute},
+		Routes: map[string]RateLimitPolicy{
+			"POST /message": {Limit: 2, Window: time.Minute, Key: func(r *http.Request) string { return r.Header.Get("X-API-Key") }},
+			"GET /healthz":  {},
+		},
+	}
+	logger := &dummyLogger{}
+	h := rateLimit(mux, logger, cfg)(mux)
+
+	send := func(method, path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, want := range []string{"1", "0"} {
+		if rr := send("POST", "/message", "a"); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != want {
+			t.Fatalf("request %d: expected 200 with %s left, got %d with %q", i, want, rr.Code, rr.Header().Get("RateLimit-Remaining"))
+		}
+	}
+	rr := send("POST", "/message", "a")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), `"code":"too_many_requests"`) {
+		t.Fatalf("expected a JSON 429, got %d %s", rr.Code, rr.Body.String())
+	}
+	wantHeaders := map[string]string{"Retry-After": "30", "RateLimit-Limit": "2", "RateLimit-Remaining": "0",
+		"RateLimit-Reset": "60", "RateLimit-Policy": "2;w=60"}
+	for name, want := range wantHeaders {
+		if got := rr.Header().Get(name); got != want {
+			t.Errorf("expected %s %q, got %q", name, want, got)
+		}
+	}
+
+	// Other callers, routes under the default policy and routes without a limit are not affected
+	if rr := send("POST", "/message", "b"); rr.Code != http.StatusOK {
+		t.Errorf("expected the other key to pass, got %d", rr.Code)
+	}
+	if rr := send("GET", "/messages", "a"); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "100" {
+		t.Errorf("expected the default policy, got %d %q", rr.Code, rr.Header().Get("RateLimit-Limit"))
+	}
+	if rr := send("GET", "/healthz", "a"); rr.Header().Get("RateLimit-Limit") != "" {
+		t.Error("expected /healthz not to be limited")
+	}
+
+	// A token comes back after half a minute, full buckets are dropped
+	now = now.Add(30 * time.Second)
+	if rr := send("POST", "/message", "a"); rr.Code != http.StatusOK {
+		t.Errorf("expected a refilled token, got %d", rr.Code)
+	}
+	now = now.Add(time.Hour)
+	send("POST", "/message", "c")
+	if len(store.buckets) != 1 {
+		t.Errorf("expected only the new bucket to be left, got %d", len(store.buckets))
+	}
+
+	// Requests pass when the store is down
+	cfg.Store = failingStore{}
+	h = rateLimit(mux, logger, cfg)(mux)
+	if rr := send("POST", "/message", "a"); rr.Code != http.StatusOK {
+		t.Errorf("expected the request to pass, got %d", rr.Code)
+	}
+	if events := logger.Events(); len(events) != 1 || !strings.Contains(events[0], "connection refused") {
+		t.Errorf("expected the store error in the log, got %q", events)
+	}
+}
+
+// traceRequests continues the trace of the caller and names spans after the route
+func TestTraceRequests(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	oldProvider, oldPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	mux := http.NewServeMux()
+	var handlerSpan trace.SpanContext
+	mux.HandleFunc("GET /messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	h := traceRequests(mux)(mux)
+
+	req := httptest.NewRequest("GET", "/messages/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /messages/{id}" || span.SpanKind != trace.SpanKindServer {
+		t.Errorf("unexpected span %s of kind %s", span.Name, span.SpanKind)
+	}
+	if span.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the trace of the caller, got trace %s parent %s", span.SpanContext.TraceID(), span.Parent.SpanID())
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Error("expected the handler to run in the request span")
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("expected 503 to mark the span failed, got %v", span.Status)
+	}
+	if spans[1].Name != "GET" || spans[1].Parent.IsValid() {
+		t.Errorf("expected an unmatched request to start a trace named after its method, got %s", spans[1].Name)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	mux := http.NewServeMux()
+	var seenID string
+	mux.HandleFunc("GET /messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		seenID, _ = requestid.FromContext(r.Context())
+		httperror.Write(w, r, http.StatusNotFound, httperror.CodeNotFound, "message not found")
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	logger := &dummyLogger{}
+	h := requestID(accessLog(mux, logger, &AccessLogConfig{ExcludePaths: []string{"/healthz"}})(mux))
+
+	req := httptest.NewRequest("GET", "/messages/7?token=secret", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("User-Agent", "probe/1.0")
+	req.RemoteAddr = "203.0.113.9:5123"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	if seenID != "req-1" || rr.Header().Get("X-Request-ID") != "req-1" || !strings.Contains(rr.Body.String(), `"request_id":"req-1"`) {
+		t.Errorf("expected the caller's id everywhere, handler saw %q, header %q, body %s", seenID, rr.Header().Get("X-Request-ID"), rr.Body.String())
+	}
+	events := logger.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected one access log line, got %q", events)
+	}
+	var entry AccessLogEntry
+	if err := json.Unmarshal([]byte(events[0]), &entry); err != nil {
+		t.Fatalf("access log line is not JSON: %v", err)
+	}
+	want := AccessLogEntry{RequestID: "req-1", Method: "GET", Path: "/messages/7", Route: "GET /messages/{id}",
+		Status: http.StatusNotFound, Bytes: int64(rr.Body.Len()), RemoteIP: "203.0.113.9", UserAgent: "probe/1.0"}
+	entry.DurationMS = 0
+	if entry != want {
+		t.Errorf("expected %+v, got %+v", want, entry)
+	}
+
+	// Unusable ids are replaced, sampling keeps server errors
+	logger = &dummyLogger{}
+	h = requestID(accessLog(mux, logger, &AccessLogConfig{SampleRate: 1e-9})(mux))
+	req = httptest.NewRequest("GET", "/messages/7", nil)
+	req.Header.Set("X-Request-ID", "bad id\nforged line")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	if id := rr.Header().Get("X-Request-ID"); len(id) != 32 || seenID != id {
+		t.Errorf("expected a new id, got %q", id)
+	}
+	if events := logger.Events(); len(events) != 1 || !strings.Contains(events[0], `"status":502`) {
+		t.Errorf("expected only the server error to be logged, got %q", events)
+	}
+}
+
 // Test NewHttpServer using default values and overridden values.
 func TestNewHttpServerDefaults(t *testing.T) {
 	logger := &dummyLogger{}
@@ -496,3 +863,43 @@ func TestHttpServer_TLS(t *testing.T) {
 		t.Fatalf("shutdown TLS server failed: %v", err)
 	}
 }
+
+// Test that unmatched routes and methods get JSON error bodies.
+func TestRouteErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "handler error", http.StatusNotFound)
+	})
+	handler := routeErrors(mux)
+
+	tests := []struct {
+		method, path string
+		status       int
+		contentType  string
+		body         string
+	}{
+		{"GET", "/unknown", http.StatusNotFound, "application/json", "{\"error\":{\"code\":\"not_found\",\"message\":\"not found\"}}\n"},
+		{"POST", "/messages/1", http.StatusMethodNotAllowed, "application/json", "{\"error\":{\"code\":\"method_not_allowed\",\"message\":\"method not allowed\"}}\n"},
+		// Errors written by matched handlers are left alone.
+		{"GET", "/messages/1", http.StatusNotFound, "text/plain; charset=utf-8", "handler error\n"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s %s: expected content type %q, got %q", tt.method, tt.path, tt.contentType, ct)
+		}
+		if rr.Body.String() != tt.body {
+			t.Errorf("%s %s: expected body %q, got %q", tt.method, tt.path, tt.body, rr.Body.String())
+		}
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/messages/1", nil))
+	if allow := rr.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("expected Allow header 'GET, HEAD', got %q", allow)
+	}
+}
@@ -0,0 +1,321 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"telegram_server/internal/tgauth"
+	"time"
+)
+
+// Session manages dashboard logins made with the Telegram Login Widget
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	CreateSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (models.Session, bool, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+}
+
+type Manager interface {
+	RequireRole(role string, next http.HandlerFunc) http.HandlerFunc
+	LoginHandler(w http.ResponseWriter, r *http.Request)
+	LogoutHandler(w http.ResponseWriter, r *http.Request)
+	MeHandler(w http.ResponseWriter, r *http.Request)
+	RunCleanup(ctx context.Context, interval time.Duration)
+}
+
+type Config struct {
+	// Secret signs session cookies
+	Secret     []byte
+	TTL        time.Duration
+	CookieName string
+	Secure     bool
+	// Roles maps Telegram user ids to models.RoleAdmin or models.RoleViewer,
+	// users missing here cannot log in.
+	Roles       map[int64]string
+	LoginWidget tgauth.LoginWidget
+	Logger      Logger
+	Database    Database
+}
+
+type ManagerImpl struct {
+	secret      []byte
+	ttl         time.Duration
+	cookieName  string
+	secure      bool
+	roles       map[int64]string
+	loginWidget tgauth.LoginWidget
+	logger      Logger
+	database    Database
+}
+
+func defaultConfig() Config {
+	return Config{
+		TTL:        12 * time.Hour,
+		CookieName: "session",
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	if cfg.LoginWidget == nil {
+		return fmt.Errorf("login widget is required")
+	}
+	if len(cfg.Secret) < 32 {
+		return fmt.Errorf("secret must be at least 32 bytes")
+	}
+	for id, role := range cfg.Roles {
+		if role != models.RoleAdmin && role != models.RoleViewer {
+			return fmt.Errorf("unknown role %q for user %d", role, id)
+		}
+	}
+	return nil
+}
+
+func NewManager(cfg Config) (Manager, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.TTL == 0 {
+		cfg.TTL = def.TTL
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = def.CookieName
+	}
+
+	return &ManagerImpl{
+		secret:      cfg.Secret,
+		ttl:         cfg.TTL,
+		cookieName:  cfg.CookieName,
+		secure:      cfg.Secure,
+		roles:       cfg.Roles,
+		loginWidget: cfg.LoginWidget,
+		logger:      cfg.Logger,
+		database:    cfg.Database,
+	}, nil
+}
+
+// ParseRoles builds a role map from comma separated lists of Telegram user ids
+func ParseRoles(admins, viewers string) (map[int64]string, error) {
+	roles := make(map[int64]string)
+	for role, list := range map[string]string{models.RoleViewer: viewers, models.RoleAdmin: admins} {
+		for _, s := range strings.Split(list, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid user id %q: %w", s, err)
+			}
+			if roles[id] != models.RoleAdmin {
+				roles[id] = role
+			}
+		}
+	}
+	return roles, nil
+}
+
+// hasRole tells whether a session with role may access endpoints requiring required
+func hasRole(role, required string) bool {
+	return role == required || role == models.RoleAdmin
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sign returns the cookie value for a session id
+func (m *ManagerImpl) sign(id string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the session id from a cookie value if its signature is valid
+func (m *ManagerImpl) verify(value string) (string, bool) {
+	id, sig, found := strings.Cut(value, ".")
+	if !found {
+		return "", false
+	}
+	if !hmac.Equal([]byte(m.sign(id)), []byte(id+"."+sig)) {
+		return "", false
+	}
+	return id, true
+}
+
+// sessionFromRequest loads the session referenced by the request cookie
+func (m *ManagerImpl) sessionFromRequest(r *http.Request) (models.Session, bool, error) {
+	cookie, err := r.Cookie(m.cookieName)
+	if err != nil {
+		return models.Session{}, false, nil
+	}
+	id, ok := m.verify(cookie.Value)
+	if !ok {
+		return models.Session{}, false, nil
+	}
+	return m.database.GetSession(r.Context(), id)
+}
+
+type contextKey struct{}
+
+// FromContext returns the session put into the context by RequireRole
+func FromContext(ctx context.Context) (models.Session, bool) {
+	session, ok := ctx.Value(contextKey{}).(models.Session)
+	return session, ok
+}
+
+// RequireRole lets through requests with a valid session cookie of at least the given role
+func (m *ManagerImpl) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, found, err := m.sessionFromRequest(r)
+		if err != nil {
+			httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+			return
+		}
+		if !found {
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "login required")
+			return
+		}
+		if !hasRole(session.Role, role) {
+			httperror.Write(w, r, http.StatusForbidden, httperror.CodeForbidden, "role "+role+" required")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, session)
+		ctx = tgauth.WithUser(ctx, tgauth.User{ID: session.UserID, Username: session.UserName})
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// GET Handler /auth/telegram (Login Widget callback)
+func (m *ManagerImpl) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := m.loginWidget.Verify(r.URL.Query())
+	if err != nil {
+		m.logger.LogEvent("Login Widget verification failed: " + err.Error())
+		httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "invalid login data")
+		return
+	}
+
+	role, ok := m.roles[user.ID]
+	if !ok {
+		m.logger.LogEvent("Login attempt by user without role: " + strconv.FormatInt(user.ID, 10))
+		httperror.Write(w, r, http.StatusForbidden, httperror.CodeForbidden, "no dashboard role")
+		return
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+		return
+	}
+	session := models.Session{
+		ID:        id,
+		UserID:    user.ID,
+		UserName:  user.Username,
+		Role:      role,
+		ExpiresAt: time.Now().Add(m.ttl),
+	}
+	if err := m.database.CreateSession(r.Context(), session); err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    m.sign(id),
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	m.logger.LogEvent("User " + strconv.FormatInt(user.ID, 10) + " logged in as " + role)
+
+	http.Redirect(w, r, safeRedirect(r.URL.Query().Get("redirect")), http.StatusFound)
+}
+
+// safeRedirect allows only local absolute paths to avoid open redirects
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return "/"
+	}
+	return target
+}
+
+// POST Handler /auth/logout
+func (m *ManagerImpl) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(m.cookieName); err == nil {
+		if id, ok := m.verify(cookie.Value); ok {
+			if err := m.database.DeleteSession(r.Context(), id); err != nil {
+				httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "internal server error")
+				return
+			}
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   m.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET Handler /auth/me (current session), must be wrapped by RequireRole
+func (m *ManagerImpl) MeHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := FromContext(r.Context())
+	if !ok {
+		httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "login required")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// RunCleanup removes expired sessions every interval until ctx is done
+func (m *ManagerImpl) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := m.database.DeleteExpiredSessions(ctx); err != nil {
+				m.logger.LogEvent("Error while deleting expired sessions: " + err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"telegram_server/internal/models"
+	"telegram_server/internal/tgauth"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	events []string
+}
+
+func (l *testLogger) LogEvent(event string) {
+	l.events = append(l.events, event)
+}
+
+// fakeDatabase keeps sessions in memory.
+type fakeDatabase struct {
+	sessions map[string]models.Session
+}
+
+func (f *fakeDatabase) CreateSession(ctx context.Context, session models.Session) error {
+	f.sessions[session.ID] = session
+	return nil
+}
+
+func (f *fakeDatabase) GetSession(ctx context.Context, id string) (models.Session, bool, error) {
+	session, ok := f.sessions[id]
+	if !ok || session.ExpiresAt.Before(time.Now()) {
+		return models.Session{}, false, nil
+	}
+	return session, true, nil
+}
+
+func (f *fakeDatabase) DeleteSession(ctx context.Context, id string) error {
+	delete(f.sessions, id)
+	return nil
+}
+
+func (f *fakeDatabase) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// fakeWidget accepts any callback carrying an id parameter.
+type fakeWidget struct{}
+
+func (fakeWidget) Verify(values url.Values) (tgauth.User, error) {
+	if values.Get("id") == "" {
+		return tgauth.User{}, tgauth.ErrMissingHash
+	}
+	return tgauth.User{ID: 1, Username: values.Get("id")}, nil
+}
+
+func newTestManager(t *testing.T, roles map[int64]string) (*ManagerImpl, *fakeDatabase) {
+	t.Helper()
+	db := &fakeDatabase{sessions: map[string]models.Session{}}
+	m, err := NewManager(Config{
+		Secret:      []byte(strings.Repeat("s", 32)),
+		Roles:       roles,
+		LoginWidget: fakeWidget{},
+		Logger:      &testLogger{},
+		Database:    db,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return m.(*ManagerImpl), db
+}
+
+func login(t *testing.T, m *ManagerImpl, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	m.LoginHandler(rr, httptest.NewRequest(http.MethodGet, "/auth/telegram?"+query, nil))
+	return rr
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles("1, 2", "2,3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if roles[1] != models.RoleAdmin || roles[2] != models.RoleAdmin || roles[3] != models.RoleViewer {
+		t.Errorf("unexpected roles: %v", roles)
+	}
+	if _, err := ParseRoles("abc", ""); err == nil {
+		t.Error("expected error for invalid id")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	m, _ := newTestManager(t, nil)
+	value := m.sign("abc")
+	if id, ok := m.verify(value); !ok || id != "abc" {
+		t.Errorf("expected valid signature, got %q %v", id, ok)
+	}
+	if _, ok := m.verify("abd" + value[3:]); ok {
+		t.Error("expected tampered id to be rejected")
+	}
+}
+
+func TestLoginAndRequireRole(t *testing.T) {
+	m, _ := newTestManager(t, map[int64]string{1: models.RoleViewer})
+
+	rr := login(t, m, "id=ann&redirect=//evil.example")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/" {
+		t.Fatalf("expected redirect to /, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("expected one HttpOnly session cookie, got %v", cookies)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	cases := []struct {
+		role   string
+		cookie bool
+		want   int
+	}{
+		{models.RoleViewer, true, http.StatusOK},
+		{models.RoleAdmin, true, http.StatusForbidden},
+		{models.RoleViewer, false, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/chats/1/messages", nil)
+		if c.cookie {
+			req.AddCookie(cookies[0])
+		}
+		rr := httptest.NewRecorder()
+		m.RequireRole(c.role, ok)(rr, req)
+		if rr.Code != c.want {
+			t.Errorf("role %s cookie %v: expected %d, got %d", c.role, c.cookie, c.want, rr.Code)
+		}
+	}
+}
+
+func TestLogin_UnknownUser(t *testing.T) {
+	m, db := newTestManager(t, map[int64]string{})
+
+	rr := login(t, m, "id=ann")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"code":"forbidden"`) {
+		t.Errorf("expected a JSON 403 for user without role, got %d %s", rr.Code, rr.Body.String())
+	}
+	if len(db.sessions) != 0 {
+		t.Error("expected no session to be created")
+	}
+}
@@ -0,0 +1,66 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// sseSink writes text/event-stream events
+type sseSink struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+}
+
+func (s sseSink) write(format string, args ...any) error {
+	// Long lived responses outlive the server write timeout, every write gets its own.
+	// Writers that do not support deadlines are fine as they are.
+	s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s sseSink) send(msg models.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.write("id: %d\nevent: message\ndata: %s\n\n", msg.ID, data)
+}
+
+func (s sseSink) heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s sseSink) end(slow bool) {
+	code, message := "stream_closed", "stream closed, reconnect to resume"
+	if slow {
+		code, message = "slow_consumer", "client does not keep up with the stream"
+	}
+	s.write("event: error\ndata: {\"code\":%q,\"message\":%q}\n\n", code, message)
+}
+
+// GET Handler /messages/stream (Server-Sent Events of new messages)
+func (h *HubImpl) SSEHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.checkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	out := sseSink{w: w, rc: http.NewResponseController(w), writeTimeout: h.writeTimeout}
+	// EventSource reconnects after retry milliseconds and sends Last-Event-ID
+	if err := out.write("retry: %d\n\n", 3000); err != nil {
+		return
+	}
+	h.serve(r.Context(), req, out)
+}
@@ -0,0 +1,359 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+	"unicode/utf8"
+)
+
+// Stream pushes newly saved messages to HTTP subscribers over Server-Sent Events
+// and WebSocket. Messages are announced by a database trigger with NOTIFY, so
+// messages saved by any replica, through POST /message or the bot webhook alike,
+// reach the subscribers of every replica. Event ids are message ids and clients
+// resume from the last one they received.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	GetMessage(ctx context.Context, id int64) (models.Message, bool, error)
+	GetMessagesSince(ctx context.Context, afterID int64, filter models.MessageStreamFilter, limit int) ([]models.Message, error)
+	ListenMessages(ctx context.Context, listening func(), notify func(id int64)) error
+}
+
+type Hub interface {
+	// Run listens for saved messages until ctx is done
+	Run(ctx context.Context)
+	// Publish delivers msg to the subscribers of this replica
+	Publish(msg models.Message)
+	SSEHandler(w http.ResponseWriter, r *http.Request)
+	WebSocketHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type Config struct {
+	Logger   Logger
+	Database Database
+	// Heartbeat is the interval of keep-alive comments and pings
+	Heartbeat time.Duration
+	// BufferSize is how many messages may wait for a subscriber before it
+	// is disconnected as a slow consumer
+	BufferSize int
+	// ReplayLimit is the page size used to replay missed messages on resume
+	ReplayLimit int
+	// WriteTimeout bounds every write to a subscriber
+	WriteTimeout time.Duration
+}
+
+type HubImpl struct {
+	logger       Logger
+	database     Database
+	heartbeat    time.Duration
+	bufferSize   int
+	replayLimit  int
+	writeTimeout time.Duration
+
+	// listening is set while notifications are received, without them
+	// subscribers would silently miss messages
+	listening atomic.Bool
+
+	mu          sync.Mutex
+	subscribers map[*subscription]struct{}
+}
+
+// subscription receives the published messages matching its filter. The hub
+// closes messages when it drops the subscriber.
+type subscription struct {
+	filter   models.MessageStreamFilter
+	messages chan models.Message
+	// slow is set when the subscriber was dropped for not keeping up
+	slow atomic.Bool
+}
+
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+	maxUserNameLen   = 64
+)
+
+func defaultConfig() Config {
+	return Config{
+		Heartbeat:    15 * time.Second,
+		BufferSize:   64,
+		ReplayLimit:  500,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	if cfg.Heartbeat < 0 || cfg.BufferSize < 0 || cfg.ReplayLimit < 0 || cfg.WriteTimeout < 0 {
+		return fmt.Errorf("durations and sizes must not be negative")
+	}
+	return nil
+}
+
+func NewHub(cfg Config) (Hub, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.Heartbeat == 0 {
+		cfg.Heartbeat = def.Heartbeat
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = def.BufferSize
+	}
+	if cfg.ReplayLimit == 0 {
+		cfg.ReplayLimit = def.ReplayLimit
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = def.WriteTimeout
+	}
+
+	return &HubImpl{
+		logger:       cfg.Logger,
+		database:     cfg.Database,
+		heartbeat:    cfg.Heartbeat,
+		bufferSize:   cfg.BufferSize,
+		replayLimit:  cfg.ReplayLimit,
+		writeTimeout: cfg.WriteTimeout,
+		subscribers:  make(map[*subscription]struct{}),
+	}, nil
+}
+
+// Run keeps a LISTEN connection open and publishes the announced messages.
+// When the connection is lost all subscribers are dropped, they resume from
+// their last event id once it is back.
+func (h *HubImpl) Run(ctx context.Context) {
+	backoff := minListenBackoff
+	for {
+		started := time.Now()
+		err := h.database.ListenMessages(ctx,
+			func() { h.listening.Store(true) },
+			func(id int64) { h.deliver(ctx, id) })
+		h.listening.Store(false)
+		h.closeAll()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > time.Minute {
+			backoff = minListenBackoff
+		}
+		reason := "connection closed"
+		if err != nil {
+			reason = err.Error()
+		}
+		h.logger.LogEvent("Message stream listener stopped (" + reason + "), retrying in " + backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxListenBackoff)
+	}
+}
+
+func (h *HubImpl) deliver(ctx context.Context, id int64) {
+	msg, found, err := h.database.GetMessage(ctx, id)
+	if err != nil {
+		h.logger.LogEvent("Error while loading streamed message " + strconv.FormatInt(id, 10) + ": " + err.Error())
+		return
+	}
+	if found {
+		h.Publish(msg)
+	}
+}
+
+// Publish hands msg to every matching subscriber without blocking, subscribers
+// whose buffer is full are dropped.
+func (h *HubImpl) Publish(msg models.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		if !sub.filter.Matches(msg) {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		default:
+			sub.slow.Store(true)
+			delete(h.subscribers, sub)
+			close(sub.messages)
+			h.logger.LogEvent("Dropped slow message stream subscriber")
+		}
+	}
+}
+
+func (h *HubImpl) subscribe(filter models.MessageStreamFilter) *subscription {
+	sub := &subscription{filter: filter, messages: make(chan models.Message, h.bufferSize)}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *HubImpl) unsubscribe(sub *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.messages)
+	}
+}
+
+func (h *HubImpl) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.messages)
+	}
+}
+
+// streamRequest is what a subscriber asked for
+type streamRequest struct {
+	filter models.MessageStreamFilter
+	// lastID is the last event the client received, resume is false for new clients
+	lastID int64
+	resume bool
+}
+
+// parseStreamRequest reads the filter from the query and the resume point from
+// the Last-Event-ID header or, for clients that cannot set it, the last_event_id
+// query parameter.
+func parseStreamRequest(r *http.Request) (streamRequest, []httperror.FieldError) {
+	var req streamRequest
+	var fields []httperror.FieldError
+	query := r.URL.Query()
+
+	if s := query.Get("chat_id"); s != "" {
+		chatID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fields = append(fields, httperror.FieldError{Field: "chat_id", Message: "must be an integer"})
+		} else {
+			req.filter.ChatID = &chatID
+		}
+	}
+	req.filter.UserName = query.Get("username")
+	if !utf8.ValidString(req.filter.UserName) || utf8.RuneCountInString(req.filter.UserName) > maxUserNameLen {
+		fields = append(fields, httperror.FieldError{Field: "username", Message: "must be valid UTF-8 of at most " + strconv.Itoa(maxUserNameLen) + " characters"})
+	}
+
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = query.Get("last_event_id")
+	}
+	if last != "" {
+		id, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || id < 0 {
+			fields = append(fields, httperror.FieldError{Field: "last_event_id", Message: "must be a message id"})
+		} else {
+			req.lastID, req.resume = id, true
+		}
+	}
+	return req, fields
+}
+
+// checkRequest writes an error and returns false when the stream cannot be served
+func (h *HubImpl) checkRequest(w http.ResponseWriter, r *http.Request) (streamRequest, bool) {
+	req, fields := parseStreamRequest(r)
+	if len(fields) > 0 {
+		httperror.Write(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "invalid query parameters", fields...)
+		return req, false
+	}
+	if !h.listening.Load() {
+		w.Header().Set("Retry-After", "5")
+		httperror.Write(w, r, http.StatusServiceUnavailable, httperror.CodeUnavailable, "message stream is not available")
+		return req, false
+	}
+	return req, true
+}
+
+// sink is one of the stream transports
+type sink interface {
+	send(msg models.Message) error
+	heartbeat() error
+	// end tells the client why the stream ends, slow is set for slow consumers
+	end(slow bool)
+}
+
+// serve replays the messages missed since req.lastID and then forwards the live
+// ones until the client goes away or the subscription is dropped. The
+// subscription is taken before the replay so no message falls in between,
+// live messages already replayed are skipped.
+func (h *HubImpl) serve(ctx context.Context, req streamRequest, out sink) {
+	sub := h.subscribe(req.filter)
+	defer h.unsubscribe(sub)
+
+	replayed := int64(-1)
+	if req.resume {
+		replayed = req.lastID
+		for {
+			messages, err := h.database.GetMessagesSince(ctx, replayed, req.filter, h.replayLimit)
+			if err != nil {
+				out.end(false)
+				return
+			}
+			for _, msg := range messages {
+				if err := out.send(msg); err != nil {
+					return
+				}
+				replayed = msg.ID
+			}
+			if len(messages) < h.replayLimit {
+				break
+			}
+		}
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-sub.messages:
+			if !ok {
+				out.end(sub.slow.Load())
+				return
+			}
+			if msg.ID <= replayed {
+				continue
+			}
+			if err := out.send(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := out.heartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sameOrigin tells whether a browser request comes from a page served by this host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
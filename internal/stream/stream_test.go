@@ -0,0 +1,338 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+// fakeDatabase keeps messages in memory, ids written to notifications are
+// announced by ListenMessages
+type fakeDatabase struct {
+	messages      []models.Message
+	notifications chan int64
+}
+
+func newFakeDatabase(messages ...models.Message) *fakeDatabase {
+	return &fakeDatabase{messages: messages, notifications: make(chan int64)}
+}
+
+func (f *fakeDatabase) GetMessage(ctx context.Context, id int64) (models.Message, bool, error) {
+	for _, msg := range f.messages {
+		if msg.ID == id {
+			return msg, true, nil
+		}
+	}
+	return models.Message{}, false, nil
+}
+
+func (f *fakeDatabase) GetMessagesSince(ctx context.Context, afterID int64, filter models.MessageStreamFilter, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	for _, msg := range f.messages {
+		if msg.ID > afterID && filter.Matches(msg) && len(messages) < limit {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (f *fakeDatabase) ListenMessages(ctx context.Context, listening func(), notify func(id int64)) error {
+	listening()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case id := <-f.notifications:
+			notify(id)
+		}
+	}
+}
+
+func newTestHub(t *testing.T, db *fakeDatabase, cfg Config) *HubImpl {
+	t.Helper()
+	cfg.Logger = testLogger{}
+	cfg.Database = db
+	h, err := NewHub(cfg)
+	if err != nil {
+		t.Fatalf("NewHub: %v", err)
+	}
+	return h.(*HubImpl)
+}
+
+func testMessage(id, chatID int64, username string) models.Message {
+	return models.Message{ID: id, ChatID: chatID, UserName: username, Text: "hello", Direction: models.DirectionIncoming}
+}
+
+func receive(t *testing.T, sub *subscription) (models.Message, bool) {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.messages:
+		return msg, ok
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return models.Message{}, false
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := newTestHub(t, newFakeDatabase(), Config{BufferSize: 1})
+	chatID := int64(1)
+	sub := h.subscribe(models.MessageStreamFilter{ChatID: &chatID})
+	everything := h.subscribe(models.MessageStreamFilter{})
+	defer h.unsubscribe(everything)
+
+	h.Publish(testMessage(1, 2, "alice"))
+	h.Publish(testMessage(2, 1, "alice"))
+	if msg, _ := receive(t, sub); msg.ID != 2 {
+		t.Fatalf("expected message 2, got %d", msg.ID)
+	}
+
+	// A full buffer drops the subscriber instead of blocking the others
+	h.Publish(testMessage(3, 1, "bob"))
+	h.Publish(testMessage(4, 1, "bob"))
+	if msg, _ := receive(t, sub); msg.ID != 3 {
+		t.Fatalf("expected message 3, got %d", msg.ID)
+	}
+	if _, ok := receive(t, sub); ok || !sub.slow.Load() {
+		t.Error("expected slow subscriber to be dropped")
+	}
+	if len(h.subscribers) != 0 {
+		t.Errorf("expected no subscribers left, got %d", len(h.subscribers))
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := newFakeDatabase(testMessage(7, 1, "alice"))
+	h := newTestHub(t, db, Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(done)
+	}()
+
+	for !h.listening.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	sub := h.subscribe(models.MessageStreamFilter{})
+	db.notifications <- 7
+	if msg, _ := receive(t, sub); msg.ID != 7 {
+		t.Fatalf("expected message 7, got %d", msg.ID)
+	}
+
+	cancel()
+	<-done
+	if _, ok := receive(t, sub); ok {
+		t.Error("expected subscribers to be closed when the listener stops")
+	}
+	if h.listening.Load() {
+		t.Error("expected hub to stop listening")
+	}
+}
+
+// readEvent reads one Server-Sent Event, skipping comments
+func readEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	event := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(event) > 0 {
+				return event
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		event[name] = value
+	}
+}
+
+func TestSSEHandler(t *testing.T) {
+	db := newFakeDatabase(testMessage(1, 1, "alice"), testMessage(2, 2, "alice"), testMessage(3, 1, "bob"), testMessage(4, 1, "carol"))
+	h := newTestHub(t, db, Config{ReplayLimit: 1})
+	h.listening.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(h.SSEHandler))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"?chat_id=1", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body := bufio.NewReader(resp.Body)
+
+	if event := readEvent(t, body); event["retry"] == "" {
+		t.Errorf("expected retry hint, got %v", event)
+	}
+	// Missed messages of chat 1 are replayed page by page
+	for _, id := range []string{"3", "4"} {
+		event := readEvent(t, body)
+		if event["id"] != id || event["event"] != "message" {
+			t.Fatalf("expected message %s, got %v", id, event)
+		}
+	}
+
+	// Replayed messages are not sent twice, new ones follow
+	h.Publish(testMessage(4, 1, "carol"))
+	h.Publish(testMessage(5, 2, "dave"))
+	h.Publish(testMessage(6, 1, "erin"))
+	event := readEvent(t, body)
+	if event["id"] != "6" {
+		t.Fatalf("expected message 6, got %v", event)
+	}
+	var msg models.Message
+	if err := json.Unmarshal([]byte(event["data"]), &msg); err != nil || msg.UserName != "erin" {
+		t.Errorf("unexpected data %q: %v", event["data"], err)
+	}
+
+	h.closeAll()
+	if event := readEvent(t, body); event["event"] != "error" {
+		t.Errorf("expected closing error event, got %v", event)
+	}
+}
+
+func TestSSEHandler_Errors(t *testing.T) {
+	h := newTestHub(t, newFakeDatabase(), Config{})
+
+	rr := httptest.NewRecorder()
+	h.SSEHandler(rr, httptest.NewRequest("GET", "/messages/stream", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while not listening, got %d", rr.Code)
+	}
+
+	h.listening.Store(true)
+	rr = httptest.NewRecorder()
+	h.SSEHandler(rr, httptest.NewRequest("GET", "/messages/stream?chat_id=x&last_event_id=-1", nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "chat_id") || !strings.Contains(rr.Body.String(), "last_event_id") {
+		t.Errorf("expected both fields to be reported: %s", rr.Body.String())
+	}
+}
+
+func waitForSubscribers(t *testing.T, h *HubImpl, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.mu.Lock()
+		count := len(h.subscribers)
+		h.mu.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers, got %d", n, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	// Pings go out while the client is not reading yet, the message waits for the pong
+	h := newTestHub(t, newFakeDatabase(), Config{Heartbeat: 10 * time.Millisecond})
+	h.listening.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(h.WebSocketHandler))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/messages/ws?username=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	waitForSubscribers(t, h, 1)
+	time.Sleep(30 * time.Millisecond)
+	h.Publish(testMessage(1, 1, "bob"))
+	h.Publish(testMessage(2, 1, "alice"))
+	typ, payload, err := conn.Read(ctx)
+	var msg models.Message
+	if err != nil || typ != websocket.MessageText || json.Unmarshal(payload, &msg) != nil || msg.ID != 2 {
+		t.Fatalf("expected message 2, got %v %q %v", typ, payload, err)
+	}
+
+	if err := conn.Close(websocket.StatusNormalClosure, ""); err != nil {
+		t.Errorf("expected the server to complete the close handshake, got %v", err)
+	}
+	waitForSubscribers(t, h, 0)
+}
+
+func TestWebSocketHandler_DataMessage(t *testing.T) {
+	h := newTestHub(t, newFakeDatabase(), Config{})
+	h.listening.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(h.WebSocketHandler))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/messages/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	// Clients only send control frames
+	conn.Write(ctx, websocket.MessageText, []byte("hello"))
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+		t.Errorf("expected a policy violation close, got %v", err)
+	}
+}
+
+func TestWebSocketHandler_Rejected(t *testing.T) {
+	h := newTestHub(t, newFakeDatabase(), Config{})
+	h.listening.Store(true)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"no upgrade", map[string]string{}, http.StatusBadRequest},
+		{"old version", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusBadRequest},
+		{"bad key", map[string]string{"Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+		{"cross origin", map[string]string{"Origin": "https://evil.example.com"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/messages/ws", nil)
+		if tt.name != "no upgrade" {
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		}
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		h.WebSocketHandler(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, rr.Code)
+		}
+	}
+}
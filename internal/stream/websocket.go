@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Messages are sent as text messages, heartbeats are pings and clients may
+// only send control frames. The handshake and framing are left to
+// github.com/coder/websocket.
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsSink writes to a websocket connection, the connection keeps messages of
+// the stream and of the read loop apart
+type wsSink struct {
+	conn         *websocket.Conn
+	ctx          context.Context
+	writeTimeout time.Duration
+}
+
+func (s wsSink) send(msg models.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+	return s.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// heartbeat waits for the pong, clients that stop answering are dropped
+func (s wsSink) heartbeat() error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	defer cancel()
+	return s.conn.Ping(ctx)
+}
+
+func (s wsSink) end(slow bool) {
+	if slow {
+		s.conn.Close(websocket.StatusPolicyViolation, "slow consumer")
+		return
+	}
+	s.conn.Close(websocket.StatusServiceRestart, "stream closed, reconnect to resume")
+}
+
+// GET Handler /messages/ws (WebSocket stream of new messages)
+func (h *HubImpl) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "websocket upgrade required")
+		return
+	}
+	// Browsers send cookies with cross-site WebSocket requests, only pages of this host may connect
+	if !sameOrigin(r) {
+		httperror.Write(w, r, http.StatusForbidden, httperror.CodeForbidden, "cross-origin websocket request")
+		return
+	}
+	req, ok := h.checkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Accept answers handshakes it rejects itself
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		h.logger.LogEvent("Error while upgrading to websocket: " + err.Error())
+		return
+	}
+	defer conn.CloseNow()
+
+	// The request context is not canceled for hijacked connections, the read
+	// context ends when the client closes or sends a data message
+	ctx := conn.CloseRead(context.Background())
+	h.serve(ctx, req, wsSink{conn: conn, ctx: ctx, writeTimeout: h.writeTimeout})
+}
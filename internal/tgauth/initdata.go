@@ -0,0 +1,131 @@
+package tgauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"telegram_server/internal/httperror"
+	"time"
+)
+
+// InitData is the launch data Telegram passes to a Mini App
+type InitData struct {
+	QueryID    string
+	User       User
+	AuthDate   time.Time
+	StartParam string
+}
+
+// InitDataAuth authenticates Mini App requests
+type InitDataAuth interface {
+	Validate(initData string) (InitData, error)
+	Middleware(next http.HandlerFunc) http.HandlerFunc
+}
+
+type InitDataConfig struct {
+	BotToken string
+	// MaxAge is how long initData stays valid after auth_date
+	MaxAge time.Duration
+	Logger Logger
+}
+
+type InitDataAuthImpl struct {
+	secret []byte
+	maxAge time.Duration
+	logger Logger
+	now    func() time.Time
+}
+
+// initDataHeader carries raw initData when the Authorization header is not used
+const initDataHeader = "X-Telegram-Init-Data"
+
+func NewInitDataAuth(cfg InitDataConfig) (InitDataAuth, error) {
+	if cfg.Logger == nil {
+		return nil, fmt.Errorf("logger is required")
+	}
+	if cfg.BotToken == "" {
+		return nil, fmt.Errorf("bot token is required")
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 24 * time.Hour
+	}
+
+	// Secret key is HMAC-SHA256 of the bot token keyed by "WebAppData"
+	mac := hmac.New(sha256.New, []byte("WebAppData"))
+	mac.Write([]byte(cfg.BotToken))
+
+	return &InitDataAuthImpl{
+		secret: mac.Sum(nil),
+		maxAge: cfg.MaxAge,
+		logger: cfg.Logger,
+		now:    time.Now,
+	}, nil
+}
+
+// Validate checks the signature and freshness of raw initData and returns its content
+func (a *InitDataAuthImpl) Validate(initData string) (InitData, error) {
+	var data InitData
+
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return data, fmt.Errorf("invalid init data: %w", err)
+	}
+	fields := make(map[string]string, len(values))
+	for k := range values {
+		fields[k] = values.Get(k)
+	}
+
+	if err := checkHash(dataCheckString(fields), a.secret, fields["hash"]); err != nil {
+		return data, err
+	}
+
+	authDate, err := strconv.ParseInt(fields["auth_date"], 10, 64)
+	if err != nil {
+		return data, fmt.Errorf("invalid auth_date: %w", err)
+	}
+	data.AuthDate = time.Unix(authDate, 0)
+	if a.now().Sub(data.AuthDate) > a.maxAge {
+		return data, ErrExpired
+	}
+
+	if fields["user"] == "" {
+		return data, ErrNoUser
+	}
+	if err := json.Unmarshal([]byte(fields["user"]), &data.User); err != nil {
+		return data, fmt.Errorf("invalid user: %w", err)
+	}
+
+	data.QueryID = fields["query_id"]
+	data.StartParam = fields["start_param"]
+	return data, nil
+}
+
+// Middleware rejects requests without valid initData and puts the Telegram user
+// into the request context. initData is taken from "Authorization: tma <initData>"
+// or the X-Telegram-Init-Data header.
+func (a *InitDataAuthImpl) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw := r.Header.Get(initDataHeader)
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "tma ") {
+			raw = strings.TrimPrefix(auth, "tma ")
+		}
+		if raw == "" {
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "Mini App init data required")
+			return
+		}
+
+		data, err := a.Validate(raw)
+		if err != nil {
+			a.logger.LogEvent("Mini App authentication failed: " + err.Error())
+			httperror.Write(w, r, http.StatusUnauthorized, httperror.CodeUnauthorized, "invalid Mini App init data")
+			return
+		}
+
+		next(w, r.WithContext(WithUser(r.Context(), data.User)))
+	}
+}
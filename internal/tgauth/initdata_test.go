@@ -0,0 +1,110 @@
+package tgauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	events []string
+}
+
+func (l *testLogger) LogEvent(event string) {
+	l.events = append(l.events, event)
+}
+
+const testToken = "123456:test-token"
+
+// signInitData builds initData signed the way Telegram does it.
+func signInitData(t *testing.T, token string, authDate time.Time, user string) string {
+	t.Helper()
+	fields := map[string]string{
+		"query_id":  "AAH",
+		"auth_date": strconv.FormatInt(authDate.Unix(), 10),
+		"user":      user,
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(dataCheckString(fields)))
+
+	values := url.Values{}
+	for k, v := range fields {
+		values.Set(k, v)
+	}
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return values.Encode()
+}
+
+func newTestInitDataAuth(t *testing.T) *InitDataAuthImpl {
+	t.Helper()
+	auth, err := NewInitDataAuth(InitDataConfig{BotToken: testToken, MaxAge: time.Hour, Logger: &testLogger{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return auth.(*InitDataAuthImpl)
+}
+
+func TestValidateInitData(t *testing.T) {
+	auth := newTestInitDataAuth(t)
+	user := `{"id":42,"first_name":"Ann","username":"ann"}`
+
+	data, err := auth.Validate(signInitData(t, testToken, time.Now(), user))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data.User.ID != 42 || data.User.Username != "ann" || data.QueryID != "AAH" {
+		t.Errorf("unexpected init data: %+v", data)
+	}
+
+	_, err = auth.Validate(signInitData(t, "other:token", time.Now(), user))
+	if !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("expected ErrInvalidHash for foreign token, got %v", err)
+	}
+
+	_, err = auth.Validate(signInitData(t, testToken, time.Now().Add(-2*time.Hour), user))
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+
+	_, err = auth.Validate("auth_date=1&user=%7B%7D")
+	if !errors.Is(err, ErrMissingHash) {
+		t.Errorf("expected ErrMissingHash, got %v", err)
+	}
+}
+
+func TestInitDataMiddleware(t *testing.T) {
+	auth := newTestInitDataAuth(t)
+	handler := auth.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			t.Error("expected user in context")
+		}
+		w.Write([]byte(strconv.FormatInt(user.ID, 10)))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/webapp/me", nil)
+	req.Header.Set("Authorization", "tma "+signInitData(t, testToken, time.Now(), `{"id":7}`))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "7" {
+		t.Errorf("expected 200 with user 7, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/webapp/me", nil)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), `"code":"unauthorized"`) {
+		t.Errorf("expected a JSON 401 without init data, got %d %s", rr.Code, rr.Body.String())
+	}
+}
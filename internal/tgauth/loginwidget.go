@@ -0,0 +1,82 @@
+package tgauth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LoginWidget verifies callbacks of the Telegram Login Widget
+type LoginWidget interface {
+	Verify(values url.Values) (User, error)
+}
+
+type LoginWidgetConfig struct {
+	BotToken string
+	// MaxAge is how long a login stays valid after auth_date
+	MaxAge time.Duration
+}
+
+type LoginWidgetImpl struct {
+	secret []byte
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// loginWidgetFields are the fields the widget signs
+var loginWidgetFields = []string{"id", "first_name", "last_name", "username", "photo_url", "auth_date"}
+
+func NewLoginWidget(cfg LoginWidgetConfig) (LoginWidget, error) {
+	if cfg.BotToken == "" {
+		return nil, fmt.Errorf("bot token is required")
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 24 * time.Hour
+	}
+
+	// Secret key is SHA256 of the bot token
+	secret := sha256.Sum256([]byte(cfg.BotToken))
+
+	return &LoginWidgetImpl{
+		secret: secret[:],
+		maxAge: cfg.MaxAge,
+		now:    time.Now,
+	}, nil
+}
+
+// Verify checks the hash and auth_date of the widget callback parameters and
+// returns the logged in user.
+func (l *LoginWidgetImpl) Verify(values url.Values) (User, error) {
+	var user User
+
+	fields := make(map[string]string)
+	for _, k := range loginWidgetFields {
+		if values.Has(k) {
+			fields[k] = values.Get(k)
+		}
+	}
+
+	if err := checkHash(dataCheckString(fields), l.secret, values.Get("hash")); err != nil {
+		return user, err
+	}
+
+	authDate, err := strconv.ParseInt(fields["auth_date"], 10, 64)
+	if err != nil {
+		return user, fmt.Errorf("invalid auth_date: %w", err)
+	}
+	if l.now().Sub(time.Unix(authDate, 0)) > l.maxAge {
+		return user, ErrExpired
+	}
+
+	user.ID, err = strconv.ParseInt(fields["id"], 10, 64)
+	if err != nil {
+		return user, ErrNoUser
+	}
+	user.FirstName = fields["first_name"]
+	user.LastName = fields["last_name"]
+	user.Username = fields["username"]
+	user.PhotoURL = fields["photo_url"]
+	return user, nil
+}
@@ -0,0 +1,64 @@
+package tgauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signLoginWidget builds Login Widget callback parameters signed with token.
+func signLoginWidget(token string, authDate time.Time) url.Values {
+	fields := map[string]string{
+		"id":         "42",
+		"first_name": "Ann",
+		"username":   "ann",
+		"auth_date":  strconv.FormatInt(authDate.Unix(), 10),
+	}
+	secret := sha256.Sum256([]byte(token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(dataCheckString(fields)))
+
+	values := url.Values{}
+	for k, v := range fields {
+		values.Set(k, v)
+	}
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return values
+}
+
+func TestLoginWidgetVerify(t *testing.T) {
+	widget, err := NewLoginWidget(LoginWidgetConfig{BotToken: testToken, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	user, err := widget.Verify(signLoginWidget(testToken, time.Now()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != 42 || user.Username != "ann" || user.FirstName != "Ann" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	// Extra parameters such as redirect are not part of the signature.
+	values := signLoginWidget(testToken, time.Now())
+	values.Set("redirect", "/dashboard")
+	if _, err := widget.Verify(values); err != nil {
+		t.Errorf("unexpected error with extra parameter: %v", err)
+	}
+
+	values = signLoginWidget(testToken, time.Now())
+	values.Set("id", "43")
+	if _, err := widget.Verify(values); !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("expected ErrInvalidHash for tampered id, got %v", err)
+	}
+
+	if _, err := widget.Verify(signLoginWidget(testToken, time.Now().Add(-2*time.Hour))); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package tgauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// tgauth verifies data signed by Telegram with the bot token
+
+type Logger interface {
+	LogEvent(string)
+}
+
+// User is a Telegram user authenticated by Telegram signed data
+type User struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+	IsPremium    bool   `json:"is_premium,omitempty"`
+	PhotoURL     string `json:"photo_url,omitempty"`
+}
+
+var (
+	ErrMissingHash = errors.New("hash is missing")
+	ErrInvalidHash = errors.New("hash is invalid")
+	ErrExpired     = errors.New("auth_date is too old")
+	ErrNoUser      = errors.New("user is missing")
+)
+
+type contextKey struct{}
+
+// WithUser returns a copy of ctx carrying user
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext returns the user stored by an authentication middleware
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(contextKey{}).(User)
+	return user, ok
+}
+
+// dataCheckString joins fields sorted by key as "key=value" lines, skipping the hash
+func dataCheckString(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != "hash" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + "=" + fields[k]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkHash compares the hex encoded hash with HMAC-SHA256 of data keyed by secret
+func checkHash(data string, secret []byte, hash string) error {
+	if hash == "" {
+		return ErrMissingHash
+	}
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		return ErrInvalidHash
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrInvalidHash
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// Tracing installs the OpenTelemetry tracer provider used by the server, bot
+// and database packages, they get their tracers from otel.Tracer. Spans are
+// sent to an OTLP/HTTP collector configured with the standard
+// OTEL_EXPORTER_OTLP_* environment variables unless Config.Exporter is set.
+// Incoming and outgoing requests carry the W3C traceparent and baggage headers.
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Tracing interface {
+	// Shutdown sends the spans still buffered and stops the exporter
+	Shutdown(ctx context.Context) error
+}
+
+type Config struct {
+	Logger      Logger
+	ServiceName string
+	// SampleRatio is the share of new traces recorded, traces started by a
+	// caller follow its sampling decision
+	SampleRatio float64
+	// Exporter replaces the OTLP exporter, spans are handed to it as they end.
+	// Tests use tracetest.NewInMemoryExporter.
+	Exporter sdktrace.SpanExporter
+}
+
+type TracingImpl struct {
+	logger   Logger
+	provider *sdktrace.TracerProvider
+}
+
+func defaultConfig() Config {
+	return Config{
+		ServiceName: "telegram-server",
+		SampleRatio: 1,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// SetPropagator makes otel propagate W3C trace context and baggage, it is
+// enough to pass on the trace of callers when tracing is not set up
+func SetPropagator() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// NewTracing creates the exporter and installs the tracer provider and the propagator globally
+func NewTracing(ctx context.Context, cfg Config) (Tracing, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = def.ServiceName
+	}
+	if cfg.SampleRatio == 0 {
+		cfg.SampleRatio = def.SampleRatio
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("could not describe the service: %w", err)
+	}
+
+	var spans sdktrace.TracerProviderOption
+	if cfg.Exporter != nil {
+		spans = sdktrace.WithSyncer(cfg.Exporter)
+	} else {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the OTLP exporter: %w", err)
+		}
+		spans = sdktrace.WithBatcher(exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		spans,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	SetPropagator()
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		cfg.Logger.LogEvent("Error while tracing: " + err.Error())
+	}))
+
+	return &TracingImpl{logger: cfg.Logger, provider: provider}, nil
+}
+
+func (t *TracingImpl) Shutdown(ctx context.Context) error {
+	if err := t.provider.Shutdown(ctx); err != nil {
+		t.logger.LogEvent("Error while shutting down tracing: " + err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+func TestNewTracing(t *testing.T) {
+	oldProvider, oldPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	if _, err := NewTracing(context.Background(), Config{Logger: testLogger{}, SampleRatio: 2}); err == nil {
+		t.Error("expected a sample ratio over 1 to be rejected")
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracing, err := NewTracing(context.Background(), Config{Logger: testLogger{}, ServiceName: "bot-test", Exporter: exporter})
+	if err != nil {
+		t.Fatalf("NewTracing: %v", err)
+	}
+
+	// A span started from an incoming traceparent continues that trace
+	header := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+	_, span := otel.Tracer("test").Start(ctx, "work")
+	span.End()
+
+	out := http.Header{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(out))
+	if out.Get("traceparent") != header.Get("Traceparent") {
+		t.Errorf("expected traceparent to be passed on, got %q", out.Get("traceparent"))
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected one span in the trace of the caller, got %+v", spans)
+	}
+	if name, _ := spans[0].Resource.Set().Value(semconv.ServiceNameKey); name.AsString() != "bot-test" {
+		t.Errorf("expected service bot-test, got %s", name.AsString())
+	}
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"telegram_server/internal/httperror"
+	"telegram_server/internal/models"
+	"unicode/utf8"
+)
+
+// SubscriptionList lists the webhook subscriptions
+type SubscriptionList struct {
+	Subscriptions []models.WebhookSubscription `json:"subscriptions"`
+}
+
+// SubscriptionRequest is the body of POST /admin/webhooks, a secret is generated
+// when none is given
+type SubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// CreatedSubscription is the new subscription with its secret, the secret is not shown again
+type CreatedSubscription struct {
+	Subscription models.WebhookSubscription `json:"subscription"`
+	Secret       string                     `json:"secret"`
+}
+
+// DeliveryList lists webhook deliveries
+type DeliveryList struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// StatusResponse acknowledges a replay
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+const (
+	minSecretLen       = 16
+	maxSecretLen       = 256
+	maxURLLen          = 2048
+	defaultDeadLetters = 50
+	maxDeadLetters     = 500
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func idFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+func validateSubscription(req SubscriptionRequest) []httperror.FieldError {
+	var fields []httperror.FieldError
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(req.URL) > maxURLLen {
+		fields = append(fields, httperror.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	if len(req.Events) == 0 {
+		fields = append(fields, httperror.FieldError{Field: "events", Message: "is required"})
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(Events, event) {
+			fields = append(fields, httperror.FieldError{Field: "events", Message: "unknown event " + strconv.Quote(event)})
+		}
+	}
+	if req.Secret != "" && (!utf8.ValidString(req.Secret) || len(req.Secret) < minSecretLen || len(req.Secret) > maxSecretLen) {
+		fields = append(fields, httperror.FieldError{Field: "secret", Message: "must be " + strconv.Itoa(minSecretLen) + " to " + strconv.Itoa(maxSecretLen) + " bytes"})
+	}
+	return fields
+}
+
+// GET Handler /admin/webhooks (webhook subscriptions)
+func (d *DispatcherImpl) ListSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subs, err := d.database.ListWebhookSubscriptions(r.Context())
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not list webhook subscriptions")
+		return
+	}
+	if subs == nil {
+		subs = []models.WebhookSubscription{}
+	}
+	writeJSON(w, http.StatusOK, SubscriptionList{Subscriptions: subs})
+}
+
+// POST Handler /admin/webhooks (subscribe a URL to events)
+func (d *DispatcherImpl) CreateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var req SubscriptionRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		httperror.Write(w, r, http.StatusBadRequest, httperror.CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if fields := validateSubscription(req); fields != nil {
+		httperror.Write(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "invalid subscription", fields...)
+		return
+	}
+
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not generate secret")
+			return
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	sub, err := d.database.CreateWebhookSubscription(r.Context(), models.WebhookSubscription{
+		URL:    req.URL,
+		Events: slices.Compact(slices.Sorted(slices.Values(req.Events))),
+		Secret: req.Secret,
+	})
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not create webhook subscription")
+		return
+	}
+	d.logger.LogEvent("Webhook subscription " + strconv.FormatInt(sub.ID, 10) + " created for " + sub.URL)
+	writeJSON(w, http.StatusCreated, CreatedSubscription{Subscription: sub, Secret: req.Secret})
+}
+
+// DELETE Handler /admin/webhooks/{id} (remove a subscription and its deliveries)
+func (d *DispatcherImpl) DeleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromPath(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := d.database.DeleteWebhookSubscription(r.Context(), id)
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not delete webhook subscription")
+		return
+	}
+	if !deleted {
+		httperror.Write(w, r, http.StatusNotFound, httperror.CodeNotFound, "webhook subscription not found")
+		return
+	}
+	d.logger.LogEvent("Webhook subscription " + strconv.FormatInt(id, 10) + " deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET Handler /admin/webhooks/dead-letters (deliveries that ran out of attempts)
+func (d *DispatcherImpl) DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultDeadLetters
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxDeadLetters {
+			httperror.Write(w, r, http.StatusUnprocessableEntity, httperror.CodeValidation, "invalid query parameters",
+				httperror.FieldError{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(maxDeadLetters)})
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := d.database.ListWebhookDeliveries(r.Context(), models.DeliveryDead, limit)
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not list webhook deliveries")
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, DeliveryList{Deliveries: deliveries})
+}
+
+// POST Handler /admin/webhooks/deliveries/{id}/replay (send a dead delivery again)
+func (d *DispatcherImpl) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idFromPath(w, r)
+	if !ok {
+		return
+	}
+	replayed, err := d.database.ReplayWebhookDelivery(r.Context(), id)
+	if err != nil {
+		httperror.Write(w, r, http.StatusInternalServerError, httperror.CodeInternal, "could not replay webhook delivery")
+		return
+	}
+	if !replayed {
+		httperror.Write(w, r, http.StatusNotFound, httperror.CodeNotFound, "dead webhook delivery not found")
+		return
+	}
+	d.logger.LogEvent("Webhook delivery " + strconv.FormatInt(id, 10) + " queued for replay")
+	d.notify()
+	writeJSON(w, http.StatusAccepted, StatusResponse{Status: models.DeliveryPending})
+}
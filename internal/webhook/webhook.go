@@ -0,0 +1,348 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"telegram_server/internal/models"
+	"time"
+)
+
+// Webhook notifies subscriber URLs of events. Events are queued in the database
+// by the code saving messages and sent by Run, failed deliveries are retried with
+// exponential backoff and end in the dead letters when they run out of attempts.
+//
+// Every request carries the headers
+//
+//	X-Webhook-ID         delivery id, the same on every attempt
+//	X-Webhook-Event      event type
+//	X-Webhook-Timestamp  unix time of the attempt
+//	X-Webhook-Signature  sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+
+type Logger interface {
+	LogEvent(string)
+}
+
+type Database interface {
+	GetMessage(ctx context.Context, id int64) (models.Message, bool, error)
+	CreateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (models.WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id int64) (bool, error)
+	EnqueueWebhookDeliveries(ctx context.Context, event string, payload []byte) (int, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64) error
+	FailWebhookDelivery(ctx context.Context, id int64, lastError string, retryAt *time.Time) error
+	ListWebhookDeliveries(ctx context.Context, status string, limit int) ([]models.WebhookDelivery, error)
+	ReplayWebhookDelivery(ctx context.Context, id int64) (bool, error)
+}
+
+type Dispatcher interface {
+	// MessageSaved queues message.created for the message with the given id
+	MessageSaved(ctx context.Context, id int64)
+	// DeliverDue sends the deliveries that are due
+	DeliverDue(ctx context.Context) error
+	Run(ctx context.Context)
+	ListSubscriptionsHandler(w http.ResponseWriter, r *http.Request)
+	CreateSubscriptionHandler(w http.ResponseWriter, r *http.Request)
+	DeleteSubscriptionHandler(w http.ResponseWriter, r *http.Request)
+	DeadLettersHandler(w http.ResponseWriter, r *http.Request)
+	ReplayHandler(w http.ResponseWriter, r *http.Request)
+}
+
+type Config struct {
+	Logger     Logger
+	Database   Database
+	HTTPClient *http.Client
+	// MaxAttempts is the number of attempts before a delivery is dead
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failure, it doubles up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PollInterval is how often due deliveries are looked for
+	PollInterval time.Duration
+	// BatchSize is the number of deliveries sent at once
+	BatchSize int
+}
+
+type DispatcherImpl struct {
+	logger         Logger
+	database       Database
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	pollInterval   time.Duration
+	batchSize      int
+	now            func() time.Time
+	// wake makes Run look for deliveries before the next poll
+	wake chan struct{}
+}
+
+// Event is the body of every delivery
+type Event struct {
+	// ID identifies the event, receivers use it to drop duplicates
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Events lists the event types subscriptions may ask for
+var Events = []string{models.EventMessageCreated}
+
+// Request headers
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+func defaultConfig() Config {
+	return Config{
+		HTTPClient:     &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:    8,
+		InitialBackoff: 30 * time.Second,
+		MaxBackoff:     6 * time.Hour,
+		PollInterval:   5 * time.Second,
+		BatchSize:      20,
+	}
+}
+
+func validateConfig(cfg Config) error {
+	if cfg.Logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	if cfg.Database == nil {
+		return fmt.Errorf("database is required")
+	}
+	if cfg.MaxAttempts < 0 || cfg.BatchSize < 0 || cfg.InitialBackoff < 0 || cfg.MaxBackoff < 0 || cfg.PollInterval < 0 {
+		return fmt.Errorf("durations and sizes must not be negative")
+	}
+	return nil
+}
+
+func NewDispatcher(cfg Config) (Dispatcher, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	def := defaultConfig()
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = def.HTTPClient
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.InitialBackoff == 0 {
+		cfg.InitialBackoff = def.InitialBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = def.MaxBackoff
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = def.PollInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+
+	return &DispatcherImpl{
+		logger:         cfg.Logger,
+		database:       cfg.Database,
+		client:         cfg.HTTPClient,
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		pollInterval:   cfg.PollInterval,
+		batchSize:      cfg.BatchSize,
+		now:            time.Now,
+		wake:           make(chan struct{}, 1),
+	}, nil
+}
+
+// Sign returns the X-Webhook-Signature value of a request
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
+
+// MessageSaved is called after a message is saved. Failures are logged, they
+// never fail saving the message.
+func (d *DispatcherImpl) MessageSaved(ctx context.Context, id int64) {
+	// The event is queued even when the request that saved the message is gone
+	ctx = context.WithoutCancel(ctx)
+
+	msg, found, err := d.database.GetMessage(ctx, id)
+	if err != nil {
+		d.logger.LogEvent("Error while loading message " + strconv.FormatInt(id, 10) + " for webhooks: " + err.Error())
+		return
+	}
+	if !found {
+		// Deleted right after it was saved, there is nothing to announce
+		return
+	}
+	if err := d.enqueue(ctx, models.EventMessageCreated, msg); err != nil {
+		d.logger.LogEvent("Error while queueing " + models.EventMessageCreated + " webhooks: " + err.Error())
+	}
+}
+
+func (d *DispatcherImpl) enqueue(ctx context.Context, eventType string, data any) error {
+	eventID, err := newEventID()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Event{ID: eventID, Type: eventType, CreatedAt: d.now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	queued, err := d.database.EnqueueWebhookDeliveries(ctx, eventType, payload)
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		d.notify()
+	}
+	return nil
+}
+
+// notify wakes Run without blocking
+func (d *DispatcherImpl) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until ctx is done
+func (d *DispatcherImpl) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			d.logger.LogEvent("Error while delivering webhooks: " + err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue sends due deliveries in batches until none are left
+func (d *DispatcherImpl) DeliverDue(ctx context.Context) error {
+	// Claimed deliveries are hidden from other replicas until the attempt is over
+	lease := 2 * d.client.Timeout
+	if lease == 0 {
+		lease = time.Minute
+	}
+
+	for ctx.Err() == nil {
+		deliveries, err := d.database.ClaimWebhookDeliveries(ctx, d.batchSize, lease)
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < d.batchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// deliver makes one attempt and records its outcome
+func (d *DispatcherImpl) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	err := d.send(ctx, delivery)
+	if err == nil {
+		d.database.CompleteWebhookDelivery(context.WithoutCancel(ctx), delivery.ID)
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down, the attempt does not count and is made again once the lease ends
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	var retryAt *time.Time
+	if attempts < d.maxAttempts {
+		next := d.now().Add(d.backoff(attempts))
+		retryAt = &next
+	} else {
+		d.logger.LogEvent("Webhook delivery " + strconv.FormatInt(delivery.ID, 10) + " to " + delivery.URL +
+			" is dead after " + strconv.Itoa(attempts) + " attempts: " + err.Error())
+	}
+	d.database.FailWebhookDelivery(context.WithoutCancel(ctx), delivery.ID, err.Error(), retryAt)
+}
+
+// backoff is the wait after the given number of failed attempts, with up to 10%
+// jitter so retries of many deliveries spread out
+func (d *DispatcherImpl) backoff(attempts int) time.Duration {
+	wait := d.maxBackoff
+	if attempts < 32 {
+		wait = min(d.initialBackoff<<(attempts-1), d.maxBackoff)
+	}
+	if jitter := int64(wait / 10); jitter > 0 {
+		wait += time.Duration(mathrand.Int64N(jitter))
+	}
+	return wait
+}
+
+func (d *DispatcherImpl) send(ctx context.Context, delivery models.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "telegram-server-webhooks")
+	req.Header.Set(HeaderID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"telegram_server/internal/models"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) LogEvent(string) {}
+
+// fakeDatabase keeps subscriptions and deliveries in memory, deliveries are due
+// once their next attempt is not after now
+type fakeDatabase struct {
+	mu         sync.Mutex
+	now        time.Time
+	messages   map[int64]models.Message
+	subs       []models.WebhookSubscription
+	deliveries []models.WebhookDelivery
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{now: time.Now(), messages: map[int64]models.Message{}}
+}
+
+func (f *fakeDatabase) GetMessage(ctx context.Context, id int64) (models.Message, bool, error) {
+	msg, ok := f.messages[id]
+	return msg, ok, nil
+}
+
+func (f *fakeDatabase) CreateWebhookSubscription(ctx context.Context, sub models.WebhookSubscription) (models.WebhookSubscription, error) {
+	sub.ID = int64(len(f.subs) + 1)
+	sub.CreatedAt = f.now
+	f.subs = append(f.subs, sub)
+	return sub, nil
+}
+
+func (f *fakeDatabase) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return f.subs, nil
+}
+
+func (f *fakeDatabase) DeleteWebhookSubscription(ctx context.Context, id int64) (bool, error) {
+	n := len(f.subs)
+	f.subs = slices.DeleteFunc(f.subs, func(s models.WebhookSubscription) bool { return s.ID == id })
+	return len(f.subs) < n, nil
+}
+
+func (f *fakeDatabase) EnqueueWebhookDeliveries(ctx context.Context, event string, payload []byte) (int, error) {
+	queued := 0
+	for _, sub := range f.subs {
+		if slices.Contains(sub.Events, event) {
+			f.deliveries = append(f.deliveries, models.WebhookDelivery{
+				ID: int64(len(f.deliveries) + 1), SubscriptionID: sub.ID, URL: sub.URL, Secret: sub.Secret,
+				Event: event, Payload: payload, Status: models.DeliveryPending, NextAttemptAt: f.now,
+			})
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+func (f *fakeDatabase) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var claimed []models.WebhookDelivery
+	for i, d := range f.deliveries {
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(f.now) && len(claimed) < limit {
+			f.deliveries[i].NextAttemptAt = f.now.Add(lease)
+			claimed = append(claimed, d)
+		}
+	}
+	return claimed, nil
+}
+
+func (f *fakeDatabase) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := &f.deliveries[id-1]
+	d.Status, d.LastError = models.DeliverySucceeded, ""
+	d.Attempts++
+	return nil
+}
+
+func (f *fakeDatabase) FailWebhookDelivery(ctx context.Context, id int64, lastError string, retryAt *time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := &f.deliveries[id-1]
+	d.Attempts++
+	d.LastError = lastError
+	if retryAt == nil {
+		d.Status = models.DeliveryDead
+	} else {
+		d.NextAttemptAt = *retryAt
+	}
+	return nil
+}
+
+func (f *fakeDatabase) ListWebhookDeliveries(ctx context.Context, status string, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	for _, d := range f.deliveries {
+		if d.Status == status {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeDatabase) ReplayWebhookDelivery(ctx context.Context, id int64) (bool, error) {
+	if id < 1 || id > int64(len(f.deliveries)) || f.deliveries[id-1].Status != models.DeliveryDead {
+		return false, nil
+	}
+	d := &f.deliveries[id-1]
+	d.Status, d.Attempts, d.LastError, d.NextAttemptAt = models.DeliveryPending, 0, "", f.now
+	return true, nil
+}
+
+func newTestDispatcher(t *testing.T, db *fakeDatabase, cfg Config) *DispatcherImpl {
+	t.Helper()
+	cfg.Logger = testLogger{}
+	cfg.Database = db
+	d, err := NewDispatcher(cfg)
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	impl := d.(*DispatcherImpl)
+	impl.now = func() time.Time { return db.now }
+	return impl
+}
+
+// receiver is a subscriber endpoint that checks signatures and answers with status
+type receiver struct {
+	t      *testing.T
+	secret string
+	status atomic.Int32
+	events chan Event
+}
+
+func newReceiver(t *testing.T, secret string) (*receiver, *httptest.Server) {
+	rec := &receiver{t: t, secret: secret, events: make(chan Event, 10)}
+	rec.status.Store(http.StatusOK)
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if r.Header.Get(HeaderSignature) != Sign(rec.secret, timestamp, body) {
+		rec.t.Errorf("bad signature %q", r.Header.Get(HeaderSignature))
+	}
+	if r.Header.Get(HeaderEvent) != models.EventMessageCreated || r.Header.Get(HeaderID) == "" {
+		rec.t.Errorf("missing headers: %v", r.Header)
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		rec.t.Errorf("invalid body %s: %v", body, err)
+	}
+	rec.events <- event
+	w.WriteHeader(int(rec.status.Load()))
+}
+
+func TestMessageSaved(t *testing.T) {
+	db := newFakeDatabase()
+	db.messages[42] = models.Message{ID: 42, UserName: "alice", Text: "hi"}
+	secret := "0123456789abcdef"
+	rec, srv := newReceiver(t, secret)
+	db.CreateWebhookSubscription(context.Background(), models.WebhookSubscription{URL: srv.URL, Events: []string{models.EventMessageCreated}, Secret: secret})
+	d := newTestDispatcher(t, db, Config{})
+
+	d.MessageSaved(context.Background(), 42)
+	select {
+	case <-d.wake:
+	default:
+		t.Error("expected queued delivery to wake the dispatcher")
+	}
+	if err := d.DeliverDue(context.Background()); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	event := <-rec.events
+	data, _ := event.Data.(map[string]any)
+	if event.Type != models.EventMessageCreated || !strings.HasPrefix(event.ID, "evt_") || data["id"] != float64(42) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if db.deliveries[0].Status != models.DeliverySucceeded {
+		t.Errorf("expected delivery to succeed, got %+v", db.deliveries[0])
+	}
+}
+
+func TestDeliverDue_RetriesAndDeadLetters(t *testing.T) {
+	db := newFakeDatabase()
+	db.messages[1] = models.Message{ID: 1, UserName: "bob", Text: "hello"}
+	secret := "fedcba9876543210"
+	rec, srv := newReceiver(t, secret)
+	rec.status.Store(http.StatusInternalServerError)
+	db.CreateWebhookSubscription(context.Background(), models.WebhookSubscription{URL: srv.URL, Events: []string{models.EventMessageCreated}, Secret: secret})
+	d := newTestDispatcher(t, db, Config{MaxAttempts: 3, InitialBackoff: time.Minute})
+	ctx := context.Background()
+
+	d.MessageSaved(ctx, 1)
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err := d.DeliverDue(ctx); err != nil {
+			t.Fatalf("DeliverDue: %v", err)
+		}
+		<-rec.events
+		delivery := db.deliveries[0]
+		if delivery.Attempts != attempt || !strings.Contains(delivery.LastError, "500") {
+			t.Fatalf("attempt %d: unexpected delivery %+v", attempt, delivery)
+		}
+		if attempt < 3 {
+			// The wait doubles, with up to 10% jitter
+			wait := delivery.NextAttemptAt.Sub(db.now)
+			base := time.Minute << (attempt - 1)
+			if wait < base || wait >= base+base/10 {
+				t.Errorf("attempt %d: expected a wait of about %s, got %s", attempt, base, wait)
+			}
+			// Nothing is due before the wait is over
+			d.DeliverDue(ctx)
+			if db.deliveries[0].Attempts != attempt {
+				t.Fatalf("delivery retried too early")
+			}
+			db.now = delivery.NextAttemptAt
+		}
+	}
+	if db.deliveries[0].Status != models.DeliveryDead {
+		t.Fatalf("expected dead delivery, got %+v", db.deliveries[0])
+	}
+
+	rr := httptest.NewRecorder()
+	d.DeadLettersHandler(rr, httptest.NewRequest("GET", "/admin/webhooks/dead-letters", nil))
+	var list DeliveryList
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list.Deliveries) != 1 {
+		t.Fatalf("expected one dead letter, got %d: %+v", rr.Code, list)
+	}
+
+	// A replay starts over once the receiver is fixed
+	rec.status.Store(http.StatusNoContent)
+	req := httptest.NewRequest("POST", "/admin/webhooks/deliveries/1/replay", nil)
+	req.SetPathValue("id", "1")
+	rr = httptest.NewRecorder()
+	d.ReplayHandler(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	d.DeliverDue(ctx)
+	<-rec.events
+	if db.deliveries[0].Status != models.DeliverySucceeded {
+		t.Errorf("expected replayed delivery to succeed, got %+v", db.deliveries[0])
+	}
+
+	rr = httptest.NewRecorder()
+	d.ReplayHandler(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 replaying a delivered event, got %d", rr.Code)
+	}
+}
+
+func TestCreateSubscriptionHandler(t *testing.T) {
+	db := newFakeDatabase()
+	d := newTestDispatcher(t, db, Config{})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"malformed", `{"url":`, http.StatusBadRequest},
+		{"unknown field", `{"url":"https://crm.example.com/hook","events":["message.created"],"extra":1}`, http.StatusBadRequest},
+		{"relative url", `{"url":"/hook","events":["message.created"]}`, http.StatusUnprocessableEntity},
+		{"no events", `{"url":"https://crm.example.com/hook","events":[]}`, http.StatusUnprocessableEntity},
+		{"unknown event", `{"url":"https://crm.example.com/hook","events":["message.deleted"]}`, http.StatusUnprocessableEntity},
+		{"short secret", `{"url":"https://crm.example.com/hook","events":["message.created"],"secret":"abc"}`, http.StatusUnprocessableEntity},
+		{"valid", `{"url":"https://crm.example.com/hook","events":["message.created","message.created"]}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		d.CreateSubscriptionHandler(rr, httptest.NewRequest("POST", "/admin/webhooks", strings.NewReader(tt.body)))
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	if len(db.subs) != 1 {
+		t.Fatalf("expected one subscription, got %d", len(db.subs))
+	}
+	if sub := db.subs[0]; len(sub.Secret) != 64 || len(sub.Events) != 1 {
+		t.Errorf("expected generated secret and deduplicated events, got %+v", sub)
+	}
+
+	// The secret is never listed
+	rr := httptest.NewRecorder()
+	d.ListSubscriptionsHandler(rr, httptest.NewRequest("GET", "/admin/webhooks", nil))
+	if strings.Contains(rr.Body.String(), db.subs[0].Secret) {
+		t.Error("secret leaked in subscription list")
+	}
+}